@@ -0,0 +1,140 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// 同一工作站名稱出現第二條連線時的處理方式
+const (
+	DuplicateAllow   = "allow"   // 允許多條連線（原本的行為）
+	DuplicateReplace = "replace" // 關閉舊連線，保留新連線
+	DuplicateReject  = "reject"  // 拒絕新連線
+)
+
+// AdmissionPolicy TPT 連線的准入條件
+type AdmissionPolicy struct {
+	Allow                []*net.IPNet // 允許的來源（空表示不限制）
+	Deny                 []*net.IPNet // 拒絕的來源（優先於 Allow）
+	MaxConnections       int          // 同時連線數上限（0 表示不限制）
+	DuplicateWorkStation string       // allow/replace/reject
+}
+
+// ParseCIDRList 解析以逗號分隔的 CIDR 或 IP 位址清單（單一 IP 視為 /32 或 /128）
+func ParseCIDRList(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR: %s", item)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ParseDuplicatePolicy 驗證重複工作站的處理方式
+func ParseDuplicatePolicy(policy string) (string, error) {
+	switch strings.ToLower(policy) {
+	case "", DuplicateAllow:
+		return DuplicateAllow, nil
+	case DuplicateReplace:
+		return DuplicateReplace, nil
+	case DuplicateReject:
+		return DuplicateReject, nil
+	default:
+		return "", fmt.Errorf("invalid duplicate workstation policy %q (allow, replace or reject)", policy)
+	}
+}
+
+// SetAdmissionPolicy 設定連線准入條件（需在 Start 之前呼叫）
+func (s *TCPServer) SetAdmissionPolicy(policy AdmissionPolicy) {
+	s.admission = policy
+}
+
+// matchCIDR 回傳第一個包含 ip 的網段
+func matchCIDR(nets []*net.IPNet, ip net.IP) *net.IPNet {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return n
+		}
+	}
+	return nil
+}
+
+// admitLocked 檢查新連線是否可接受，拒絕時回傳原因（呼叫端需持有 clientsMu）
+func (s *TCPServer) admitLocked(conn net.Conn) string {
+	policy := s.admission
+
+	var ip net.IP
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+
+	if ip != nil {
+		if n := matchCIDR(policy.Deny, ip); n != nil {
+			return fmt.Sprintf("address matches denylist %s", n)
+		}
+		if len(policy.Allow) > 0 && matchCIDR(policy.Allow, ip) == nil {
+			return "address is not in allowlist"
+		}
+	}
+
+	if policy.MaxConnections > 0 && len(s.clients) >= policy.MaxConnections {
+		return fmt.Sprintf("connection limit reached (%d)", policy.MaxConnections)
+	}
+	return ""
+}
+
+// claimWorkStation 收到 LINK 時登記連線的工作站名稱，並依設定處理同名的其他連線
+// 回傳 false 表示此連線應被拒絕
+func (s *TCPServer) claimWorkStation(client *tcpClient, name string) bool {
+	if name == "" {
+		return true
+	}
+
+	s.clientsMu.Lock()
+	var existing []*tcpClient
+	for _, other := range s.clients {
+		if other != client && other.workStation == name {
+			existing = append(existing, other)
+		}
+	}
+
+	policy := s.admission.DuplicateWorkStation
+	if len(existing) > 0 && policy == DuplicateReject {
+		s.clientsMu.Unlock()
+		logTCP.Warn("LINK rejected: workstation is already connected",
+			"remote", client.id, "work_station", name, "existing", existing[0].id)
+		return false
+	}
+
+	client.workStation = name
+	s.clientsMu.Unlock()
+
+	if len(existing) > 0 && policy == DuplicateReplace {
+		for _, other := range existing {
+			logTCP.Info("workstation reconnected, closing previous connection",
+				"work_station", name, "remote", client.id, "previous", other.id)
+			other.conn.Close()
+		}
+	}
+	return true
+}
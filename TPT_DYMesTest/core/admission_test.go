@@ -0,0 +1,108 @@
+package core
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseCIDRList(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"blank items", " , ,", nil, false},
+		{"cidr", "10.0.0.0/8", []string{"10.0.0.0/8"}, false},
+		{"host bits cleared", "192.168.1.77/24", []string{"192.168.1.0/24"}, false},
+		{"single ipv4", "192.168.1.5", []string{"192.168.1.5/32"}, false},
+		{"single ipv6", "::1", []string{"::1/128"}, false},
+		{"mixed with spaces", "10.0.0.0/8, 172.16.0.1 ,fd00::/8", []string{"10.0.0.0/8", "172.16.0.1/32", "fd00::/8"}, false},
+		{"invalid ip", "10.0.0.256", nil, true},
+		{"invalid prefix", "10.0.0.0/33", nil, true},
+		{"hostname", "tpt-01.local", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nets, err := ParseCIDRList(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, n := range nets {
+				got = append(got, n.String())
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDuplicatePolicy(t *testing.T) {
+	for input, want := range map[string]string{"": DuplicateAllow, "allow": DuplicateAllow, "Replace": DuplicateReplace, "REJECT": DuplicateReject} {
+		if got, err := ParseDuplicatePolicy(input); err != nil || got != want {
+			t.Fatalf("ParseDuplicatePolicy(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseDuplicatePolicy("kick"); err == nil {
+		t.Fatal("expected an error for an unknown policy")
+	}
+}
+
+// addrConn 只提供遠端位址的連線（准入判斷只看位址）
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.addr }
+
+func TestAdmitLocked(t *testing.T) {
+	cidrs := func(list string) []*net.IPNet {
+		nets, err := ParseCIDRList(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nets
+	}
+
+	tests := []struct {
+		name    string
+		policy  AdmissionPolicy
+		remote  string
+		clients int
+		want    string // 拒絕原因的片段（空字串表示接受）
+	}{
+		{"no policy", AdmissionPolicy{}, "203.0.113.9", 100, ""},
+		{"allowlist hit", AdmissionPolicy{Allow: cidrs("192.168.1.0/24")}, "192.168.1.20", 0, ""},
+		{"allowlist miss", AdmissionPolicy{Allow: cidrs("192.168.1.0/24")}, "192.168.2.20", 0, "not in allowlist"},
+		{"denylist", AdmissionPolicy{Deny: cidrs("10.0.0.0/8")}, "10.1.2.3", 0, "denylist 10.0.0.0/8"},
+		{"deny before allow", AdmissionPolicy{Allow: cidrs("10.0.0.0/8"), Deny: cidrs("10.0.0.66")}, "10.0.0.66", 0, "denylist"},
+		{"ipv6 allowlist", AdmissionPolicy{Allow: cidrs("fd00::/8")}, "fd00::20", 0, ""},
+		{"ipv4-mapped ipv6", AdmissionPolicy{Allow: cidrs("192.168.1.0/24")}, "::ffff:192.168.1.20", 0, ""},
+		{"below limit", AdmissionPolicy{MaxConnections: 2}, "192.168.1.20", 1, ""},
+		{"limit reached", AdmissionPolicy{MaxConnections: 2}, "192.168.1.20", 2, "connection limit reached (2)"},
+		{"denylist before limit", AdmissionPolicy{Deny: cidrs("192.168.1.20"), MaxConnections: 2}, "192.168.1.20", 5, "denylist"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewTCPServer(0, NewStateManager(1))
+			server.SetAdmissionPolicy(tt.policy)
+			for i := 0; i < tt.clients; i++ {
+				conn := addrConn{addr: &net.TCPAddr{IP: net.IPv4(198, 51, 100, byte(i)), Port: 1000 + i}}
+				server.clients[conn] = &tcpClient{conn: conn}
+			}
+
+			conn := addrConn{addr: &net.TCPAddr{IP: net.ParseIP(tt.remote), Port: 50000}}
+			got := server.admitLocked(conn)
+			if (got == "") != (tt.want == "") || !strings.Contains(got, tt.want) {
+				t.Fatalf("admitLocked = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,648 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// APIPrefix 版本化 REST API 的路徑前綴
+const APIPrefix = "/api/v1"
+
+// 功能未啟用時的錯誤
+var (
+	errTemplatesDisabled = newError(ErrFeatureDisabled, nil, "Templates are not enabled")
+	errSchedulerDisabled = newError(ErrFeatureDisabled, nil, "Scheduler is not enabled")
+	errRulesDisabled     = newError(ErrFeatureDisabled, nil, "Rules engine is not enabled")
+)
+
+// APIError 統一的錯誤內容
+type APIError struct {
+	Code    string                 `json:"code"`              // 機器可讀的錯誤代碼，例如 not_found
+	Message string                 `json:"message"`           // 錯誤訊息
+	Details map[string]interface{} `json:"details,omitempty"` // 額外資訊，例如 channel、state
+}
+
+// APIErrorResponse 錯誤回應（所有 /api/v1 錯誤都使用此格式）
+type APIErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// StatusResponse 不需回傳資料的操作結果
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// CommandResponse 單一命令的發送結果
+type CommandResponse struct {
+	Status string `json:"status"`
+	MsgID  string `json:"msg_id,omitempty"` // 命令的 msg_id（可用於對應 command_result 事件）
+}
+
+// UserCommandResponse 自訂命令的發送結果
+type UserCommandResponse struct {
+	Status string                 `json:"status"`
+	Sent   map[string]interface{} `json:"sent"`            // 實際送出的內容
+	Reply  map[string]interface{} `json:"reply,omitempty"` // wait_reply 時收到的回覆
+}
+
+// errorStatus 依錯誤分類決定 HTTP 狀態碼
+func errorStatus(err error) int {
+	status, _ := errorCode(err)
+	return status
+}
+
+// errorCode 依錯誤分類決定 HTTP 狀態碼與錯誤代碼
+func errorCode(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrInvalidRequest):
+		return http.StatusBadRequest, "invalid_request"
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized, "unauthorized"
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden, "forbidden"
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound, "not_found"
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict, "conflict"
+	case errors.Is(err, ErrStateConflict):
+		return http.StatusConflict, "state_conflict"
+	case errors.Is(err, ErrTPTOffline):
+		return http.StatusServiceUnavailable, "tpt_offline"
+	case errors.Is(err, ErrFeatureDisabled):
+		return http.StatusServiceUnavailable, "feature_disabled"
+	case errors.Is(err, ErrReplyTimeout):
+		return http.StatusGatewayTimeout, "reply_timeout"
+	case errors.Is(err, ErrSendFailed):
+		return http.StatusBadGateway, "send_failed"
+	default:
+		return http.StatusInternalServerError, "internal_error"
+	}
+}
+
+// withDetails 為錯誤加上細節欄位（保留原本的分類）
+func withDetails(err error, details map[string]interface{}) error {
+	merged := make(map[string]interface{})
+	for k, v := range ErrorDetails(err) {
+		merged[k] = v
+	}
+	for k, v := range details {
+		merged[k] = v
+	}
+	return &classifiedError{kind: err, message: err.Error(), details: merged}
+}
+
+// writeJSON 寫出 JSON 回應
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError 寫出統一格式的錯誤回應
+func writeAPIError(w http.ResponseWriter, err error) {
+	status, code := errorCode(err)
+	if status == http.StatusInternalServerError {
+		logHTTP.Error("internal error", "error", err)
+	}
+	writeJSON(w, status, APIErrorResponse{Error: APIError{
+		Code:    code,
+		Message: err.Error(),
+		Details: ErrorDetails(err),
+	}})
+}
+
+// decodeBody 解析 JSON 請求內容（未知欄位視為錯誤，避免拼錯欄位名稱時默默忽略）
+func decodeBody(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return newError(ErrInvalidRequest, nil, "Invalid request body: %v", err)
+	}
+	return nil
+}
+
+// canonicalChannelID 將 "CH005"、"ch005"、"5" 轉為 "CH005"
+func canonicalChannelID(id string) (string, error) {
+	n, err := parseChannelNumber(id)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("CH%03d", n), nil
+}
+
+// apiHandler 回傳結果或錯誤，由 handleAPIv1 統一寫出
+type apiHandler func(r *http.Request, params map[string]string) (interface{}, error)
+
+// apiParam OpenAPI 查詢參數說明
+type apiParam struct {
+	Name        string
+	Description string
+	Type        string // string/integer/boolean
+}
+
+// apiRoute /api/v1 路由（同時作為 OpenAPI 文件的來源）
+type apiRoute struct {
+	Method   string
+	Path     string // 例如 /channels/{id}（不含 APIPrefix）
+	Summary  string
+	Tag      string
+	Query    []apiParam
+	Request  interface{}      // 請求內容的範例型別（nil 表示沒有請求內容）
+	Response interface{}      // 回應內容的範例型別
+	Status   int              // 成功時的狀態碼（預設 200）
+	Handler  apiHandler       // JSON 處理函式
+	Stream   http.HandlerFunc // 串流處理函式（SSE 等非 JSON 回應）
+}
+
+// match 比對路徑，成功時回傳路徑參數
+func (route apiRoute) match(path string) (map[string]string, bool) {
+	pattern := strings.Split(strings.Trim(route.Path, "/"), "/")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(pattern) != len(segments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, p := range pattern {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[p[1:len(p)-1]] = segments[i]
+			continue
+		}
+		if p != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// handleAPIv1 依路由表分派 /api/v1 請求
+func (s *HTTPServer) handleAPIv1(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, APIPrefix)
+
+	var allowed []string
+	for _, route := range s.apiRoutes {
+		params, ok := route.match(path)
+		if !ok {
+			continue
+		}
+		if route.Method != r.Method {
+			allowed = append(allowed, route.Method)
+			continue
+		}
+
+		if route.Stream != nil {
+			route.Stream(w, r)
+			return
+		}
+
+		result, err := route.Handler(r, params)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		writeJSON(w, status, result)
+		return
+	}
+
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeJSON(w, http.StatusMethodNotAllowed, APIErrorResponse{Error: APIError{
+			Code:    "method_not_allowed",
+			Message: fmt.Sprintf("Method %s not allowed", r.Method),
+			Details: map[string]interface{}{"allowed": allowed},
+		}})
+		return
+	}
+
+	writeAPIError(w, newError(ErrNotFound, nil, "No route for %s %s", r.Method, r.URL.Path))
+}
+
+// apiV1Routes 建立 /api/v1 路由表
+func (s *HTTPServer) apiV1Routes() []apiRoute {
+	routes := []apiRoute{
+		{Method: http.MethodGet, Path: "/status", Tag: "status", Summary: "取得 TCP 與 TPT 連線狀態",
+			Response: map[string]interface{}{}, Handler: s.apiGetStatus},
+		{Method: http.MethodGet, Path: "/snapshot", Tag: "status", Summary: "取得狀態快照（含最後事件序號）",
+			Response: map[string]interface{}{}, Handler: s.apiGetSnapshot},
+		{Method: http.MethodGet, Path: "/events", Tag: "status", Summary: "Server-Sent Events 事件串流",
+			Stream: s.handleEvents},
+		{Method: http.MethodGet, Path: "/events/history", Tag: "status", Summary: "匯出伺服器保留的最近廣播事件",
+			Query: []apiParam{
+				{Name: "since", Description: "只回傳序號大於此值的事件", Type: "integer"},
+				{Name: "channels", Description: "通道選擇器，例如 CH001-CH032", Type: "string"},
+				{Name: "types", Description: "訊息或事件類型，以逗號分隔", Type: "string"},
+				{Name: "directions", Description: "TPT->MES 或 MES->TPT", Type: "string"},
+				{Name: "work_stations", Description: "工作站名稱，以逗號分隔", Type: "string"},
+			},
+			Response: EventHistoryPage{}, Handler: s.apiGetEventHistory},
+		{Method: http.MethodGet, Path: "/connections", Tag: "status", Summary: "列出 TPT 連線",
+			Response: []ClientInfo{}, Handler: s.apiGetConnections},
+		{Method: http.MethodGet, Path: "/audit", Tag: "audit", Summary: "查詢操作稽核記錄（由新到舊）",
+			Query: []apiParam{
+				{Name: "user", Description: "使用者或 Token 名稱", Type: "string"},
+				{Name: "channel", Description: "通道", Type: "string"},
+				{Name: "msg_id", Description: "命令的 msg_id", Type: "string"},
+				{Name: "outcome", Description: "accepted 或 rejected", Type: "string"},
+				{Name: "since", Description: "起始時間（RFC 3339）", Type: "string"},
+				{Name: "until", Description: "結束時間（RFC 3339）", Type: "string"},
+				{Name: "limit", Description: "最多回傳筆數（預設 100）", Type: "integer"},
+			},
+			Response: []AuditRecord{}, Handler: s.apiGetAudit},
+		{Method: http.MethodGet, Path: "/whoami", Tag: "status", Summary: "取得目前請求者的身分與角色",
+			Response: WhoAmIResponse{}, Handler: s.apiWhoAmI},
+		{Method: http.MethodGet, Path: "/channels", Tag: "channels", Summary: "列出通道狀態（篩選與分頁）",
+			Query: []apiParam{
+				{Name: "state", Description: "狀態，以逗號分隔多個值，例如 Running,Paused", Type: "string"},
+				{Name: "barcode_prefix", Description: "條碼前綴", Type: "string"},
+				{Name: "process", Description: "製程名稱", Type: "string"},
+				{Name: "offset", Description: "略過的筆數", Type: "integer"},
+				{Name: "limit", Description: "最多回傳筆數（0 或省略表示不限制）", Type: "integer"},
+			},
+			Response: ChannelPage{}, Handler: s.apiGetChannels},
+		{Method: http.MethodGet, Path: "/channels/{id}", Tag: "channels", Summary: "取得單一通道詳細資訊（最後訊息、狀態變更時間、測試記錄、等待中命令）",
+			Response: ChannelDetail{}, Handler: s.apiGetChannel},
+	}
+
+	for _, cmd := range []string{CmdStart, CmdStop, CmdPause, CmdResume, CmdRspStatus} {
+		route := apiRoute{
+			Method:   http.MethodPost,
+			Path:     "/commands/" + strings.ToLower(cmd),
+			Tag:      "commands",
+			Summary:  fmt.Sprintf("發送 %s 命令", cmd),
+			Response: CommandResponse{},
+			Handler:  s.apiSendCommand(cmd),
+		}
+		if cmd != CmdRspStatus {
+			route.Request = CommandRequest{}
+		}
+		routes = append(routes, route)
+	}
+
+	routes = append(routes, []apiRoute{
+		{Method: http.MethodPost, Path: "/commands/user_command", Tag: "commands", Summary: "發送自訂命令",
+			Request: UserCommandRequest{}, Response: UserCommandResponse{}, Handler: s.apiSendUserCommand},
+		{Method: http.MethodPost, Path: "/commands/batch", Tag: "commands", Summary: "對多個通道發送批次命令",
+			Request: BatchCommandRequest{}, Response: BatchSummary{}, Handler: s.apiSendBatch},
+
+		{Method: http.MethodGet, Path: "/schedules", Tag: "schedules", Summary: "列出排程",
+			Response: []Schedule{}, Handler: s.apiListSchedules},
+		{Method: http.MethodPost, Path: "/schedules", Tag: "schedules", Summary: "建立排程",
+			Request: ScheduleRequest{}, Response: Schedule{}, Status: http.StatusCreated, Handler: s.apiCreateSchedule},
+		{Method: http.MethodDelete, Path: "/schedules/{id}", Tag: "schedules", Summary: "取消排程",
+			Response: StatusResponse{}, Handler: s.apiCancelSchedule},
+
+		{Method: http.MethodGet, Path: "/rules", Tag: "rules", Summary: "列出規則",
+			Response: []Rule{}, Handler: s.apiListRules},
+		{Method: http.MethodPost, Path: "/rules", Tag: "rules", Summary: "新增規則",
+			Request: Rule{}, Response: Rule{}, Status: http.StatusCreated, Handler: s.apiCreateRule},
+		{Method: http.MethodPut, Path: "/rules/{id}", Tag: "rules", Summary: "啟用或停用規則",
+			Request: RuleToggleRequest{}, Response: StatusResponse{}, Handler: s.apiToggleRule},
+		{Method: http.MethodDelete, Path: "/rules/{id}", Tag: "rules", Summary: "刪除規則",
+			Response: StatusResponse{}, Handler: s.apiDeleteRule},
+
+		{Method: http.MethodGet, Path: "/templates", Tag: "templates", Summary: "列出自訂命令範本",
+			Response: []CommandTemplate{}, Handler: s.apiListTemplates},
+		{Method: http.MethodPost, Path: "/templates", Tag: "templates", Summary: "新增或覆寫自訂命令範本",
+			Request: CommandTemplate{}, Response: StatusResponse{}, Status: http.StatusCreated, Handler: s.apiSaveTemplate},
+		{Method: http.MethodDelete, Path: "/templates/{name}", Tag: "templates", Summary: "刪除自訂命令範本",
+			Response: StatusResponse{}, Handler: s.apiDeleteTemplate},
+
+		{Method: http.MethodPost, Path: "/raw", Tag: "raw", Summary: "將原始封包寫入 TPT 連線",
+			Request: RawInjectRequest{}, Response: RawInjectResponse{}, Handler: s.apiInjectRaw},
+		{Method: http.MethodPost, Path: "/raw/fuzz", Tag: "raw", Summary: "以變異後的命令測試 TPT 的封包解析",
+			Request: FuzzRequest{}, Response: FuzzResponse{}, Handler: s.apiFuzz},
+
+		{Method: http.MethodGet, Path: "/logging", Tag: "meta", Summary: "取得日誌設定",
+			Response: LogConfig{}, Handler: s.apiGetLogging},
+		{Method: http.MethodPut, Path: "/logging", Tag: "meta", Summary: "調整日誌等級與 Hex dump（需 admin 權限）",
+			Request: LogSettingsRequest{}, Response: LogConfig{}, Handler: s.apiUpdateLogging},
+		{Method: http.MethodGet, Path: "/openapi.json", Tag: "meta", Summary: "OpenAPI 文件",
+			Response: map[string]interface{}{}, Handler: s.apiGetOpenAPI},
+	}...)
+
+	return routes
+}
+
+// apiGetStatus GET /api/v1/status
+func (s *HTTPServer) apiGetStatus(r *http.Request, params map[string]string) (interface{}, error) {
+	return s.stateManager.GetConnectionStatus(), nil
+}
+
+// apiGetSnapshot GET /api/v1/snapshot
+func (s *HTTPServer) apiGetSnapshot(r *http.Request, params map[string]string) (interface{}, error) {
+	s.wsClientsMu.Lock()
+	seq := s.eventSeq
+	s.wsClientsMu.Unlock()
+
+	snapshot, err := encodeEvent(s.currentState(), seq)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(snapshot), nil
+}
+
+// apiGetEventHistory GET /api/v1/events/history
+func (s *HTTPServer) apiGetEventHistory(r *http.Request, params map[string]string) (interface{}, error) {
+	query := r.URL.Query()
+	filter, err := compileFilter(EventFilterFromQuery(query), s.stateManager.ChannelCount())
+	if err != nil {
+		return nil, err
+	}
+	var since uint64
+	if value := query.Get("since"); value != "" {
+		if since, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, newError(ErrInvalidRequest, nil, "invalid since: %s", value)
+		}
+	}
+	return s.recentEvents(since, filter), nil
+}
+
+// apiGetAudit GET /api/v1/audit
+func (s *HTTPServer) apiGetAudit(r *http.Request, params map[string]string) (interface{}, error) {
+	return s.queryAudit(r)
+}
+
+// apiWhoAmI GET /api/v1/whoami
+func (s *HTTPServer) apiWhoAmI(r *http.Request, params map[string]string) (interface{}, error) {
+	return s.whoami(r), nil
+}
+
+// apiGetConnections GET /api/v1/connections
+func (s *HTTPServer) apiGetConnections(r *http.Request, params map[string]string) (interface{}, error) {
+	return s.tcpServer.GetClients(), nil
+}
+
+// ChannelView /api/v1 回傳的通道資訊
+// ChannelState 沒有 JSON 標籤，舊版 /api/channels 與 WebSocket 事件沿用 ChannelID 等欄位名稱，
+// /api/v1 則與 ChannelDetail 一致使用 snake_case
+type ChannelView struct {
+	ChannelID string `json:"channel_id"`
+	State     string `json:"state"`
+	Barcode   string `json:"barcode,omitempty"`
+	Process   string `json:"process,omitempty"`
+	DataPath  string `json:"data_path,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// newChannelViews 將通道狀態轉為 /api/v1 的回應格式
+func newChannelViews(channels []ChannelState) []ChannelView {
+	views := make([]ChannelView, len(channels))
+	for i, ch := range channels {
+		views[i] = ChannelView(ch)
+	}
+	return views
+}
+
+// ChannelPage 通道列表分頁結果
+type ChannelPage struct {
+	Total    int           `json:"total"` // 符合條件的總筆數
+	Offset   int           `json:"offset"`
+	Limit    int           `json:"limit"`
+	Channels []ChannelView `json:"channels"`
+}
+
+// apiGetChannels GET /api/v1/channels
+func (s *HTTPServer) apiGetChannels(r *http.Request, params map[string]string) (interface{}, error) {
+	query, err := ChannelQueryFromURL(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	channels, total := s.stateManager.QueryChannels(query)
+	return ChannelPage{Total: total, Offset: query.Offset, Limit: query.Limit, Channels: newChannelViews(channels)}, nil
+}
+
+// apiGetChannel GET /api/v1/channels/{id}
+func (s *HTTPServer) apiGetChannel(r *http.Request, params map[string]string) (interface{}, error) {
+	return s.channelDetail(params["id"])
+}
+
+// apiSendCommand POST /api/v1/commands/{start|stop|pause|resume|rsp_status}
+func (s *HTTPServer) apiSendCommand(cmdType string) apiHandler {
+	return func(r *http.Request, params map[string]string) (interface{}, error) {
+		cmd := Command{Type: cmdType}
+		if cmdType != CmdRspStatus {
+			var req CommandRequest
+			if err := decodeBody(r, &req); err != nil {
+				return nil, err
+			}
+			if req.Channel == "" {
+				return nil, newError(ErrInvalidRequest, nil, "Missing channel field")
+			}
+			channelID, err := canonicalChannelID(req.Channel)
+			if err != nil {
+				return nil, err
+			}
+			cmd.Channel = channelID
+			cmd.Barcode = req.Barcode
+			cmd.Process = req.Process
+			cmd.DataPath = req.DataPath
+		}
+
+		msgID, err := s.stateManager.SendCommand(cmd)
+		if err != nil {
+			return nil, err
+		}
+		return CommandResponse{Status: "ok", MsgID: msgID}, nil
+	}
+}
+
+// apiSendUserCommand POST /api/v1/commands/user_command
+func (s *HTTPServer) apiSendUserCommand(r *http.Request, params map[string]string) (interface{}, error) {
+	var req UserCommandRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	timeout, err := req.replyTimeout()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := s.buildUserPayload(req)
+	if err != nil {
+		return nil, err
+	}
+
+	sent, reply, err := s.stateManager.SendUserCommandPayload(payload, timeout)
+	if err != nil {
+		if sent != nil {
+			err = withDetails(err, map[string]interface{}{"sent": sent})
+		}
+		return nil, err
+	}
+
+	resp := UserCommandResponse{Status: "ok", Sent: sent}
+	if req.WaitReply {
+		resp.Reply = reply
+	}
+	return resp, nil
+}
+
+// apiSendBatch POST /api/v1/commands/batch
+func (s *HTTPServer) apiSendBatch(r *http.Request, params map[string]string) (interface{}, error) {
+	var req BatchCommandRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	return s.executeBatch(req)
+}
+
+// apiListSchedules GET /api/v1/schedules
+func (s *HTTPServer) apiListSchedules(r *http.Request, params map[string]string) (interface{}, error) {
+	if s.scheduler == nil {
+		return nil, errSchedulerDisabled
+	}
+	return s.scheduler.List(), nil
+}
+
+// apiCreateSchedule POST /api/v1/schedules
+func (s *HTTPServer) apiCreateSchedule(r *http.Request, params map[string]string) (interface{}, error) {
+	if s.scheduler == nil {
+		return nil, errSchedulerDisabled
+	}
+	var req ScheduleRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	schedule, err := req.schedule()
+	if err != nil {
+		return nil, err
+	}
+	return s.scheduler.Add(schedule)
+}
+
+// apiCancelSchedule DELETE /api/v1/schedules/{id}
+func (s *HTTPServer) apiCancelSchedule(r *http.Request, params map[string]string) (interface{}, error) {
+	if s.scheduler == nil {
+		return nil, errSchedulerDisabled
+	}
+	if err := s.scheduler.Cancel(params["id"]); err != nil {
+		return nil, err
+	}
+	return StatusResponse{Status: "ok"}, nil
+}
+
+// apiListRules GET /api/v1/rules
+func (s *HTTPServer) apiListRules(r *http.Request, params map[string]string) (interface{}, error) {
+	if s.rulesEngine == nil {
+		return nil, errRulesDisabled
+	}
+	return s.rulesEngine.List(), nil
+}
+
+// apiCreateRule POST /api/v1/rules
+func (s *HTTPServer) apiCreateRule(r *http.Request, params map[string]string) (interface{}, error) {
+	if s.rulesEngine == nil {
+		return nil, errRulesDisabled
+	}
+	var spec ruleSpec
+	if err := decodeBody(r, &spec); err != nil {
+		return nil, err
+	}
+	return s.rulesEngine.Add(spec.rule())
+}
+
+// apiToggleRule PUT /api/v1/rules/{id}
+func (s *HTTPServer) apiToggleRule(r *http.Request, params map[string]string) (interface{}, error) {
+	if s.rulesEngine == nil {
+		return nil, errRulesDisabled
+	}
+	var req RuleToggleRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	if err := s.rulesEngine.SetEnabled(params["id"], req.Enabled); err != nil {
+		return nil, err
+	}
+	return StatusResponse{Status: "ok"}, nil
+}
+
+// apiDeleteRule DELETE /api/v1/rules/{id}
+func (s *HTTPServer) apiDeleteRule(r *http.Request, params map[string]string) (interface{}, error) {
+	if s.rulesEngine == nil {
+		return nil, errRulesDisabled
+	}
+	if err := s.rulesEngine.Remove(params["id"]); err != nil {
+		return nil, err
+	}
+	return StatusResponse{Status: "ok"}, nil
+}
+
+// apiListTemplates GET /api/v1/templates
+func (s *HTTPServer) apiListTemplates(r *http.Request, params map[string]string) (interface{}, error) {
+	if s.templates == nil {
+		return nil, errTemplatesDisabled
+	}
+	return s.templates.List(), nil
+}
+
+// apiSaveTemplate POST /api/v1/templates
+func (s *HTTPServer) apiSaveTemplate(r *http.Request, params map[string]string) (interface{}, error) {
+	if s.templates == nil {
+		return nil, errTemplatesDisabled
+	}
+	var t CommandTemplate
+	if err := decodeBody(r, &t); err != nil {
+		return nil, err
+	}
+	if err := s.templates.Save(t); err != nil {
+		return nil, err
+	}
+	return StatusResponse{Status: "ok"}, nil
+}
+
+// apiDeleteTemplate DELETE /api/v1/templates/{name}
+func (s *HTTPServer) apiDeleteTemplate(r *http.Request, params map[string]string) (interface{}, error) {
+	if s.templates == nil {
+		return nil, errTemplatesDisabled
+	}
+	if err := s.templates.Delete(params["name"]); err != nil {
+		return nil, err
+	}
+	return StatusResponse{Status: "ok"}, nil
+}
+
+// apiInjectRaw POST /api/v1/raw
+func (s *HTTPServer) apiInjectRaw(r *http.Request, params map[string]string) (interface{}, error) {
+	var req RawInjectRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	n, err := s.injectRaw(req)
+	if err != nil {
+		return nil, err
+	}
+	return RawInjectResponse{Status: "ok", Bytes: n}, nil
+}
+
+// apiFuzz POST /api/v1/raw/fuzz
+func (s *HTTPServer) apiFuzz(r *http.Request, params map[string]string) (interface{}, error) {
+	var req FuzzRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	resp, err := s.runFuzz(r.Context(), req)
+	if err != nil {
+		if resp != nil {
+			err = withDetails(err, map[string]interface{}{"seed": resp.Seed, "frames": resp.Frames})
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// apiGetOpenAPI GET /api/v1/openapi.json
+func (s *HTTPServer) apiGetOpenAPI(r *http.Request, params map[string]string) (interface{}, error) {
+	return buildOpenAPI(s.apiRoutes), nil
+}
@@ -0,0 +1,463 @@
+package core
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Role 使用者角色（viewer < operator < admin）
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"   // 只能查看狀態與事件
+	RoleOperator Role = "operator" // 可發送 START/STOP/PAUSE/RESUME、批次與自訂命令
+	RoleAdmin    Role = "admin"    // 可注入原始封包、管理規則
+)
+
+// roleRank 角色權限等級
+var roleRank = map[Role]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// Allows 檢查角色是否具備 required 的權限
+func (r Role) Allows(required Role) bool {
+	return roleRank[r] >= roleRank[required]
+}
+
+// parseRole 解析角色名稱
+func parseRole(name string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(name)))
+	if _, ok := roleRank[role]; !ok {
+		return "", fmt.Errorf("unknown role: %q", name)
+	}
+	return role, nil
+}
+
+// Identity 請求者身分
+type Identity struct {
+	Name       string // 使用者或 Token 名稱（未啟用驗證或匿名時為空）
+	Role       Role
+	RemoteAddr string
+}
+
+// String 記錄用的身分描述，例如 alice(operator)@10.0.0.5:53211
+func (id Identity) String() string {
+	name := id.Name
+	if name == "" {
+		name = "anonymous"
+	}
+	return fmt.Sprintf("%s(%s)@%s", name, id.Role, id.RemoteAddr)
+}
+
+type identityKey struct{}
+
+// IdentityFromContext 取得請求者身分（未經過驗證中介層時回傳 false）
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// AuthConfig 驗證設定檔內容
+//
+//	{
+//	  "anonymous_role": "viewer",
+//	  "users":  [{"name": "alice", "password_hash": "pbkdf2-sha256:600000:<salt>:<hex>", "role": "operator"}],
+//	  "tokens": [{"name": "line-ci", "token": "<random>", "role": "admin"}]
+//	}
+type AuthConfig struct {
+	AnonymousRole string      `json:"anonymous_role,omitempty"` // 未帶認證資訊時的角色（空字串表示必須登入）
+	Users         []AuthUser  `json:"users"`
+	Tokens        []AuthToken `json:"tokens"`
+}
+
+// AuthUser 本機使用者（以 HTTP Basic 驗證）
+type AuthUser struct {
+	Name         string `json:"name"`
+	PasswordHash string `json:"password_hash"` // 由 -hash-password 產生
+	Role         string `json:"role"`
+}
+
+// AuthToken API Token（以 Authorization: Bearer 或 ?access_token= 帶入）
+type AuthToken struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Role  string `json:"role"`
+}
+
+// Authenticator 驗證請求者身分（nil 表示未啟用驗證，所有請求皆視為 admin）
+type Authenticator struct {
+	users         map[string]AuthUser
+	userRoles     map[string]Role
+	tokens        []AuthToken
+	tokenRoles    []Role
+	anonymousRole Role
+
+	verifyKey  []byte            // 驗證快取的 HMAC 金鑰（每次啟動隨機產生）
+	verifiedMu sync.Mutex        // 保護 verified
+	verified   map[string][]byte // 使用者名稱 -> 最後一次驗證成功的密碼 HMAC
+}
+
+// LoadAuthFile 載入驗證設定檔
+func LoadAuthFile(path string) (*Authenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth file: %w", err)
+	}
+
+	var config AuthConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse auth file: %w", err)
+	}
+
+	return NewAuthenticator(config)
+}
+
+// NewAuthenticator 依設定建立驗證器
+func NewAuthenticator(config AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		users:     make(map[string]AuthUser),
+		userRoles: make(map[string]Role),
+		verifyKey: make([]byte, 32),
+		verified:  make(map[string][]byte),
+	}
+	if _, err := rand.Read(a.verifyKey); err != nil {
+		return nil, err
+	}
+
+	if config.AnonymousRole != "" {
+		role, err := parseRole(config.AnonymousRole)
+		if err != nil {
+			return nil, fmt.Errorf("anonymous_role: %w", err)
+		}
+		a.anonymousRole = role
+	}
+
+	for _, u := range config.Users {
+		if u.Name == "" {
+			return nil, fmt.Errorf("user without name")
+		}
+		if _, err := parsePasswordHash(u.PasswordHash); err != nil {
+			return nil, fmt.Errorf("user %s: %w", u.Name, err)
+		}
+		role, err := parseRole(u.Role)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", u.Name, err)
+		}
+		a.users[u.Name] = u
+		a.userRoles[u.Name] = role
+	}
+
+	for _, t := range config.Tokens {
+		if t.Name == "" || len(t.Token) < 16 {
+			return nil, fmt.Errorf("token %q: name is required and token must be at least 16 characters", t.Name)
+		}
+		role, err := parseRole(t.Role)
+		if err != nil {
+			return nil, fmt.Errorf("token %s: %w", t.Name, err)
+		}
+		a.tokens = append(a.tokens, t)
+		a.tokenRoles = append(a.tokenRoles, role)
+	}
+
+	logHTTP.Info("authentication enabled", "users", len(a.users), "tokens", len(a.tokens), "anonymous_role", string(a.anonymousRole))
+	return a, nil
+}
+
+// Authenticate 從請求取得身分（帶入錯誤的認證資訊時一律拒絕，不會退回匿名）
+func (a *Authenticator) Authenticate(r *http.Request) (Identity, error) {
+	id := Identity{RemoteAddr: r.RemoteAddr}
+	if a == nil {
+		id.Role = RoleAdmin
+		return id, nil
+	}
+
+	// API Token：瀏覽器的 WebSocket/EventSource 無法設定標頭，因此也接受 ?access_token=
+	token := r.URL.Query().Get("access_token")
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		token = strings.TrimSpace(auth[7:])
+	}
+	if token != "" {
+		for i, t := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+				id.Name, id.Role = t.Name, a.tokenRoles[i]
+				return id, nil
+			}
+		}
+		return id, newError(ErrUnauthorized, nil, "invalid API token")
+	}
+
+	if name, password, ok := r.BasicAuth(); ok {
+		user, exists := a.users[name]
+		if !exists || !a.verifyUser(user, password) {
+			return id, newError(ErrUnauthorized, nil, "invalid username or password")
+		}
+		id.Name, id.Role = name, a.userRoles[name]
+		return id, nil
+	}
+
+	if a.anonymousRole == "" {
+		return id, newError(ErrUnauthorized, nil, "authentication required")
+	}
+	id.Role = a.anonymousRole
+	return id, nil
+}
+
+// 密碼雜湊（PBKDF2-HMAC-SHA256）
+const (
+	passwordHashScheme      = "pbkdf2-sha256"
+	passwordHashIterations  = 600000 // -hash-password 使用的次數
+	minPasswordIterations   = 100000 // 設定檔中可接受的最低次數
+	passwordSaltSize        = 16
+	passwordHashFormatError = "expected " + passwordHashScheme + ":<iterations>:<salt>:<hex>, use -hash-password"
+)
+
+// HashPassword 產生密碼雜湊（格式為 pbkdf2-sha256:<iterations>:<salt>:<hex>）
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2SHA256([]byte(password), salt, passwordHashIterations)
+	return fmt.Sprintf("%s:%d:%s:%s", passwordHashScheme, passwordHashIterations,
+		hex.EncodeToString(salt), hex.EncodeToString(key)), nil
+}
+
+// passwordHash 拆解後的密碼雜湊
+type passwordHash struct {
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+// parsePasswordHash 拆解密碼雜湊
+func parsePasswordHash(hash string) (passwordHash, error) {
+	if strings.HasPrefix(hash, "sha256:") {
+		return passwordHash{}, fmt.Errorf("password_hash uses the old sha256 format, regenerate it with -hash-password")
+	}
+
+	parts := strings.Split(hash, ":")
+	if len(parts) != 4 || parts[0] != passwordHashScheme {
+		return passwordHash{}, fmt.Errorf("invalid password_hash (%s)", passwordHashFormatError)
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < minPasswordIterations {
+		return passwordHash{}, fmt.Errorf("invalid password_hash: iterations must be at least %d (%s)", minPasswordIterations, passwordHashFormatError)
+	}
+	salt, err := hex.DecodeString(parts[2])
+	if err != nil || len(salt) == 0 {
+		return passwordHash{}, fmt.Errorf("invalid password_hash salt (%s)", passwordHashFormatError)
+	}
+	key, err := hex.DecodeString(parts[3])
+	if err != nil || len(key) != sha256.Size {
+		return passwordHash{}, fmt.Errorf("invalid password_hash key (%s)", passwordHashFormatError)
+	}
+	return passwordHash{iterations: iterations, salt: salt, key: key}, nil
+}
+
+// checkPassword 比對密碼
+func checkPassword(hash, password string) bool {
+	h, err := parsePasswordHash(hash)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(h.key, pbkdf2SHA256([]byte(password), h.salt, h.iterations)) == 1
+}
+
+// pbkdf2SHA256 PBKDF2-HMAC-SHA256（RFC 8018），輸出長度為一個 SHA-256 區塊
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1}) // 區塊編號 1
+	u := prf.Sum(nil)
+
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// verifyUser 比對使用者密碼
+// 瀏覽器的每個請求都會帶入 HTTP Basic 密碼，驗證成功後以隨機金鑰的 HMAC 記在記憶體中，避免每次都重新計算 PBKDF2
+func (a *Authenticator) verifyUser(user AuthUser, password string) bool {
+	mac := hmac.New(sha256.New, a.verifyKey)
+	mac.Write([]byte(user.Name + "\x00" + password))
+	digest := mac.Sum(nil)
+
+	a.verifiedMu.Lock()
+	cached, ok := a.verified[user.Name]
+	a.verifiedMu.Unlock()
+	if ok && hmac.Equal(cached, digest) {
+		return true
+	}
+
+	if !checkPassword(user.PasswordHash, password) {
+		return false
+	}
+	a.verifiedMu.Lock()
+	a.verified[user.Name] = digest
+	a.verifiedMu.Unlock()
+	return true
+}
+
+// requiredRole 依 HTTP 方法與路徑決定所需角色（/api 與 /api/v1 共用）
+func requiredRole(method, path string) Role {
+	if method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions {
+		return RoleViewer
+	}
+
+	path = strings.TrimPrefix(path, APIPrefix)
+	path = strings.TrimPrefix(path, "/api")
+	switch {
+	case path == "/raw" || strings.HasPrefix(path, "/raw/"):
+		// 原始封包注入可送出任意內容
+		return RoleAdmin
+	case path == "/rules" || strings.HasPrefix(path, "/rules/"):
+		// 規則會在背景自動發送命令
+		return RoleAdmin
+	case path == "/logging":
+		// 日誌等級影響整個伺服器（debug 會記錄完整封包內容）
+		return RoleAdmin
+	default:
+		return RoleOperator
+	}
+}
+
+// requiredRPCRole WebSocket 命令所需角色
+func requiredRPCRole(method string) Role {
+	switch strings.ToLower(method) {
+	case "ping", "subscribe", "unsubscribe", "snapshot":
+		return RoleViewer
+	default:
+		return RoleOperator
+	}
+}
+
+// errForbidden 角色權限不足
+func errForbidden(id Identity, required Role) error {
+	return newError(ErrForbidden, map[string]interface{}{"role": id.Role, "required_role": required},
+		"role %s is not allowed to perform this action (requires %s)", id.Role, required)
+}
+
+// SetAuthenticator 設定驗證器（nil 表示不驗證）
+func (s *HTTPServer) SetAuthenticator(auth *Authenticator) {
+	s.auth = auth
+}
+
+// SetAllowedOrigins 設定允許的跨來源 Origin（同源一律允許，"*" 表示不限制）
+func (s *HTTPServer) SetAllowedOrigins(origins []string) {
+	s.allowedOrigins = make(map[string]bool)
+	for _, origin := range origins {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			s.allowedOrigins[strings.ToLower(origin)] = true
+		}
+	}
+}
+
+// checkOrigin 檢查請求來源（沒有 Origin 標頭的非瀏覽器客戶端一律允許）
+func (s *HTTPServer) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	if s.allowedOrigins["*"] || s.allowedOrigins[strings.ToLower(origin)] {
+		return true
+	}
+
+	logHTTP.Warn("cross-origin request rejected", "remote", r.RemoteAddr, "origin", origin)
+	return false
+}
+
+// withAuth 驗證身分、檢查角色，並將身分放入 context
+func (s *HTTPServer) withAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := s.auth.Authenticate(r)
+		if err != nil {
+			logHTTP.Warn("authentication failed", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr, "error", err)
+			w.Header().Set("WWW-Authenticate", `Basic realm="DYMesTest", charset="UTF-8"`)
+			writeAuthError(w, r, err)
+			return
+		}
+
+		required := requiredRole(r.Method, r.URL.Path)
+		serve := func(w http.ResponseWriter, r *http.Request) {
+			if required != RoleViewer && !s.checkOrigin(r) {
+				// 避免其他網站利用瀏覽器已保存的帳密發送命令（CSRF）
+				writeAuthError(w, r, newError(ErrForbidden, nil, "cross-origin request is not allowed"))
+				return
+			}
+			if !id.Role.Allows(required) {
+				logHTTP.Warn("permission denied", "method", r.Method, "path", r.URL.Path, "user", id.String(), "requires", string(required))
+				writeAuthError(w, r, errForbidden(id, required))
+				return
+			}
+			if required != RoleViewer {
+				logHTTP.Info("request", "method", r.Method, "path", r.URL.Path, "user", id.String())
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+		}
+
+		if required == RoleViewer {
+			serve(w, r)
+			return
+		}
+		// 會變更狀態的請求（包含被拒絕的）都記錄到稽核記錄
+		s.auditHTTP(w, r, id, serve)
+	})
+}
+
+// writeAuthError API 路徑回傳統一錯誤格式，其他路徑（網頁）回傳純文字
+func writeAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeAPIError(w, err)
+		return
+	}
+	status, _ := errorCode(err)
+	http.Error(w, err.Error(), status)
+}
+
+// WhoAmIResponse 目前請求者的身分
+type WhoAmIResponse struct {
+	Name        string `json:"name,omitempty"`
+	Role        Role   `json:"role"`
+	AuthEnabled bool   `json:"auth_enabled"`
+}
+
+// whoami 取得請求者身分
+func (s *HTTPServer) whoami(r *http.Request) WhoAmIResponse {
+	id, ok := IdentityFromContext(r.Context())
+	if !ok {
+		id = Identity{Role: RoleAdmin}
+	}
+	return WhoAmIResponse{Name: id.Name, Role: id.Role, AuthEnabled: s.auth != nil}
+}
+
+// handleWhoAmI GET /api/whoami
+func (s *HTTPServer) handleWhoAmI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.whoami(r))
+}
@@ -0,0 +1,248 @@
+package core
+
+import (
+	"GoTestMES/models"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 支援的命令類型
+const (
+	CmdStart     = "START"
+	CmdStop      = "STOP"
+	CmdPause     = "PAUSE"
+	CmdResume    = "RESUME"
+	CmdRspStatus = "RSP_STATUS"
+)
+
+// DefaultBatchInterval 批次命令預設發送間隔（避免瞬間灌爆 TPT）
+const DefaultBatchInterval = 50 * time.Millisecond
+
+// 批次命令的間隔上限（HTTP 請求會等待整個批次完成）
+const (
+	MaxBatchInterval = 5 * time.Second
+	MaxBatchDuration = 2 * time.Minute
+)
+
+// Command 通用命令描述（批次、排程等功能共用）
+type Command struct {
+	Type     string `json:"type"`                // START/STOP/PAUSE/RESUME/RSP_STATUS
+	Channel  string `json:"channel,omitempty"`   // 通道編號 (例如: "CH003")
+	Barcode  string `json:"barcode,omitempty"`   // 條碼（START 使用）
+	Process  string `json:"process,omitempty"`   // 製程名稱（START 使用）
+	DataPath string `json:"data_path,omitempty"` // 資料路徑（START 使用）
+}
+
+// BatchResult 批次命令中單一通道的執行結果
+type BatchResult struct {
+	Channel string `json:"channel"`
+	OK      bool   `json:"ok"`
+	MsgID   string `json:"msg_id,omitempty"` // 已發送命令的 msg_id（用於對應 ACK）
+	Error   string `json:"error,omitempty"`
+}
+
+// ExecuteCommand 依命令類型呼叫對應的 ValidateAndSend* 方法
+func (sm *StateManager) ExecuteCommand(cmd Command) error {
+	_, err := sm.SendCommand(cmd)
+	return err
+}
+
+// SendCommand 與 ExecuteCommand 相同，但同時回傳已發送命令的 msg_id
+func (sm *StateManager) SendCommand(cmd Command) (msgID string, err error) {
+	switch strings.ToUpper(cmd.Type) {
+	case CmdStart:
+		if cmd.Barcode == "" || cmd.Process == "" || cmd.DataPath == "" {
+			return "", newError(ErrInvalidRequest, nil, "START requires barcode, process and data_path")
+		}
+		return sm.sendStart(cmd.Channel, cmd.Barcode, cmd.Process, cmd.DataPath)
+	case CmdStop:
+		return sm.sendStop(cmd.Channel)
+	case CmdPause:
+		return sm.sendPause(cmd.Channel)
+	case CmdResume:
+		return sm.sendResume(cmd.Channel)
+	case CmdRspStatus:
+		return sm.sendRspStatus()
+	default:
+		return "", newError(ErrInvalidRequest, nil, "unsupported command type: %s", cmd.Type)
+	}
+}
+
+// checkBatchCommand 檢查命令是否可以批次執行（RSP_STATUS 不針對通道，逐通道發送只會重複查詢）
+func checkBatchCommand(cmdType string) error {
+	if strings.EqualFold(cmdType, CmdRspStatus) {
+		return newError(ErrInvalidRequest, nil, "%s is not channel-specific and cannot be sent as a batch", CmdRspStatus)
+	}
+	return nil
+}
+
+// ExecuteBatch 對多個通道依序執行同一命令，每次發送間隔 interval
+// barcodes 可針對個別通道覆寫條碼（未指定時使用 cmd.Barcode）
+// interval 不可超過 MaxBatchInterval，整個批次的等待時間不可超過 MaxBatchDuration
+func (sm *StateManager) ExecuteBatch(cmd Command, channelIDs []string, barcodes map[string]string, interval time.Duration) ([]BatchResult, error) {
+	if err := checkBatchCommand(cmd.Type); err != nil {
+		return nil, err
+	}
+	if interval > MaxBatchInterval {
+		return nil, newError(ErrInvalidRequest, nil, "batch interval %v exceeds the maximum of %v", interval, MaxBatchInterval)
+	}
+	if len(channelIDs) > 1 && interval*time.Duration(len(channelIDs)-1) > MaxBatchDuration {
+		return nil, newError(ErrInvalidRequest, nil, "batch of %d channel(s) at %v intervals exceeds the maximum duration of %v",
+			len(channelIDs), interval, MaxBatchDuration)
+	}
+
+	results := make([]BatchResult, 0, len(channelIDs))
+
+	for i, channelID := range channelIDs {
+		if i > 0 && interval > 0 {
+			time.Sleep(interval)
+		}
+
+		target := cmd
+		target.Channel = channelID
+		if bc, ok := barcodes[channelID]; ok && bc != "" {
+			target.Barcode = bc
+		}
+
+		result := BatchResult{Channel: channelID, OK: true}
+		msgID, err := sm.SendCommand(target)
+		if err != nil {
+			result.OK = false
+			result.Error = err.Error()
+		}
+		result.MsgID = msgID
+		results = append(results, result)
+	}
+
+	logHTTP.Info("batch command sent", "command", strings.ToUpper(cmd.Type), "channels", len(channelIDs))
+	return results, nil
+}
+
+// ResolveChannels 將選擇器解析為通道 ID 列表（依通道編號排序、去除重複）
+// 每個選擇器可包含以逗號分隔的多個項目，支援以下格式：
+//
+//	CH001 / ch001 / 1          單一通道
+//	CH001-CH032 / 1-32         通道範圍
+//	all                        所有通道
+//	StandBy / all StandBy      指定狀態的所有通道
+func (sm *StateManager) ResolveChannels(selectors ...string) ([]string, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	selected := make(map[int]bool)
+	for _, selector := range selectors {
+		for _, term := range strings.Split(selector, ",") {
+			term = strings.TrimSpace(term)
+			if term == "" {
+				continue
+			}
+			if err := sm.resolveTerm(term, selected); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	channelIDs := make([]string, 0, len(selected))
+	for i := 1; i <= sm.channelCount; i++ {
+		if selected[i] {
+			channelIDs = append(channelIDs, fmt.Sprintf("CH%03d", i))
+		}
+	}
+	return channelIDs, nil
+}
+
+// resolveTerm 解析單一選擇器項目（呼叫端需持有讀鎖）
+func (sm *StateManager) resolveTerm(term string, selected map[int]bool) error {
+	// all / all <State>
+	fields := strings.Fields(term)
+	if strings.EqualFold(fields[0], "all") {
+		if len(fields) == 1 {
+			for i := 1; i <= sm.channelCount; i++ {
+				selected[i] = true
+			}
+			return nil
+		}
+		return sm.selectByState(strings.Join(fields[1:], " "), selected)
+	}
+
+	// CH001-CH032
+	if from, to, found := strings.Cut(term, "-"); found {
+		start, err := parseChannelNumber(from)
+		if err != nil {
+			return err
+		}
+		end, err := parseChannelNumber(to)
+		if err != nil {
+			return err
+		}
+		if start > end {
+			return newError(ErrInvalidRequest, nil, "invalid channel range: %s", term)
+		}
+		if end > sm.channelCount {
+			return newError(ErrNotFound, nil, "channel range %s exceeds channel count %d", term, sm.channelCount)
+		}
+		for i := start; i <= end; i++ {
+			selected[i] = true
+		}
+		return nil
+	}
+
+	// CH001
+	if n, err := parseChannelNumber(term); err == nil {
+		if n > sm.channelCount {
+			return errChannelNotFound(term)
+		}
+		selected[n] = true
+		return nil
+	}
+
+	// StandBy
+	return sm.selectByState(term, selected)
+}
+
+// selectByState 選取指定狀態的所有通道（呼叫端需持有讀鎖）
+func (sm *StateManager) selectByState(state string, selected map[int]bool) error {
+	if !isKnownState(state) {
+		return newError(ErrInvalidRequest, nil, "unknown channel selector: %s", state)
+	}
+	for i := 1; i <= sm.channelCount; i++ {
+		ch, exists := sm.channels[fmt.Sprintf("CH%03d", i)]
+		if exists && strings.EqualFold(ch.State, state) {
+			selected[i] = true
+		}
+	}
+	return nil
+}
+
+// parseChannelNumber 將 "CH005"、"ch005"、"5" 轉為通道編號
+func parseChannelNumber(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && strings.EqualFold(s[:2], "ch") {
+		s = s[2:]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, newError(ErrInvalidRequest, nil, "invalid channel: %s", s)
+	}
+	return n, nil
+}
+
+// knownStates 已定義的通道狀態
+var knownStates = []string{
+	models.StateStandBy, models.StateRunning, models.StatePaused,
+	models.StateStartFailed, models.StateChangeStepFailed, models.StateResumeFailed,
+	models.StateAlarm, models.StateNoLoad, models.StateFinish,
+	models.StateReversePolarity, models.StateOffLine,
+}
+
+// isKnownState 檢查是否為已定義的通道狀態（不分大小寫）
+func isKnownState(state string) bool {
+	for _, s := range knownStates {
+		if strings.EqualFold(s, state) {
+			return true
+		}
+	}
+	return false
+}
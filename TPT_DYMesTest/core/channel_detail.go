@@ -0,0 +1,294 @@
+package core
+
+import (
+	"GoTestMES/models"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChannelMessage 通道最後一則訊息
+type ChannelMessage struct {
+	Direction string      `json:"direction"` // TPT->MES 或 MES->TPT
+	Type      string      `json:"type"`      // 訊息類型
+	At        time.Time   `json:"at"`        // 收到或發送的時間
+	Data      interface{} `json:"data"`      // 訊息內容
+}
+
+// ChannelRun 通道的一次測試（由 START 開始，到離開 Running/Paused 狀態為止）
+type ChannelRun struct {
+	Barcode     string     `json:"barcode"`
+	Process     string     `json:"process"`
+	DataPath    string     `json:"data_path"`
+	StartMsgID  string     `json:"start_msg_id,omitempty"` // START 命令的 msg_id
+	RequestedAt *time.Time `json:"requested_at,omitempty"` // START 發送時間
+	StartedAt   *time.Time `json:"started_at,omitempty"`   // 進入 Running 的時間
+	EndedAt     *time.Time `json:"ended_at,omitempty"`     // 結束時間
+	EndState    string     `json:"end_state,omitempty"`    // 結束時的狀態（Finish、Alarm...）
+	RecordPath  string     `json:"record_path,omitempty"`  // REPORT 回報的記錄檔案路徑
+}
+
+// PendingCommandInfo 等待 ACK 的命令
+type PendingCommandInfo struct {
+	Command string    `json:"command"`
+	MsgID   string    `json:"msg_id"`
+	SentAt  time.Time `json:"sent_at"`
+	AgeMs   int64     `json:"age_ms"` // 已等待的時間
+}
+
+// ChannelDetail 單一通道的詳細資訊
+type ChannelDetail struct {
+	ChannelID       string               `json:"channel_id"`
+	State           string               `json:"state"`
+	Barcode         string               `json:"barcode,omitempty"`
+	Process         string               `json:"process,omitempty"`
+	DataPath        string               `json:"data_path,omitempty"`
+	Message         string               `json:"message,omitempty"`
+	PreviousState   string               `json:"previous_state,omitempty"`   // 上一個狀態
+	StateChangedAt  *time.Time           `json:"state_changed_at,omitempty"` // 最後一次狀態變更時間
+	LastMessage     *ChannelMessage      `json:"last_message,omitempty"`     // 最後一則與此通道相關的訊息
+	CurrentRun      *ChannelRun          `json:"current_run,omitempty"`      // 進行中的測試
+	LastRun         *ChannelRun          `json:"last_run,omitempty"`         // 最近一次已結束的測試
+	PendingCommands []PendingCommandInfo `json:"pending_commands"`           // 等待 ACK 的命令
+}
+
+// channelHistory 通道的附加資訊（只在詳細查詢時使用，不包含在 ChannelState 廣播中）
+type channelHistory struct {
+	previousState  string
+	stateChangedAt time.Time
+	lastMessage    *ChannelMessage
+	run            *ChannelRun
+	lastRun        *ChannelRun
+}
+
+// historyLocked 取得通道的附加資訊（不存在時建立，呼叫端需持有寫鎖）
+func (sm *StateManager) historyLocked(channelID string) *channelHistory {
+	h, exists := sm.channelHistory[channelID]
+	if !exists {
+		h = &channelHistory{}
+		sm.channelHistory[channelID] = h
+	}
+	return h
+}
+
+// setStateLocked 更新通道狀態並記錄變更時間與測試進度（呼叫端需持有寫鎖）
+func (sm *StateManager) setStateLocked(ch *ChannelState, state string) {
+	if ch.State == state {
+		return
+	}
+
+	now := time.Now()
+	h := sm.historyLocked(ch.ChannelID)
+	h.previousState = ch.State
+	h.stateChangedAt = now
+	ch.State = state
+
+	switch state {
+	case models.StateRunning:
+		if h.run == nil {
+			// 未經由本系統 START（例如 TPT 端手動啟動）
+			h.run = &ChannelRun{Barcode: ch.Barcode, Process: ch.Process, DataPath: ch.DataPath}
+		}
+		if h.run.StartedAt == nil {
+			h.run.StartedAt = &now
+		}
+	case models.StatePaused:
+		// 暫停中仍屬於同一次測試
+	default:
+		if h.run != nil {
+			h.run.EndedAt = &now
+			h.run.EndState = state
+			h.lastRun = h.run
+			h.run = nil
+		}
+	}
+}
+
+// beginRunLocked START 發送後建立新的測試記錄（呼叫端需持有寫鎖）
+func (sm *StateManager) beginRunLocked(ch *ChannelState, msgID string) {
+	now := time.Now()
+	sm.historyLocked(ch.ChannelID).run = &ChannelRun{
+		Barcode:     ch.Barcode,
+		Process:     ch.Process,
+		DataPath:    ch.DataPath,
+		StartMsgID:  msgID,
+		RequestedAt: &now,
+	}
+}
+
+// recordMessageLocked 記錄通道最後一則訊息（呼叫端需持有寫鎖）
+func (sm *StateManager) recordMessageLocked(channelID, direction, msgType string, data interface{}) {
+	if _, exists := sm.channels[channelID]; !exists {
+		return
+	}
+	sm.historyLocked(channelID).lastMessage = &ChannelMessage{
+		Direction: direction,
+		Type:      msgType,
+		At:        time.Now(),
+		Data:      data,
+	}
+}
+
+// recordIncomingLocked 依 TPT 訊息內容記錄相關通道的最後訊息（呼叫端需持有寫鎖）
+func (sm *StateManager) recordIncomingLocked(msgType string, msg map[string]interface{}) {
+	if channel := stringField(msg, "channel"); channel != "" {
+		if channelID, err := canonicalChannelID(channel); err == nil {
+			sm.recordMessageLocked(channelID, "TPT->MES", msgType, msg)
+		}
+	}
+
+	// STATUS_ALL 的 channels: [{"ch": "001", ...}]
+	if list, ok := msg["channels"].([]interface{}); ok {
+		for _, item := range list {
+			entry, _ := item.(map[string]interface{})
+			if channelID, err := canonicalChannelID(stringField(entry, "ch")); err == nil {
+				sm.recordMessageLocked(channelID, "TPT->MES", msgType, msg)
+			}
+		}
+	}
+}
+
+// GetChannelDetail 取得單一通道的詳細資訊
+func (sm *StateManager) GetChannelDetail(channelID string) (ChannelDetail, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	ch, exists := sm.channels[channelID]
+	if !exists {
+		return ChannelDetail{}, false
+	}
+
+	detail := ChannelDetail{
+		ChannelID:       ch.ChannelID,
+		State:           ch.State,
+		Barcode:         ch.Barcode,
+		Process:         ch.Process,
+		DataPath:        ch.DataPath,
+		Message:         ch.Message,
+		PendingCommands: []PendingCommandInfo{},
+	}
+
+	if h, ok := sm.channelHistory[channelID]; ok {
+		detail.PreviousState = h.previousState
+		if !h.stateChangedAt.IsZero() {
+			changedAt := h.stateChangedAt
+			detail.StateChangedAt = &changedAt
+		}
+		if h.lastMessage != nil {
+			msg := *h.lastMessage
+			detail.LastMessage = &msg
+		}
+		if h.run != nil {
+			run := *h.run
+			detail.CurrentRun = &run
+		}
+		if h.lastRun != nil {
+			run := *h.lastRun
+			detail.LastRun = &run
+		}
+	}
+
+	now := time.Now()
+	for msgID, pending := range sm.pendingCommands {
+		if pending.Channel != channelID {
+			continue
+		}
+		detail.PendingCommands = append(detail.PendingCommands, PendingCommandInfo{
+			Command: pending.Type,
+			MsgID:   msgID,
+			SentAt:  pending.SentAt,
+			AgeMs:   now.Sub(pending.SentAt).Milliseconds(),
+		})
+	}
+	sort.Slice(detail.PendingCommands, func(i, j int) bool {
+		return detail.PendingCommands[i].SentAt.Before(detail.PendingCommands[j].SentAt)
+	})
+
+	return detail, true
+}
+
+// ChannelQuery 通道列表的篩選與分頁條件
+type ChannelQuery struct {
+	States        []string // 狀態（任一符合，不分大小寫）
+	BarcodePrefix string   // 條碼前綴
+	Process       string   // 製程名稱（完全符合）
+	Offset        int      // 略過的筆數
+	Limit         int      // 最多回傳筆數（0 表示不限制）
+}
+
+// ChannelQueryFromURL 從 URL 參數解析通道查詢條件
+//
+//	?state=Running,Paused&barcode_prefix=A12&process=TEST-001&offset=0&limit=32
+func ChannelQueryFromURL(values url.Values) (ChannelQuery, error) {
+	q := ChannelQuery{
+		BarcodePrefix: values.Get("barcode_prefix"),
+		Process:       values.Get("process"),
+	}
+
+	for _, v := range values["state"] {
+		for _, state := range strings.Split(v, ",") {
+			state = strings.TrimSpace(state)
+			if state == "" {
+				continue
+			}
+			if !isKnownState(state) {
+				return q, newError(ErrInvalidRequest, nil, "unknown state: %s", state)
+			}
+			q.States = append(q.States, state)
+		}
+	}
+
+	for name, target := range map[string]*int{"offset": &q.Offset, "limit": &q.Limit} {
+		v := values.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return q, newError(ErrInvalidRequest, nil, "invalid %s: %s", name, v)
+		}
+		*target = n
+	}
+
+	return q, nil
+}
+
+// QueryChannels 依條件篩選通道（依通道編號排序），回傳該頁資料與符合條件的總筆數
+func (sm *StateManager) QueryChannels(q ChannelQuery) ([]ChannelState, int) {
+	all := sm.GetAllChannels() // 已依通道編號排序（CH999 之後為 CH1000）
+
+	matched := make([]ChannelState, 0, len(all))
+	for _, ch := range all {
+		if len(q.States) > 0 {
+			found := false
+			for _, state := range q.States {
+				if strings.EqualFold(ch.State, state) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		if q.BarcodePrefix != "" && !strings.HasPrefix(ch.Barcode, q.BarcodePrefix) {
+			continue
+		}
+		if q.Process != "" && ch.Process != q.Process {
+			continue
+		}
+		matched = append(matched, ch)
+	}
+
+	total := len(matched)
+	if q.Offset >= total {
+		return []ChannelState{}, total
+	}
+	matched = matched[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(matched) {
+		matched = matched[:q.Limit]
+	}
+	return matched, total
+}
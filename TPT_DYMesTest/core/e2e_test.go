@@ -0,0 +1,394 @@
+package core
+
+import (
+	"GoTestMES/models"
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// tptClient 透過 loopback TCP 模擬 TPT
+type tptClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTPT(t *testing.T, addr string) *tptClient {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &tptClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *tptClient) send(msg map[string]interface{}) {
+	c.t.Helper()
+	if err := WriteMessage(c.conn, msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// expect 讀取下一則訊息並確認類型
+func (c *tptClient) expect(msgType string) map[string]interface{} {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	data, err := ReadMessage(c.reader)
+	if err != nil {
+		c.t.Fatalf("waiting for %s: %v", msgType, err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	if msg["type"] != msgType {
+		c.t.Fatalf("got %s, want %s: %s", msg["type"], msgType, data)
+	}
+	return msg
+}
+
+// waitUntil 在期限內等待條件成立
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+// startTCPServer 在系統分配的連接埠啟動 TCP 伺服器（configure 在 Start 之前呼叫）
+func startTCPServer(t *testing.T, sm *StateManager, policy HandshakePolicy, configure ...func(*TCPServer)) *TCPServer {
+	t.Helper()
+	server := NewTCPServer(0, sm)
+	server.SetListenAddr("127.0.0.1:0")
+	server.SetHandshakePolicy(policy)
+	for _, fn := range configure {
+		fn(server)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	return server
+}
+
+func TestEndToEndLinkStartReport(t *testing.T) {
+	sm := NewStateManager(4)
+	server := startTCPServer(t, sm, HandshakePolicy{Mode: LinkModeNG, LinkTimeout: 5 * time.Second})
+	tpt := dialTPT(t, server.Addr().String())
+
+	// LINK 之前的訊息回覆 NG
+	tpt.send(map[string]interface{}{"type": "STATUS_ALL", "msg_id": "E0", "work_station_name": "WS1", "channels": []interface{}{}})
+	if ack := tpt.expect("STATUS_ALL_ACK"); ack["ack"] != models.AckNG || ack["reply_to"] != "E0" {
+		t.Fatalf("expected NG before LINK, got %v", ack)
+	}
+
+	tpt.send(map[string]interface{}{"type": "LINK", "msg_id": "E1", "work_station_name": "WS1", "state": "Online-Auto", "channel_count": "4"})
+	if ack := tpt.expect("LINK_ACK"); ack["ack"] != models.AckOK || ack["reply_to"] != "E1" {
+		t.Fatalf("unexpected LINK_ACK: %v", ack)
+	}
+	waitUntil(t, "client marked as linked", func() bool {
+		clients := server.GetClients()
+		return len(clients) == 1 && clients[0].Linked && clients[0].WorkStation == "WS1"
+	})
+
+	channels := make([]map[string]string, 4)
+	for i := range channels {
+		channels[i] = map[string]string{"ch": fmt.Sprintf("%03d", i+1), "state": models.StateStandBy}
+	}
+	tpt.send(map[string]interface{}{"type": "STATUS_ALL", "msg_id": "E2", "work_station_name": "WS1", "channels": channels})
+	if ack := tpt.expect("STATUS_ALL_ACK"); ack["ack"] != models.AckOK {
+		t.Fatalf("unexpected STATUS_ALL_ACK: %v", ack)
+	}
+	if ch, _ := sm.GetChannel("CH004"); ch.State != models.StateStandBy {
+		t.Fatalf("CH004 = %s after STATUS_ALL", ch.State)
+	}
+
+	// MES 發送 START，TPT 回覆 ACK 後回報 Running
+	msgID, err := sm.SendCommand(Command{Type: CmdStart, Channel: "CH002", Barcode: "BC9", Process: "CYCLE", DataPath: `D:\data`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := tpt.expect("START")
+	if start["msg_id"] != msgID || start["channel"] != "CH002" || start["barcode"] != "BC9" || start["data_path"] != `D:\data` {
+		t.Fatalf("unexpected START: %v", start)
+	}
+	tpt.send(map[string]interface{}{"type": "START_ACK", "msg_id": "E3", "reply_to": msgID, "channel": "CH002", "ack": models.AckOK})
+	tpt.send(map[string]interface{}{"type": "STATUS", "msg_id": "E4", "work_station_name": "WS1", "channel": "CH002", "state": models.StateRunning})
+	tpt.expect("STATUS_ACK")
+	waitUntil(t, "START ACK", func() bool {
+		pending, _ := sm.pendingCommandCount()
+		return pending == 0
+	})
+
+	// 未跳脫的 Windows 路徑也能處理
+	if _, err := tpt.conn.Write([]byte(`{"type":"REPORT","msg_id":"E5","work_station_name":"WS1","channel":"ch002","record_path":"D:\logs\002.csv"}` + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if ack := tpt.expect("REPORT_ACK"); ack["reply_to"] != "E5" || ack["ack"] != models.AckOK {
+		t.Fatalf("unexpected REPORT_ACK: %v", ack)
+	}
+	ch, _ := sm.GetChannel("CH002")
+	if ch.State != models.StateFinish {
+		t.Fatalf("CH002 = %s, want Finish", ch.State)
+	}
+	detail, _ := sm.GetChannelDetail("CH002")
+	if detail.LastRun == nil || detail.LastRun.Barcode != "BC9" || detail.LastRun.RecordPath != `D:\logs\002.csv` {
+		t.Fatalf("unexpected last run: %+v", detail.LastRun)
+	}
+
+	// 斷線後 TPT 視為離線
+	tpt.conn.Close()
+	waitUntil(t, "TPT offline", func() bool {
+		return sm.GetConnectionStatus()["tpt_connected"] == false
+	})
+}
+
+func TestEndToEndLinkTimeout(t *testing.T) {
+	sm := NewStateManager(1)
+	server := startTCPServer(t, sm, HandshakePolicy{Mode: LinkModeNG, LinkTimeout: 100 * time.Millisecond})
+	tpt := dialTPT(t, server.Addr().String())
+
+	tpt.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := tpt.reader.ReadByte(); err == nil {
+		t.Fatal("expected the server to close an idle connection without LINK")
+	}
+}
+
+func TestEndToEndWorkStationMismatch(t *testing.T) {
+	sm := NewStateManager(2)
+	server := startTCPServer(t, sm, HandshakePolicy{Mode: LinkModeNG})
+	tpt := dialTPT(t, server.Addr().String())
+
+	tpt.send(map[string]interface{}{"type": "LINK", "msg_id": "W1", "work_station_name": "WS1", "state": "Online-Auto", "channel_count": "2"})
+	tpt.expect("LINK_ACK")
+	tpt.send(map[string]interface{}{"type": "STATUS", "msg_id": "W2", "work_station_name": "WS2", "channel": "CH001", "state": models.StateAlarm})
+	if ack := tpt.expect("STATUS_ACK"); ack["ack"] != models.AckNG {
+		t.Fatalf("expected NG for mismatched workstation, got %v", ack)
+	}
+	if ch, _ := sm.GetChannel("CH001"); ch.State == models.StateAlarm {
+		t.Fatal("rejected STATUS must not change the channel")
+	}
+}
+
+func TestEndToEndCommandsSkipUnlinkedClients(t *testing.T) {
+	sm := NewStateManager(2)
+	server := startTCPServer(t, sm, HandshakePolicy{Mode: LinkModeNG})
+	tpt := dialTPT(t, server.Addr().String())
+	stray := dialTPT(t, server.Addr().String())
+
+	tpt.send(map[string]interface{}{"type": "LINK", "msg_id": "U1", "work_station_name": "WS1", "state": "Online-Auto", "channel_count": "2"})
+	tpt.expect("LINK_ACK")
+	waitUntil(t, "both connections registered", func() bool { return len(server.GetClients()) == 2 })
+
+	if _, err := sm.SendCommand(Command{Type: CmdStop, Channel: "CH001"}); err != nil {
+		t.Fatal(err)
+	}
+	tpt.expect("STOP")
+
+	// 未完成 LINK 的連線不會收到命令
+	stray.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if data, err := ReadMessage(stray.reader); err == nil {
+		t.Fatalf("unlinked connection received %s", data)
+	}
+
+	// 只剩未完成 LINK 的連線時視為 TPT 離線
+	tpt.conn.Close()
+	waitUntil(t, "linked connection closed", func() bool { return len(server.GetClients()) == 1 })
+	if _, err := sm.SendCommand(Command{Type: CmdStop, Channel: "CH002"}); !errors.Is(err, ErrTPTOffline) {
+		t.Fatalf("err = %v, want ErrTPTOffline", err)
+	}
+}
+
+func TestEndToEndDrainRejectsCommands(t *testing.T) {
+	sm := NewStateManager(2)
+	server := startTCPServer(t, sm, HandshakePolicy{Mode: LinkModeOff})
+	tpt := dialTPT(t, server.Addr().String())
+
+	tpt.send(map[string]interface{}{"type": "LINK", "msg_id": "D1", "work_station_name": "WS1", "state": "Online-Auto", "channel_count": "2"})
+	tpt.expect("LINK_ACK")
+
+	msgID, err := sm.SendCommand(Command{Type: CmdStop, Channel: "CH001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpt.expect("STOP")
+
+	server.Drain()
+	if _, err := sm.SendCommand(Command{Type: CmdStop, Channel: "CH002"}); err == nil {
+		t.Fatal("commands must be rejected while draining")
+	}
+
+	// 關閉中仍可收到先前命令的 ACK
+	tpt.send(map[string]interface{}{"type": "STOP_ACK", "msg_id": "D2", "reply_to": msgID, "channel": "CH001", "ack": models.AckOK})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if pending := sm.WaitForPendingCommands(ctx); pending != 0 {
+		t.Fatalf("%d command(s) still pending", pending)
+	}
+
+	if err := server.NotifyShutdown("MES_SHUTDOWN"); err != nil {
+		t.Fatal(err)
+	}
+	if notice := tpt.expect("MES_SHUTDOWN"); notice["work_station_name"] != "WS1" {
+		t.Fatalf("unexpected shutdown notice: %v", notice)
+	}
+}
+
+func TestEndToEndFuzzStopsWhenCancelled(t *testing.T) {
+	sm := NewStateManager(2)
+	server := startTCPServer(t, sm, HandshakePolicy{Mode: LinkModeOff})
+	httpServer := NewHTTPServer(0, sm, server, fstest.MapFS{})
+	tpt := dialTPT(t, server.Addr().String())
+	waitUntil(t, "connection registered", func() bool { return len(server.GetClients()) == 1 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	delay := 0
+	resp, err := httpServer.runFuzz(ctx, FuzzRequest{Template: "STOP", Count: 100, DelayMs: &delay, Seed: 1})
+	if !errors.Is(err, context.Canceled) || resp == nil || len(resp.Frames) != 1 {
+		t.Fatalf("runFuzz = %+v, %v; want one frame and context.Canceled", resp, err)
+	}
+	tpt.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := tpt.reader.ReadByte(); err != nil {
+		t.Fatalf("first frame was not sent: %v", err)
+	}
+}
+
+// expectClosed 確認伺服器已關閉連線（或拒絕交握）
+func (c *tptClient) expectClosed(what string) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if data, err := ReadMessage(c.reader); err == nil {
+		c.t.Fatalf("%s: connection still open, got %s", what, data)
+	} else if errors.Is(err, os.ErrDeadlineExceeded) {
+		c.t.Fatalf("%s: connection was not closed", what)
+	}
+}
+
+func TestEndToEndConnectionLimit(t *testing.T) {
+	sm := NewStateManager(1)
+	server := startTCPServer(t, sm, HandshakePolicy{Mode: LinkModeOff}, func(s *TCPServer) {
+		s.SetAdmissionPolicy(AdmissionPolicy{MaxConnections: 1})
+	})
+
+	first := dialTPT(t, server.Addr().String())
+	waitUntil(t, "first connection registered", func() bool { return len(server.GetClients()) == 1 })
+	dialTPT(t, server.Addr().String()).expectClosed("connection over the limit")
+
+	// 第一條連線關閉後可以再連線
+	first.conn.Close()
+	waitUntil(t, "first connection removed", func() bool { return len(server.GetClients()) == 0 })
+	dialTPT(t, server.Addr().String())
+	waitUntil(t, "reconnection registered", func() bool { return len(server.GetClients()) == 1 })
+}
+
+func TestEndToEndDuplicateWorkStation(t *testing.T) {
+	link := func(c *tptClient, msgID string) {
+		c.send(map[string]interface{}{"type": "LINK", "msg_id": msgID, "work_station_name": "WS1", "state": "Online-Auto", "channel_count": "1"})
+	}
+
+	t.Run("replace", func(t *testing.T) {
+		server := startTCPServer(t, NewStateManager(1), HandshakePolicy{Mode: LinkModeNG}, func(s *TCPServer) {
+			s.SetAdmissionPolicy(AdmissionPolicy{DuplicateWorkStation: DuplicateReplace})
+		})
+		first := dialTPT(t, server.Addr().String())
+		link(first, "R1")
+		first.expect("LINK_ACK")
+
+		second := dialTPT(t, server.Addr().String())
+		link(second, "R2")
+		if ack := second.expect("LINK_ACK"); ack["ack"] != models.AckOK {
+			t.Fatalf("replacement LINK_ACK = %v", ack)
+		}
+		first.expectClosed("replaced connection")
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		server := startTCPServer(t, NewStateManager(1), HandshakePolicy{Mode: LinkModeNG}, func(s *TCPServer) {
+			s.SetAdmissionPolicy(AdmissionPolicy{DuplicateWorkStation: DuplicateReject})
+		})
+		first := dialTPT(t, server.Addr().String())
+		link(first, "J1")
+		first.expect("LINK_ACK")
+
+		second := dialTPT(t, server.Addr().String())
+		link(second, "J2")
+		if ack := second.expect("LINK_ACK"); ack["ack"] != models.AckNG {
+			t.Fatalf("duplicate LINK_ACK = %v", ack)
+		}
+		second.expectClosed("rejected duplicate")
+
+		// 原本的連線不受影響
+		first.send(map[string]interface{}{"type": "STATUS", "msg_id": "J3", "work_station_name": "WS1", "channel": "CH001", "state": models.StateStandBy})
+		first.expect("STATUS_ACK")
+	})
+}
+
+func TestEndToEndMutualTLS(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, ca.pem, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{ca.issue(t, "localhost", true)}, MinVersion: tls.VersionTLS12}
+	if err := RequireClientCerts(config, caFile); err != nil {
+		t.Fatal(err)
+	}
+
+	sm := NewStateManager(1)
+	server := startTCPServer(t, sm, HandshakePolicy{Mode: LinkModeNG}, func(s *TCPServer) {
+		s.SetTLSConfig(config, map[string]string{"TPT-01": "WS1"})
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	dial := func(certs ...tls.Certificate) *tptClient {
+		t.Helper()
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 2 * time.Second}, "tcp", server.Addr().String(),
+			&tls.Config{RootCAs: roots, Certificates: certs, ServerName: "127.0.0.1", MinVersion: tls.VersionTLS12})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return &tptClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	}
+	link := map[string]interface{}{"type": "LINK", "msg_id": "T1", "work_station_name": "WS1", "state": "Online-Auto", "channel_count": "1"}
+
+	// 對應到工作站的憑證可以連線
+	tpt := dial(ca.issue(t, "TPT-01", false))
+	tpt.send(link)
+	if ack := tpt.expect("LINK_ACK"); ack["ack"] != models.AckOK {
+		t.Fatalf("LINK_ACK = %v", ack)
+	}
+	waitUntil(t, "client bound to WS1", func() bool {
+		clients := server.GetClients()
+		return len(clients) == 1 && clients[0].TLS && clients[0].WorkStation == "WS1"
+	})
+
+	// 沒有憑證、其他 CA 簽發、或未對應到工作站的憑證都會被拒絕
+	for name, certs := range map[string][]tls.Certificate{
+		"no certificate": nil,
+		"unknown CA":     {newTestCA(t, "Other CA").issue(t, "TPT-01", false)},
+		"unmapped CN":    {ca.issue(t, "TPT-99", false)},
+	} {
+		client := dial(certs...)
+		client.conn.Write([]byte("{}\r\n")) // TLS 1.3 的客戶端憑證在第一次讀寫時才驗證
+		client.expectClosed(name)
+	}
+	waitUntil(t, "rejected connections removed", func() bool { return len(server.GetClients()) == 1 })
+}
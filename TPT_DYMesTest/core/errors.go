@@ -0,0 +1,73 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 錯誤分類（以 errors.Is 判斷，API 依分類決定 HTTP 狀態碼）
+var (
+	ErrInvalidRequest  = errors.New("invalid request")  // 請求內容不正確
+	ErrNotFound        = errors.New("not found")        // 通道、排程、規則等不存在
+	ErrConflict        = errors.New("conflict")         // 資源已存在
+	ErrStateConflict   = errors.New("state conflict")   // 通道狀態不允許此命令
+	ErrTPTOffline      = errors.New("TPT offline")      // TPT 未連線
+	ErrSendFailed      = errors.New("send failed")      // 寫入 TCP 失敗
+	ErrFeatureDisabled = errors.New("feature disabled") // 功能未啟用
+	ErrUnauthorized    = errors.New("unauthorized")     // 未登入或認證資訊錯誤
+	ErrForbidden       = errors.New("forbidden")        // 角色權限不足
+)
+
+// classifiedError 帶有分類與細節的錯誤（Error() 只回傳訊息，維持原本的錯誤文字）
+type classifiedError struct {
+	kind    error
+	message string
+	details map[string]interface{}
+	cause   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.message
+}
+
+func (e *classifiedError) Unwrap() []error {
+	if e.cause == nil {
+		return []error{e.kind}
+	}
+	return []error{e.kind, e.cause}
+}
+
+// newError 建立分類錯誤（format 可使用 %w 包裝原始錯誤）
+func newError(kind error, details map[string]interface{}, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	return &classifiedError{
+		kind:    kind,
+		message: err.Error(),
+		details: details,
+		cause:   errors.Unwrap(err),
+	}
+}
+
+// ErrorDetails 取得錯誤的細節欄位（沒有時回傳 nil）
+func ErrorDetails(err error) map[string]interface{} {
+	var ce *classifiedError
+	if errors.As(err, &ce) {
+		return ce.details
+	}
+	return nil
+}
+
+// errTPTNotConnected TPT 未連線時所有命令共用的錯誤
+var errTPTNotConnected = newError(ErrTPTOffline, nil, "TPT is not connected")
+
+// errChannelNotFound 通道不存在
+func errChannelNotFound(channelID string) error {
+	return newError(ErrNotFound, map[string]interface{}{"channel": channelID},
+		"channel %s does not exist", channelID)
+}
+
+// errChannelState 通道狀態不允許此命令
+func errChannelState(ch *ChannelState, format string, args ...interface{}) error {
+	return newError(ErrStateConflict, map[string]interface{}{"channel": ch.ChannelID, "state": ch.State},
+		format, args...)
+}
@@ -0,0 +1,98 @@
+package core
+
+import "encoding/json"
+
+// DefaultEventHistorySize 預設保留的廣播事件數量
+const DefaultEventHistorySize = 1000
+
+// historyEntry 歷史事件（已序列化）
+type historyEntry struct {
+	seq uint64
+	msg []byte
+}
+
+// eventHistory 固定大小的廣播事件環狀緩衝區（非執行緒安全，由 HTTPServer.wsClientsMu 保護）
+type eventHistory struct {
+	entries []historyEntry
+	start   int // 最舊事件的位置
+	count   int // 目前保存的事件數量
+}
+
+// newEventHistory 建立新的事件環狀緩衝區
+func newEventHistory(size int) *eventHistory {
+	if size < 0 {
+		size = 0
+	}
+	return &eventHistory{entries: make([]historyEntry, size)}
+}
+
+// add 加入事件，緩衝區已滿時覆寫最舊的事件
+func (h *eventHistory) add(seq uint64, msg []byte) {
+	if len(h.entries) == 0 {
+		return
+	}
+	if h.count < len(h.entries) {
+		h.entries[(h.start+h.count)%len(h.entries)] = historyEntry{seq: seq, msg: msg}
+		h.count++
+		return
+	}
+	h.entries[h.start] = historyEntry{seq: seq, msg: msg}
+	h.start = (h.start + 1) % len(h.entries)
+}
+
+// oldestSeq 取得最舊事件的序號（沒有事件時回傳 0）
+func (h *eventHistory) oldestSeq() uint64 {
+	if h.count == 0 {
+		return 0
+	}
+	return h.entries[h.start].seq
+}
+
+// since 取得序號大於 seq 的所有事件
+// 若 seq 之後的事件已被覆寫（缺口過大），ok 為 false
+func (h *eventHistory) since(seq, latest uint64) (msgs [][]byte, ok bool) {
+	if seq > latest {
+		// 客戶端的序號比伺服器新（例如伺服器已重啟）
+		return nil, false
+	}
+	if seq == latest {
+		return nil, true
+	}
+	if h.count == 0 || seq+1 < h.oldestSeq() {
+		return nil, false
+	}
+
+	for i := 0; i < h.count; i++ {
+		entry := h.entries[(h.start+i)%len(h.entries)]
+		if entry.seq > seq {
+			msgs = append(msgs, entry.msg)
+		}
+	}
+	return msgs, true
+}
+
+// EventHistoryPage 最近廣播事件的匯出結果
+type EventHistoryPage struct {
+	OldestSeq uint64            `json:"oldest_seq"` // 伺服器仍保留的最舊事件序號
+	LatestSeq uint64            `json:"latest_seq"` // 最後一則廣播事件的序號
+	Events    []json.RawMessage `json:"events"`     // 與 /ws、/api/events 相同格式的事件（由舊到新）
+}
+
+// recentEvents 取得序號大於 since 且符合訂閱條件的保留事件
+// since 早於最舊的保留事件時，從最舊的事件開始回傳
+func (s *HTTPServer) recentEvents(since uint64, filter *eventFilter) EventHistoryPage {
+	s.wsClientsMu.Lock()
+	page := EventHistoryPage{OldestSeq: s.history.oldestSeq(), LatestSeq: s.eventSeq, Events: []json.RawMessage{}}
+	if page.OldestSeq > 0 && since+1 < page.OldestSeq {
+		since = page.OldestSeq - 1
+	}
+	msgs, _ := s.history.since(since, s.eventSeq)
+	s.wsClientsMu.Unlock()
+
+	for _, msg := range msgs {
+		if filter.match(parseEventMeta(msg)) {
+			page.Events = append(page.Events, msg)
+		}
+	}
+	return page
+}
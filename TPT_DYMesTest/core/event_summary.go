@@ -0,0 +1,162 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EventSummary 廣播事件的摘要（ctl tail、匯出與 TUI 的訊息記錄共用）
+type EventSummary struct {
+	Seq         uint64
+	Kind        string // 結構化事件類型；通訊訊息為 message
+	Direction   string
+	Type        string // 訊息類型或事件類型
+	Channel     string
+	WorkStation string
+	Text        string // 一行文字摘要
+}
+
+// 摘要中省略的通訊訊息欄位（已另外顯示或意義不大）
+var summaryOmittedFields = map[string]bool{
+	"type": true, "channel": true, "channels": true, "timestamp": true, "work_station_name": true,
+}
+
+// SummarizeEvent 解析已序列化的廣播事件並產生摘要（無法解析的欄位留空）
+func SummarizeEvent(event []byte) EventSummary {
+	var fields map[string]json.RawMessage
+	json.Unmarshal(event, &fields)
+
+	var s EventSummary
+	json.Unmarshal(fields["seq"], &s.Seq)
+	s.Type = jsonString(fields["type"])
+	s.Direction = jsonString(fields["direction"])
+
+	if s.Direction != "" {
+		var data map[string]json.RawMessage
+		json.Unmarshal(fields["data"], &data)
+		s.Kind = "message"
+		s.Type = jsonString(data["type"])
+		s.Channel = jsonString(data["channel"])
+		s.WorkStation = jsonString(data["work_station_name"])
+
+		parts := []string{s.Direction, s.Type}
+		if s.Channel != "" {
+			parts = append(parts, s.Channel)
+		}
+		var channels []json.RawMessage
+		if json.Unmarshal(data["channels"], &channels) == nil && len(channels) > 0 {
+			parts = append(parts, fmt.Sprintf("channels=%d", len(channels)))
+		}
+		parts = append(parts, summaryKeyValues(data, summaryOmittedFields)...)
+		s.Text = strings.Join(parts, " ")
+		return s
+	}
+
+	s.Kind = s.Type
+	switch s.Type {
+	case "initial_state", EventConnectionUpdate:
+		var status map[string]interface{}
+		json.Unmarshal(fields["status"], &status)
+		s.WorkStation, _ = status["work_station_name"].(string)
+		s.Text = fmt.Sprintf("%s tpt_connected=%v work_station=%s tcp_clients=%v", s.Type, status["tpt_connected"], orDash(s.WorkStation), status["tcp_clients"])
+		var channels []ChannelState
+		if json.Unmarshal(fields["channels"], &channels) == nil && channels != nil {
+			s.Text += fmt.Sprintf(" channels=%d", len(channels))
+		}
+
+	case EventChannelUpdate:
+		var channels []ChannelState
+		json.Unmarshal(fields["channels"], &channels)
+		if len(channels) == 1 {
+			ch := channels[0]
+			s.Channel = ch.ChannelID
+			s.Text = fmt.Sprintf("%s %s %s", s.Type, ch.ChannelID, ch.State)
+			if ch.Barcode != "" {
+				s.Text += " barcode=" + ch.Barcode
+			}
+			if ch.Message != "" {
+				s.Text += " message=" + ch.Message
+			}
+			break
+		}
+		counts := make(map[string]int)
+		for _, ch := range channels {
+			counts[ch.State]++
+		}
+		states := make([]string, 0, len(counts))
+		for state, n := range counts {
+			states = append(states, fmt.Sprintf("%s=%d", state, n))
+		}
+		sort.Strings(states)
+		s.Text = fmt.Sprintf("%s %d channels %s", s.Type, len(channels), strings.Join(states, " "))
+
+	case EventCommandResult:
+		var result CommandResult
+		json.Unmarshal(event, &result)
+		s.Channel = result.Channel
+		parts := []string{s.Type, result.Command}
+		if result.Channel != "" {
+			parts = append(parts, result.Channel)
+		}
+		parts = append(parts, result.Status)
+		if result.Ack != "" {
+			parts = append(parts, result.Ack)
+		}
+		if result.MsgID != "" {
+			parts = append(parts, "msg_id="+result.MsgID)
+		}
+		if result.LatencyMs > 0 {
+			parts = append(parts, fmt.Sprintf("latency=%dms", result.LatencyMs))
+		}
+		if result.Message != "" {
+			parts = append(parts, "message="+result.Message)
+		}
+		s.Text = strings.Join(parts, " ")
+
+	default:
+		// gap_too_large 等其他事件
+		s.Text = strings.Join(append([]string{s.Type}, summaryKeyValues(fields, map[string]bool{"type": true, "seq": true})...), " ")
+	}
+	return s
+}
+
+// summaryKeyValues 將 JSON 物件欄位依名稱排序後輸出為 key=value
+func summaryKeyValues(fields map[string]json.RawMessage, omit map[string]bool) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if !omit[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := jsonString(fields[key])
+		if value == "" {
+			value = string(fields[key])
+		}
+		if value == `""` || value == "null" {
+			continue
+		}
+		parts = append(parts, key+"="+value)
+	}
+	return parts
+}
+
+// jsonString 取得 JSON 字串的內容（不是字串時回傳空字串）
+func jsonString(raw json.RawMessage) string {
+	var s string
+	json.Unmarshal(raw, &s)
+	return s
+}
+
+// orDash 空字串以 - 表示
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,36 @@
+package core
+
+import "testing"
+
+func TestSummarizeEvent(t *testing.T) {
+	tests := []struct {
+		event string
+		want  EventSummary
+	}{
+		{
+			`{"seq":3,"direction":"TPT->MES","data":{"type":"STATUS","channel":"CH002","state":"Alarm","msg_id":"9","work_station_name":"WS1","timestamp":"t"}}`,
+			EventSummary{Seq: 3, Kind: "message", Direction: "TPT->MES", Type: "STATUS", Channel: "CH002", WorkStation: "WS1", Text: "TPT->MES STATUS CH002 msg_id=9 state=Alarm"},
+		},
+		{
+			`{"seq":4,"type":"channel_update","channels":[{"ChannelID":"CH001","State":"Running","Barcode":"B1"}]}`,
+			EventSummary{Seq: 4, Kind: "channel_update", Type: "channel_update", Channel: "CH001", Text: "channel_update CH001 Running barcode=B1"},
+		},
+		{
+			`{"seq":5,"type":"channel_update","channels":[{"ChannelID":"CH001","State":"Running"},{"ChannelID":"CH002","State":"Alarm"},{"ChannelID":"CH003","State":"Running"}]}`,
+			EventSummary{Seq: 5, Kind: "channel_update", Type: "channel_update", Text: "channel_update 3 channels Alarm=1 Running=2"},
+		},
+		{
+			`{"seq":6,"type":"command_result","command":"START","channel":"CH001","msg_id":"M1","status":"acked","ack":"NG","message":"busy","latency_ms":12}`,
+			EventSummary{Seq: 6, Kind: "command_result", Type: "command_result", Channel: "CH001", Text: "command_result START CH001 acked NG msg_id=M1 latency=12ms message=busy"},
+		},
+		{
+			`{"seq":7,"type":"gap_too_large","oldest_seq":10,"latest_seq":20}`,
+			EventSummary{Seq: 7, Kind: "gap_too_large", Type: "gap_too_large", Text: "gap_too_large latest_seq=20 oldest_seq=10"},
+		},
+	}
+	for _, tt := range tests {
+		if got := SummarizeEvent([]byte(tt.event)); got != tt.want {
+			t.Errorf("SummarizeEvent(%s)\n got %+v\nwant %+v", tt.event, got, tt.want)
+		}
+	}
+}
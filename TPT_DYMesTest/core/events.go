@@ -0,0 +1,151 @@
+package core
+
+import (
+	"strings"
+	"time"
+)
+
+// 結構化事件類型（StateManager 內部狀態變更時推送到前端）
+const (
+	EventChannelUpdate    = "channel_update"    // 通道狀態變更
+	EventConnectionUpdate = "connection_update" // 連線狀態變更
+	EventCommandResult    = "command_result"    // 命令發送結果與 TPT ACK
+)
+
+// 命令結果狀態
+const (
+	CommandSent   = "sent"   // 已發送，等待 ACK
+	CommandFailed = "failed" // 驗證失敗或發送失敗
+	CommandAcked  = "acked"  // 收到 TPT ACK（ack 欄位為 OK/NG）
+)
+
+// pendingCommandTTL 等待 ACK 的命令保留時間
+const pendingCommandTTL = 5 * time.Minute
+
+// pendingCommand 已發送、等待 ACK 的命令
+type pendingCommand struct {
+	Type    string
+	Channel string
+	SentAt  time.Time
+}
+
+// CommandResult command_result 事件內容
+type CommandResult struct {
+	Type      string `json:"type"`                 // 固定為 command_result
+	Command   string `json:"command"`              // START/STOP/PAUSE/RESUME/RSP_STATUS
+	Channel   string `json:"channel,omitempty"`    // 通道編號
+	MsgID     string `json:"msg_id,omitempty"`     // 命令的 msg_id
+	Status    string `json:"status"`               // sent/failed/acked
+	Ack       string `json:"ack,omitempty"`        // OK/NG（status 為 acked 時）
+	Message   string `json:"message,omitempty"`    // 錯誤或 ACK 訊息
+	LatencyMs int64  `json:"latency_ms,omitempty"` // 命令到 ACK 的時間
+}
+
+// emitChannelUpdateLocked 推送通道狀態變更（呼叫端需持有鎖）
+func (sm *StateManager) emitChannelUpdateLocked(channelIDs ...string) {
+	if len(channelIDs) == 0 {
+		return
+	}
+
+	channels := make([]ChannelState, 0, len(channelIDs))
+	for _, channelID := range channelIDs {
+		if ch, exists := sm.channels[channelID]; exists {
+			channels = append(channels, *ch)
+		}
+	}
+	if len(channels) == 0 {
+		return
+	}
+
+	sm.broadcast(map[string]interface{}{
+		"type":     EventChannelUpdate,
+		"channels": channels,
+	})
+}
+
+// emitConnectionUpdateLocked 推送連線狀態變更（呼叫端需持有鎖）
+func (sm *StateManager) emitConnectionUpdateLocked() {
+	sm.broadcast(map[string]interface{}{
+		"type":   EventConnectionUpdate,
+		"status": sm.connectionStatusLocked(),
+	})
+}
+
+// emitCommandResultLocked 推送命令結果（呼叫端需持有鎖）
+func (sm *StateManager) emitCommandResultLocked(result CommandResult) {
+	result.Type = EventCommandResult
+	sm.broadcast(result)
+}
+
+// commandSentLocked 記錄已發送的命令並推送 command_result（呼叫端需持有鎖）
+func (sm *StateManager) commandSentLocked(cmdType, channelID, msgID string) {
+	now := time.Now()
+
+	// 清除過期未回覆的命令
+	for id, pending := range sm.pendingCommands {
+		if now.Sub(pending.SentAt) > pendingCommandTTL {
+			delete(sm.pendingCommands, id)
+		}
+	}
+
+	sm.pendingCommands[msgID] = &pendingCommand{
+		Type:    cmdType,
+		Channel: channelID,
+		SentAt:  now,
+	}
+
+	sm.emitCommandResultLocked(CommandResult{
+		Command: cmdType,
+		Channel: channelID,
+		MsgID:   msgID,
+		Status:  CommandSent,
+	})
+}
+
+// commandFailedLocked 推送命令失敗結果（呼叫端需持有鎖）
+func (sm *StateManager) commandFailedLocked(cmdType, channelID string, err error) {
+	sm.emitCommandResultLocked(CommandResult{
+		Command: cmdType,
+		Channel: channelID,
+		Status:  CommandFailed,
+		Message: err.Error(),
+	})
+}
+
+// handleCommandAck 處理 TPT 回覆的命令 ACK（START_ACK、STOP_ACK 等），不需回覆
+func (sm *StateManager) handleCommandAck(msgType string, msg map[string]interface{}) (interface{}, error) {
+	replyTo := stringField(msg, "reply_to")
+	ack := stringField(msg, "ack")
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	result := CommandResult{
+		Command: strings.TrimSuffix(msgType, "_ACK"),
+		Channel: normalizeChannelID(stringField(msg, "channel")),
+		MsgID:   replyTo,
+		Status:  CommandAcked,
+		Ack:     ack,
+		Message: stringField(msg, "message"),
+	}
+
+	if pending, exists := sm.pendingCommands[replyTo]; exists {
+		delete(sm.pendingCommands, replyTo)
+		result.Command = pending.Type
+		if result.Channel == "" {
+			result.Channel = pending.Channel
+		}
+		latency := time.Since(pending.SentAt)
+		result.LatencyMs = latency.Milliseconds()
+		sm.metrics.countAck(result.Command, ack, latency)
+	} else {
+		logState.Warn("ACK does not match any pending command", "type", msgType, "reply_to", replyTo)
+		sm.metrics.countAck(result.Command, ack, -1)
+	}
+
+	logState.Info("command acknowledged", "command", result.Command, "channel", result.Channel,
+		"ack", ack, "message", result.Message, "latency_ms", result.LatencyMs)
+	sm.emitCommandResultLocked(result)
+
+	return nil, nil
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"GoTestMES/models"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LINK 之前收到其他訊息，或工作站名稱不一致時的處理方式
+const (
+	LinkModeNG         = "ng"         // 回覆 NG 並忽略該訊息
+	LinkModeDisconnect = "disconnect" // 中斷連線
+	LinkModeOff        = "off"        // 不檢查（原本的行為）
+)
+
+// DefaultLinkTimeout 連線後等待 LINK 的預設時間
+const DefaultLinkTimeout = 30 * time.Second
+
+// HandshakePolicy 每條 TPT 連線的握手規則
+type HandshakePolicy struct {
+	Mode        string        // ng/disconnect/off
+	LinkTimeout time.Duration // 連線後需在此時間內送出 LINK（0 表示不限制，Mode 為 off 時不套用）
+}
+
+// ParseLinkMode 驗證 LINK 檢查模式
+func ParseLinkMode(mode string) (string, error) {
+	switch strings.ToLower(mode) {
+	case LinkModeNG, LinkModeDisconnect, LinkModeOff:
+		return strings.ToLower(mode), nil
+	default:
+		return "", fmt.Errorf("invalid LINK mode %q (ng, disconnect or off)", mode)
+	}
+}
+
+// SetHandshakePolicy 設定握手規則（需在 Start 之前呼叫）
+func (s *TCPServer) SetHandshakePolicy(policy HandshakePolicy) {
+	s.linkPolicy = policy
+}
+
+// linkDeadline 連線建立後等待 LINK 的期限（不限制時回傳零值）
+func (s *TCPServer) linkDeadline() time.Time {
+	policy := s.linkPolicy
+	if policy.Mode == LinkModeOff || policy.LinkTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(policy.LinkTimeout)
+}
+
+// checkHandshake 依連線的握手狀態檢查訊息
+// process 表示訊息可交給 StateManager 處理；keep 為 false 時呼叫端應關閉連線
+func (s *TCPServer) checkHandshake(client *tcpClient, msgType string, jsonData []byte) (process, keep bool) {
+	name := messageWorkStation(jsonData)
+
+	// 憑證對應的工作站只能以自己的名稱送出訊息
+	if client.certBound && name != "" && name != client.workStation {
+		logTCP.Warn("work_station_name does not match client certificate, closing connection",
+			"remote", client.id, "work_station", name, "certificate", client.workStation)
+		return false, false
+	}
+
+	// LINK 時登記工作站名稱，處理同名的重複連線
+	if msgType == "LINK" {
+		if !s.claimWorkStation(client, name) {
+			s.rejectLink(client, jsonData, "workstation is already connected")
+			return false, false
+		}
+		s.clientsMu.Lock()
+		firstLink := !client.linked
+		client.linked = true
+		s.clientsMu.Unlock()
+		if firstLink {
+			client.conn.SetReadDeadline(time.Time{})
+			logTCP.Info("linked", "remote", client.id, "work_station", name)
+		}
+		return true, true
+	}
+
+	mode := s.linkPolicy.Mode
+	if mode == LinkModeOff || mode == "" {
+		return true, true
+	}
+
+	var reason string
+	switch {
+	case !client.linked:
+		reason = fmt.Sprintf("LINK is required before %s", msgType)
+	case name != "" && name != client.workStation:
+		reason = fmt.Sprintf("work_station_name %q does not match LINK (%q)", name, client.workStation)
+	default:
+		return true, true
+	}
+
+	if mode == LinkModeDisconnect {
+		logTCP.Warn("handshake violation, closing connection", "remote", client.id, "reason", reason)
+		return false, false
+	}
+
+	logTCP.Warn("handshake violation, replying NG", "remote", client.id, "reason", reason)
+	s.replyNG(client, msgType, jsonData, reason)
+	return false, true
+}
+
+// replyNG 對 TPT 訊息回覆 NG（ACK 類訊息無法回覆，只記錄）
+func (s *TCPServer) replyNG(client *tcpClient, msgType string, jsonData []byte, reason string) {
+	if msgType == "" || strings.HasSuffix(msgType, "_ACK") {
+		return
+	}
+
+	msg := map[string]interface{}{}
+	json.Unmarshal(jsonData, &msg)
+
+	ack := map[string]interface{}{
+		"type":              msgType + "_ACK",
+		"timestamp":         models.GetTimestamp(),
+		"msg_id":            models.GenerateMsgID(),
+		"work_station_name": stringField(msg, "work_station_name"),
+		"reply_to":          stringField(msg, "msg_id"),
+		"ack":               models.AckNG,
+		"message":           reason,
+	}
+	if channel := stringField(msg, "channel"); channel != "" {
+		ack["channel"] = channel
+	}
+
+	if err := client.write(ack); err != nil {
+		logTCP.Warn("write failed", "remote", client.id, "error", err)
+		return
+	}
+	s.stateManager.broadcast(map[string]interface{}{
+		"direction": "MES->TPT",
+		"data":      ack,
+	})
+}
@@ -0,0 +1,299 @@
+package core
+
+import (
+	"GoTestMES/models"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 分段寫入的限制：寫入期間持有連線的寫入鎖，其他命令與 ACK 都必須等待
+const (
+	MaxChunkDelay     = time.Second      // 每段之間的最大延遲
+	MaxRawInjectDelay = 10 * time.Second // 單一封包所有分段延遲的總和上限
+	rawWriteTimeout   = 5 * time.Second  // 每段的寫入期限（TPT 不讀取時避免卡住）
+)
+
+// RawFrame 原始封包（直接寫入 TCP，不經過 json.Marshal）
+type RawFrame struct {
+	Data       []byte        // 封包內容
+	Terminator bool          // 是否在結尾加上 \r\n
+	ChunkSize  int           // 分段寫入的大小（0 表示一次寫入）
+	ChunkDelay time.Duration // 每段之間的延遲
+}
+
+// size 實際寫入的位元組數（含 \r\n）
+func (f RawFrame) size() int {
+	if f.Terminator {
+		return len(f.Data) + 2
+	}
+	return len(f.Data)
+}
+
+// chunkSize 每段的大小
+func (f RawFrame) chunkSize() int {
+	if f.ChunkSize <= 0 || f.ChunkSize > f.size() {
+		return f.size()
+	}
+	return f.ChunkSize
+}
+
+// TotalDelay 所有分段之間的延遲總和
+func (f RawFrame) TotalDelay() time.Duration {
+	if f.size() == 0 {
+		return 0
+	}
+	chunks := (f.size() + f.chunkSize() - 1) / f.chunkSize()
+	return time.Duration(chunks-1) * f.ChunkDelay
+}
+
+// DecodeRawData 依編碼方式解析原始資料（hex 或 text）
+// hex 允許空白與 0x 前綴，例如 "7b 22 74 0x0d 0x0a"
+func DecodeRawData(data, encoding string) ([]byte, error) {
+	switch strings.ToLower(encoding) {
+	case "", "text":
+		return []byte(data), nil
+	case "hex":
+		cleaned := strings.NewReplacer("0x", "", "0X", "", " ", "", "\n", "", "\r", "", "\t", "", ":", "").Replace(data)
+		decoded, err := hex.DecodeString(cleaned)
+		if err != nil {
+			return nil, newError(ErrInvalidRequest, nil, "invalid hex data: %w", err)
+		}
+		return decoded, nil
+	default:
+		return nil, newError(ErrInvalidRequest, nil, "unsupported encoding: %s", encoding)
+	}
+}
+
+// writeRaw 寫入原始封包（持有寫入鎖，確保分段之間不會插入其他訊息）
+func (c *tcpClient) writeRaw(frame RawFrame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	data := frame.Data
+	if frame.Terminator {
+		data = append(append([]byte{}, data...), 0x0D, 0x0A)
+	}
+
+	chunkSize := frame.chunkSize()
+	defer c.conn.SetWriteDeadline(time.Time{})
+
+	for offset := 0; offset < len(data); offset += chunkSize {
+		if offset > 0 && frame.ChunkDelay > 0 {
+			time.Sleep(frame.ChunkDelay)
+		}
+		end := min(offset+chunkSize, len(data))
+		c.conn.SetWriteDeadline(time.Now().Add(rawWriteTimeout))
+		if _, err := c.conn.Write(data[offset:end]); err != nil {
+			return newError(ErrSendFailed, nil, "failed to write raw chunk at offset %d: %w", offset, err)
+		}
+	}
+	return nil
+}
+
+// InjectRaw 將原始封包寫入指定的 TPT 連線（connID 為空時寫入所有連線）
+func (s *TCPServer) InjectRaw(connID string, frame RawFrame) error {
+	s.clientsMu.RLock()
+	targets := make([]*tcpClient, 0, len(s.clients))
+	for _, client := range s.clients {
+		if connID == "" || client.id == connID {
+			targets = append(targets, client)
+		}
+	}
+	s.clientsMu.RUnlock()
+
+	if len(targets) == 0 {
+		if connID == "" {
+			return newError(ErrTPTOffline, nil, "no TPT clients connected")
+		}
+		return newError(ErrNotFound, map[string]interface{}{"connection": connID}, "connection %s not found", connID)
+	}
+
+	var lastErr error
+	for _, client := range targets {
+		if err := client.writeRaw(frame); err != nil {
+			logTCP.Warn("raw inject failed", "remote", client.id, "error", err)
+			lastErr = err
+			continue
+		}
+		logTCP.Info("raw frame injected", "remote", client.id, "bytes", len(frame.Data),
+			"terminator", frame.Terminator, "chunk", frame.ChunkSize)
+	}
+
+	s.stateManager.broadcast(map[string]interface{}{
+		"direction": "MES->TPT",
+		"raw":       true,
+		"data": map[string]interface{}{
+			"type":       "RAW",
+			"hex":        hex.EncodeToString(frame.Data),
+			"text":       string(frame.Data),
+			"terminator": frame.Terminator,
+			"connection": connID,
+		},
+	})
+
+	return lastErr
+}
+
+// fuzzEpoch 變異範本時間戳記的基準時間（範本的時間與 msg_id 由 seed 決定，不使用目前時間）
+var fuzzEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.FixedZone("Asia/Taipei", 8*60*60))
+
+// Template 根據命令類型產生合法的命令作為變異基礎
+// timestamp、msg_id 由亂數產生器決定，相同 seed 產生相同的範本
+func (f *Fuzzer) Template(commandType, workStationName string) (map[string]interface{}, error) {
+	at := fuzzEpoch.Add(time.Duration(f.rng.Int63n(365*24*60*60)) * time.Second)
+	base := map[string]interface{}{
+		"type":              strings.ToUpper(commandType),
+		"timestamp":         models.FormatTimestamp(at),
+		"msg_id":            f.msgID(at),
+		"work_station_name": workStationName,
+	}
+
+	switch strings.ToUpper(commandType) {
+	case CmdStart:
+		base["channel"] = "CH001"
+		base["barcode"] = "A1234578900BE"
+		base["process"] = "TEST-20251201-001"
+		base["data_path"] = `C:\ThinkLab4\record`
+	case CmdStop, CmdPause, CmdResume:
+		base["channel"] = "CH001"
+	case CmdRspStatus:
+	case "LINK_ACK", "STATUS_ACK", "STATUS_ALL_ACK", "REPORT_ACK":
+		base["reply_to"] = f.msgID(at.Add(-time.Second))
+		base["ack"] = models.AckOK
+		base["message"] = ""
+	default:
+		return nil, newError(ErrInvalidRequest, nil, "unsupported fuzz template: %s", commandType)
+	}
+
+	return base, nil
+}
+
+// Fuzzer 封包變異產生器
+type Fuzzer struct {
+	rng *rand.Rand
+}
+
+// NewFuzzer 建立新的變異產生器（相同 seed 產生相同結果，便於重現）
+func NewFuzzer(seed int64) *Fuzzer {
+	return &Fuzzer{rng: rand.New(rand.NewSource(seed))}
+}
+
+// msgID 以亂數序號產生指定時間的訊息 ID
+func (f *Fuzzer) msgID(at time.Time) string {
+	return models.FormatMsgID(at, f.rng.Int63())
+}
+
+// FuzzFrame 變異後的封包
+type FuzzFrame struct {
+	Mutation   string `json:"mutation"`   // 變異方式
+	Hex        string `json:"hex"`        // 封包內容 (hex)
+	Terminator bool   `json:"terminator"` // 是否附加 \r\n
+	Data       []byte `json:"-"`
+}
+
+// fuzzMutations 所有變異方式
+var fuzzMutations = []string{
+	"bit_flip", "byte_insert", "byte_delete", "truncate", "duplicate",
+	"no_terminator", "split_terminator", "embedded_crlf", "invalid_escape",
+	"wrong_type", "missing_field", "null_field", "huge_value", "invalid_utf8",
+	"leading_garbage", "nested_depth",
+}
+
+// IsFuzzMutation 檢查是否為支援的變異方式
+func IsFuzzMutation(mutation string) bool {
+	for _, m := range fuzzMutations {
+		if m == mutation {
+			return true
+		}
+	}
+	return false
+}
+
+// Mutate 對合法命令套用一種隨機變異
+func (f *Fuzzer) Mutate(template map[string]interface{}) FuzzFrame {
+	mutation := fuzzMutations[f.rng.Intn(len(fuzzMutations))]
+	return f.MutateWith(template, mutation)
+}
+
+// MutateWith 對合法命令套用指定的變異
+func (f *Fuzzer) MutateWith(template map[string]interface{}, mutation string) FuzzFrame {
+	valid, _ := json.Marshal(template)
+	data := append([]byte{}, valid...)
+	terminator := true
+
+	switch mutation {
+	case "bit_flip":
+		i := f.rng.Intn(len(data))
+		data[i] ^= 1 << uint(f.rng.Intn(8))
+	case "byte_insert":
+		i := f.rng.Intn(len(data) + 1)
+		data = append(data[:i], append([]byte{byte(f.rng.Intn(256))}, data[i:]...)...)
+	case "byte_delete":
+		i := f.rng.Intn(len(data))
+		data = append(data[:i], data[i+1:]...)
+	case "truncate":
+		data = data[:f.rng.Intn(len(data))]
+	case "duplicate":
+		data = append(data, valid...)
+	case "no_terminator":
+		terminator = false
+	case "split_terminator":
+		// 只送出 \r，沒有 \n
+		data = append(data, 0x0D)
+		terminator = false
+	case "embedded_crlf":
+		i := 1 + f.rng.Intn(len(data)-1)
+		data = append(data[:i], append([]byte{0x0D, 0x0A}, data[i:]...)...)
+	case "invalid_escape":
+		data = bytes.Replace(data, []byte(`"type":"`), []byte(`"type":"\q\x`), 1)
+	case "wrong_type":
+		data = f.mutateField(template, func(m map[string]interface{}, key string) {
+			m[key] = []interface{}{12345, true}
+		})
+	case "missing_field":
+		data = f.mutateField(template, func(m map[string]interface{}, key string) {
+			delete(m, key)
+		})
+	case "null_field":
+		data = f.mutateField(template, func(m map[string]interface{}, key string) {
+			m[key] = nil
+		})
+	case "huge_value":
+		data = f.mutateField(template, func(m map[string]interface{}, key string) {
+			m[key] = strings.Repeat("A", 64*1024)
+		})
+	case "invalid_utf8":
+		data = bytes.Replace(data, []byte(`"type":"`), []byte("\"type\":\"\xff\xfe"), 1)
+	case "leading_garbage":
+		data = append([]byte{0x00, 0xFF, ' ', 'x'}, data...)
+	case "nested_depth":
+		data = []byte(strings.Repeat(`{"a":`, 512) + "1" + strings.Repeat("}", 512))
+	}
+
+	return FuzzFrame{
+		Mutation:   mutation,
+		Hex:        hex.EncodeToString(data),
+		Terminator: terminator,
+		Data:       data,
+	}
+}
+
+// mutateField 隨機挑選一個欄位並套用 JSON 層級的變異
+func (f *Fuzzer) mutateField(template map[string]interface{}, mutate func(map[string]interface{}, string)) []byte {
+	copied := make(map[string]interface{}, len(template))
+	keys := make([]string, 0, len(template))
+	for k, v := range template {
+		copied[k] = v
+		keys = append(keys, k)
+	}
+	// map 走訪順序不固定，排序後才能以 seed 重現
+	sort.Strings(keys)
+	mutate(copied, keys[f.rng.Intn(len(keys))])
+	data, _ := json.Marshal(copied)
+	return data
+}
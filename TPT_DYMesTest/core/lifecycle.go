@@ -0,0 +1,85 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultShutdownTimeout 關閉流程（等待 ACK、結束 HTTP 請求）的預設總時限
+const DefaultShutdownTimeout = 10 * time.Second
+
+// errShuttingDown 關閉中拒絕發送新命令
+var errShuttingDown = newError(ErrTPTOffline, nil, "server is shutting down")
+
+// Drain 進入關閉狀態：之後所有命令（API、排程、規則）都會被拒絕，已連線的 TPT 仍可回覆 ACK
+func (s *TCPServer) Drain() {
+	if s.draining.CompareAndSwap(false, true) {
+		logTCP.Info("draining, new commands are rejected")
+	}
+}
+
+// NotifyShutdown 通知所有 TPT 即將關閉（發送指定 type 的自訂命令，不受 Drain 限制）
+func (s *TCPServer) NotifyShutdown(commandType string) error {
+	cmd, err := s.stateManager.BuildUserCommand(map[string]interface{}{"type": commandType})
+	if err != nil {
+		return err
+	}
+
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+
+	targets := s.commandTargetsLocked()
+	if len(targets) == 0 {
+		return nil
+	}
+
+	var lastErr error
+	for _, client := range targets {
+		if err := client.write(cmd); err != nil {
+			logTCP.Warn("send failed", "remote", client.id, "error", err)
+			lastErr = err
+		}
+	}
+	logTCP.Info("shutdown notice sent", "type", commandType, "clients", len(targets))
+
+	s.stateManager.broadcast(map[string]interface{}{
+		"direction": "MES->TPT",
+		"data":      cmd,
+	})
+	return lastErr
+}
+
+// WaitForPendingCommands 等待已發送的命令收到 ACK（TPT 斷線或 ctx 結束時停止等待）
+// 回傳仍未回覆的命令數量
+func (sm *StateManager) WaitForPendingCommands(ctx context.Context) int {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		pending, connected := sm.pendingCommandCount()
+		if pending == 0 || !connected {
+			return pending
+		}
+
+		select {
+		case <-ctx.Done():
+			return pending
+		case <-ticker.C:
+		}
+	}
+}
+
+// pendingCommandCount 取得尚未過期、等待 ACK 的命令數量與 TPT 連線狀態
+func (sm *StateManager) pendingCommandCount() (int, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	count := 0
+	now := time.Now()
+	for _, pending := range sm.pendingCommands {
+		if now.Sub(pending.SentAt) <= pendingCommandTTL {
+			count++
+		}
+	}
+	return count, sm.isConnected
+}
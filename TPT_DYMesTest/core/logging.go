@@ -0,0 +1,389 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 日誌子系統（可分別設定等級）
+const (
+	LogProtocol = "protocol" // 封包讀寫（訊框、跳脫字元修正、Hex dump）
+	LogTCP      = "tcp"      // TPT 連線、TLS、准入、握手
+	LogState    = "state"    // 狀態管理、命令與 ACK、規則、排程
+	LogHTTP     = "http"     // HTTP API、身分驗證、稽核
+	LogWS       = "ws"       // WebSocket 與 SSE
+)
+
+// 日誌輸出格式
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+var (
+	// rootHandler 目前的輸出 handler（SetupLogging 之前輸出到 stderr）
+	rootHandler atomic.Pointer[slog.Handler]
+
+	// subsystems 各子系統的等級
+	subsystems = map[string]*slog.LevelVar{}
+
+	logProtocol = newSubsystemLogger(LogProtocol)
+	logTCP      = newSubsystemLogger(LogTCP)
+	logState    = newSubsystemLogger(LogState)
+	logHTTP     = newSubsystemLogger(LogHTTP)
+	logWS       = newSubsystemLogger(LogWS)
+
+	// hexDump 是否在 protocol debug 日誌中輸出原始位元組
+	hexDump atomic.Bool
+
+	logSettingsMu sync.Mutex
+	logSettings   LogConfig // 目前的設定（供 /api/logging 顯示）
+)
+
+func init() {
+	setRootHandler(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// setRootHandler 替換輸出 handler（各子系統的等級由 subsystemHandler 判斷）
+func setRootHandler(h slog.Handler) {
+	rootHandler.Store(&h)
+}
+
+// newSubsystemLogger 建立子系統 logger（預設 info 等級）
+func newSubsystemLogger(name string) *slog.Logger {
+	level := new(slog.LevelVar)
+	subsystems[name] = level
+	return slog.New(&subsystemHandler{level: level}).With("subsystem", name)
+}
+
+// subsystemHandler 依子系統等級過濾，再交給目前的 rootHandler 輸出
+// （rootHandler 可能在之後被替換，因此 With/WithGroup 記錄下來，輸出時才套用）
+type subsystemHandler struct {
+	level *slog.LevelVar
+	with  []func(slog.Handler) slog.Handler
+}
+
+func (h *subsystemHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := *rootHandler.Load()
+	for _, apply := range h.with {
+		out = apply(out)
+	}
+	return out.Handle(ctx, r)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.extend(func(out slog.Handler) slog.Handler { return out.WithAttrs(attrs) })
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.extend(func(out slog.Handler) slog.Handler { return out.WithGroup(name) })
+}
+
+func (h *subsystemHandler) extend(apply func(slog.Handler) slog.Handler) *subsystemHandler {
+	return &subsystemHandler{
+		level: h.level,
+		with:  append(append([]func(slog.Handler) slog.Handler{}, h.with...), apply),
+	}
+}
+
+// LogConfig 日誌設定
+type LogConfig struct {
+	Level      string            `json:"level"`          // 預設等級（debug/info/warn/error）
+	Levels     map[string]string `json:"levels"`         // 各子系統的等級
+	Format     string            `json:"format"`         // text/json
+	File       string            `json:"file,omitempty"` // 另外寫入的檔案（空白表示只輸出到 stderr）
+	MaxSizeMB  int               `json:"max_size_mb"`    // 檔案超過此大小時輪替（0 表示不輪替）
+	MaxBackups int               `json:"max_backups"`    // 保留的舊檔數量
+	HexDump    bool              `json:"hex_dump"`       // protocol debug 日誌是否包含 Hex dump
+	Output     io.Writer         `json:"-"`              // 取代 stderr 的輸出（例如 TUI 模式的日誌區）
+}
+
+// ParseLogLevel 解析日誌等級（debug/info/warn/error）
+func ParseLogLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (debug, info, warn or error)", level)
+	}
+	return l, nil
+}
+
+// ParseLogLevels 解析以逗號分隔的子系統等級，例如 "protocol=debug,ws=warn"
+func ParseLogLevels(list string) (map[string]string, error) {
+	levels := map[string]string{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, level, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid subsystem level %q (expected name=level)", item)
+		}
+		levels[strings.TrimSpace(name)] = strings.TrimSpace(level)
+	}
+	return levels, nil
+}
+
+// SetupLogging 依設定建立輸出 handler，並將標準 log 套件的輸出導向 slog
+// 有設定 File 時回傳的 Closer 需在結束前關閉（否則為 nil）
+func SetupLogging(config LogConfig) (io.Closer, error) {
+	if config.Level == "" {
+		config.Level = "info"
+	}
+	if config.Format == "" {
+		config.Format = LogFormatText
+	}
+	defaultLevel, err := ParseLogLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	var out io.Writer = os.Stderr
+	if config.Output != nil {
+		out = config.Output
+	}
+	var closer io.Closer
+	if config.File != "" {
+		file, err := openRotatingFile(config.File, int64(config.MaxSizeMB)*1024*1024, config.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		closer = file
+		out = io.MultiWriter(out, file)
+	}
+
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var handler slog.Handler
+	switch config.Format {
+	case LogFormatText:
+		handler = slog.NewTextHandler(out, options)
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(out, options)
+	default:
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, fmt.Errorf("invalid log format %q (text or json)", config.Format)
+	}
+
+	levels := map[string]string{}
+	for name := range subsystems {
+		levels[name] = config.Level
+	}
+	for name, level := range config.Levels {
+		levels[name] = level
+	}
+	config.Levels = levels
+	if err := applyLogLevels(levels); err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, err
+	}
+
+	setRootHandler(handler)
+	// 其他模組的 log.Printf 以 info 等級輸出（低於預設等級時略過）
+	slog.SetDefault(slog.New(&subsystemHandler{level: levelVar(defaultLevel)}))
+	hexDump.Store(config.HexDump)
+
+	logSettingsMu.Lock()
+	logSettings = config
+	logSettingsMu.Unlock()
+	return closer, nil
+}
+
+// levelVar 建立固定等級的 LevelVar
+func levelVar(level slog.Level) *slog.LevelVar {
+	v := new(slog.LevelVar)
+	v.Set(level)
+	return v
+}
+
+// applyLogLevels 設定子系統等級（先全部驗證，避免只套用一部分）
+func applyLogLevels(levels map[string]string) error {
+	parsed := make(map[string]slog.Level, len(levels))
+	for name, level := range levels {
+		if _, exists := subsystems[name]; !exists {
+			return newError(ErrInvalidRequest, nil, "unknown log subsystem %q (%s)", name, strings.Join(subsystemNames(), ", "))
+		}
+		l, err := ParseLogLevel(level)
+		if err != nil {
+			return newError(ErrInvalidRequest, nil, "%v", err)
+		}
+		parsed[name] = l
+	}
+	for name, level := range parsed {
+		subsystems[name].Set(level)
+	}
+	return nil
+}
+
+// subsystemNames 依字母順序列出子系統
+func subsystemNames() []string {
+	names := make([]string, 0, len(subsystems))
+	for name := range subsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// currentLogConfig 取得目前的日誌設定
+func currentLogConfig() LogConfig {
+	logSettingsMu.Lock()
+	defer logSettingsMu.Unlock()
+
+	config := logSettings
+	config.Levels = make(map[string]string, len(subsystems))
+	for name, level := range subsystems {
+		config.Levels[name] = strings.ToLower(level.Level().String())
+	}
+	config.HexDump = hexDump.Load()
+	return config
+}
+
+// LogSettingsRequest 執行期間調整日誌設定（省略的欄位維持不變）
+type LogSettingsRequest struct {
+	Levels  map[string]string `json:"levels,omitempty"`   // 例如 {"protocol": "debug"}
+	HexDump *bool             `json:"hex_dump,omitempty"` // 開關 protocol Hex dump
+}
+
+// updateLogSettings 套用 LogSettingsRequest
+func updateLogSettings(req LogSettingsRequest) (LogConfig, error) {
+	if err := applyLogLevels(req.Levels); err != nil {
+		return LogConfig{}, err
+	}
+	if req.HexDump != nil {
+		hexDump.Store(*req.HexDump)
+	}
+	config := currentLogConfig()
+	logHTTP.Info("logging settings changed", "levels", config.Levels, "hex_dump", config.HexDump)
+	return config, nil
+}
+
+// handleLogging 查詢或調整日誌設定（調整需 admin 權限）
+//
+//	GET /api/logging
+//	PUT /api/logging {"levels": {"protocol": "debug"}, "hex_dump": true}
+func (s *HTTPServer) handleLogging(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, currentLogConfig())
+
+	case http.MethodPut:
+		var req LogSettingsRequest
+		if err := decodeBody(r, &req); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		config, err := updateLogSettings(req)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, config)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiGetLogging GET /api/v1/logging
+func (s *HTTPServer) apiGetLogging(r *http.Request, params map[string]string) (interface{}, error) {
+	return currentLogConfig(), nil
+}
+
+// apiUpdateLogging PUT /api/v1/logging
+func (s *HTTPServer) apiUpdateLogging(r *http.Request, params map[string]string) (interface{}, error) {
+	var req LogSettingsRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	return updateLogSettings(req)
+}
+
+// rotatingFile 超過大小上限時輪替的日誌檔（file -> file.1 -> file.2 ...）
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// openRotatingFile 以附加模式開啟日誌檔
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write 寫入一筆日誌（超過上限時先輪替；單筆日誌不會被拆到兩個檔案）
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate 關閉目前的檔案並將舊檔依序改名（超過 maxBackups 的舊檔刪除）
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+
+	if f.maxBackups <= 0 {
+		os.Remove(f.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		os.Rename(f.path, f.path+".1")
+	}
+	return f.open()
+}
+
+// Close 關閉日誌檔
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
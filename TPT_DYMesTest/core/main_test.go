@@ -0,0 +1,19 @@
+package core
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// 測試輸出只保留錯誤日誌
+	closer, err := SetupLogging(LogConfig{Level: "error"})
+	if err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	if closer != nil {
+		closer.Close()
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,302 @@
+package core
+
+import (
+	"GoTestMES/models"
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 訊息方向（metrics 標籤）
+const (
+	DirectionIn  = "in"  // TPT -> MES
+	DirectionOut = "out" // MES -> TPT
+)
+
+// ackLatencyBuckets 命令到 ACK 延遲的 histogram 區間（秒）
+var ackLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// histogram 延遲分佈（counts[i] 為落在 buckets[i-1] 與 buckets[i] 之間的次數，輸出時再累加）
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Metrics 執行期間的統計（以 Prometheus 文字格式輸出）
+type Metrics struct {
+	mu         sync.Mutex
+	messages   map[[2]string]uint64  // [type, direction] -> 次數
+	acks       map[[2]string]uint64  // [command, ack] -> 次數
+	ackLatency map[string]*histogram // command -> 延遲分佈
+
+	parseErrors   atomic.Uint64 // 無法解析的訊框（過大、非 JSON、缺少 type）
+	escapeRepairs atomic.Uint64 // 修正的不合法跳脫字元數量
+}
+
+// newMetrics 建立空的統計
+func newMetrics() *Metrics {
+	return &Metrics{
+		messages:   make(map[[2]string]uint64),
+		acks:       make(map[[2]string]uint64),
+		ackLatency: make(map[string]*histogram),
+	}
+}
+
+// otherLabel 協定未定義的訊息類型、命令與 ACK 結果一律歸為此標籤，避免標籤組合無限增加
+const otherLabel = "other"
+
+// metricMessageTypes 協定定義的訊息類型（其他類型如自訂命令記為 otherLabel）
+var metricMessageTypes = map[string]bool{
+	"LINK": true, "STATUS": true, "STATUS_ALL": true, "REPORT": true,
+	"LINK_ACK": true, "STATUS_ACK": true, "STATUS_ALL_ACK": true, "REPORT_ACK": true,
+	CmdStart: true, CmdStop: true, CmdPause: true, CmdResume: true, CmdRspStatus: true,
+	CmdStart + "_ACK": true, CmdStop + "_ACK": true, CmdPause + "_ACK": true, CmdResume + "_ACK": true, CmdRspStatus + "_ACK": true,
+}
+
+// messageTypeLabel 將訊息類型轉為 metrics 標籤
+func messageTypeLabel(msgType string) string {
+	switch {
+	case msgType == "":
+		return "unknown"
+	case metricMessageTypes[msgType]:
+		return msgType
+	default:
+		return otherLabel
+	}
+}
+
+// commandLabel 將命令類型轉為 metrics 標籤
+func commandLabel(command string) string {
+	switch command {
+	case CmdStart, CmdStop, CmdPause, CmdResume, CmdRspStatus:
+		return command
+	default:
+		return otherLabel
+	}
+}
+
+// ackLabel 將 ACK 結果轉為 metrics 標籤
+func ackLabel(ack string) string {
+	switch ack {
+	case "":
+		return "unknown"
+	case models.AckOK, models.AckNG:
+		return ack
+	default:
+		return otherLabel
+	}
+}
+
+// countMessage 記錄一則 TCP 訊息（m 為 nil 時不記錄）
+func (m *Metrics) countMessage(msgType, direction string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.messages[[2]string{messageTypeLabel(msgType), direction}]++
+	m.mu.Unlock()
+}
+
+// countAck 記錄 TPT 對命令的 ACK；latency 小於 0 表示找不到對應的命令
+func (m *Metrics) countAck(command, ack string, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	command, ack = commandLabel(command), ackLabel(ack)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.acks[[2]string{command, ack}]++
+	if latency < 0 {
+		return
+	}
+
+	h, exists := m.ackLatency[command]
+	if !exists {
+		h = &histogram{counts: make([]uint64, len(ackLatencyBuckets))}
+		m.ackLatency[command] = h
+	}
+	seconds := latency.Seconds()
+	for i, bound := range ackLatencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// countParseError 記錄一次解析失敗
+func (m *Metrics) countParseError() {
+	if m != nil {
+		m.parseErrors.Add(1)
+	}
+}
+
+// countEscapeRepairs 記錄修正的跳脫字元數量
+func (m *Metrics) countEscapeRepairs(n int) {
+	if m != nil {
+		m.escapeRepairs.Add(uint64(n))
+	}
+}
+
+// metricsWriter 以 Prometheus 文字格式（0.0.4）輸出
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+// header 輸出 HELP 與 TYPE
+func (w *metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(&w.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample 輸出一筆資料（labels 為 key, value 交錯）
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.buf.WriteString(name)
+	if len(labels) > 0 {
+		w.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&w.buf, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		w.buf.WriteByte('}')
+	}
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(formatValue(value))
+	w.buf.WriteByte('\n')
+}
+
+// escapeLabel 跳脫標籤值中的反斜線、雙引號與換行
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatValue 格式化數值（整數不帶小數點）
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// sortedKeys 依字母順序排列標籤組合，讓輸出穩定
+func sortedKeys(m map[[2]string]uint64) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+// writeCounters 輸出累計的計數器與 histogram
+func (m *Metrics) writeCounters(w *metricsWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.header("dymes_messages_total", "counter", "TCP messages by type and direction (in: TPT->MES, out: MES->TPT).")
+	for _, key := range sortedKeys(m.messages) {
+		w.sample("dymes_messages_total", float64(m.messages[key]), "type", key[0], "direction", key[1])
+	}
+
+	w.header("dymes_acks_total", "counter", "Command ACKs received from TPT by command and result.")
+	for _, key := range sortedKeys(m.acks) {
+		w.sample("dymes_acks_total", float64(m.acks[key]), "command", key[0], "ack", key[1])
+	}
+
+	w.header("dymes_parse_errors_total", "counter", "TCP frames that could not be parsed.")
+	w.sample("dymes_parse_errors_total", float64(m.parseErrors.Load()))
+
+	w.header("dymes_escape_repairs_total", "counter", "Invalid JSON escape sequences repaired in incoming messages.")
+	w.sample("dymes_escape_repairs_total", float64(m.escapeRepairs.Load()))
+
+	commands := make([]string, 0, len(m.ackLatency))
+	for command := range m.ackLatency {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	w.header("dymes_command_ack_latency_seconds", "histogram", "Time from sending a command to receiving its ACK.")
+	for _, command := range commands {
+		h := m.ackLatency[command]
+		var cumulative uint64
+		for i, bound := range ackLatencyBuckets {
+			cumulative += h.counts[i]
+			w.sample("dymes_command_ack_latency_seconds_bucket", float64(cumulative), "command", command, "le", formatValue(bound))
+		}
+		w.sample("dymes_command_ack_latency_seconds_bucket", float64(h.count), "command", command, "le", "+Inf")
+		w.sample("dymes_command_ack_latency_seconds_sum", h.sum, "command", command)
+		w.sample("dymes_command_ack_latency_seconds_count", float64(h.count), "command", command)
+	}
+}
+
+// handleMetrics 以 Prometheus 文字格式輸出統計
+//
+//	GET /metrics
+func (s *HTTPServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var out metricsWriter
+	s.stateManager.metrics.writeCounters(&out)
+
+	// 連線數
+	out.header("dymes_tcp_clients", "gauge", "Connected TPT TCP clients.")
+	out.sample("dymes_tcp_clients", float64(s.tcpServer.GetClientCount()))
+
+	s.wsClientsMu.Lock()
+	wsClients := len(s.wsClients)
+	events, evicted, dropped := s.eventSeq, s.evictedCount, s.droppedCount
+	s.wsClientsMu.Unlock()
+
+	out.header("dymes_ws_clients", "gauge", "Connected WebSocket and SSE clients.")
+	out.sample("dymes_ws_clients", float64(wsClients))
+
+	// 各狀態的通道數（沒有通道的已知狀態也輸出 0，讓圖表連續）
+	states := map[string]int{}
+	for _, state := range knownStates {
+		states[state] = 0
+	}
+	for _, ch := range s.stateManager.GetAllChannels() {
+		states[ch.State]++
+	}
+	names := make([]string, 0, len(states))
+	for state := range states {
+		names = append(names, state)
+	}
+	sort.Strings(names)
+
+	out.header("dymes_channels", "gauge", "Channels per state.")
+	for _, state := range names {
+		out.sample("dymes_channels", float64(states[state]), "state", state)
+	}
+
+	// 廣播
+	out.header("dymes_broadcast_events_total", "counter", "Events broadcast to WebSocket and SSE clients.")
+	out.sample("dymes_broadcast_events_total", float64(events))
+	out.header("dymes_broadcast_evicted_clients_total", "counter", "Slow WebSocket/SSE clients evicted because their queue was full.")
+	out.sample("dymes_broadcast_evicted_clients_total", float64(evicted))
+	out.header("dymes_broadcast_dropped_total", "counter", "Broadcast events not delivered because a client was evicted.")
+	out.sample("dymes_broadcast_dropped_total", float64(dropped))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(out.buf.Bytes())
+}
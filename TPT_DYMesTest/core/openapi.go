@@ -0,0 +1,202 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// buildOpenAPI 由路由表產生 OpenAPI 3 文件（schema 以反射從 Go 型別產生，與實作保持一致）
+func buildOpenAPI(routes []apiRoute) map[string]interface{} {
+	gen := &schemaGenerator{schemas: make(map[string]interface{})}
+	errorRef := gen.schemaOf(reflect.TypeOf(APIErrorResponse{}))
+
+	paths := make(map[string]interface{})
+	for _, route := range routes {
+		item, _ := paths[APIPrefix+route.Path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[APIPrefix+route.Path] = item
+		}
+
+		op := map[string]interface{}{
+			"summary":     route.Summary,
+			"tags":        []string{route.Tag},
+			"operationId": operationID(route),
+		}
+
+		var parameters []interface{}
+		for _, segment := range strings.Split(route.Path, "/") {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				parameters = append(parameters, map[string]interface{}{
+					"name":     segment[1 : len(segment)-1],
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]interface{}{"type": "string"},
+				})
+			}
+		}
+		for _, q := range route.Query {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        q.Name,
+				"in":          "query",
+				"description": q.Description,
+				"schema":      map[string]interface{}{"type": q.Type},
+			})
+		}
+		if len(parameters) > 0 {
+			op["parameters"] = parameters
+		}
+
+		if route.Request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": gen.schemaOf(reflect.TypeOf(route.Request))},
+				},
+			}
+		}
+
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		if route.Stream != nil {
+			success["content"] = map[string]interface{}{
+				"text/event-stream": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			}
+		} else if route.Response != nil {
+			success["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": gen.schemaOf(reflect.TypeOf(route.Response))},
+			}
+		}
+		op["responses"] = map[string]interface{}{
+			strconv.Itoa(status): success,
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": errorRef},
+				},
+			},
+		}
+
+		item[strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "DYMesTest API",
+			"version":     "v1",
+			"description": "TPT MES 模擬器 REST API。錯誤回應統一為 {\"error\": {\"code\", \"message\", \"details\"}}。",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": gen.schemas},
+	}
+}
+
+// operationID 產生 operationId，例如 GET /channels/{id} -> get_channels_id
+func operationID(route apiRoute) string {
+	replacer := strings.NewReplacer("/", "_", "{", "", "}", "", ".", "_")
+	return strings.ToLower(route.Method) + replacer.Replace(route.Path)
+}
+
+// schemaGenerator 以反射產生 JSON Schema（具名 struct 放入 components.schemas）
+type schemaGenerator struct {
+	schemas map[string]interface{}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaOf 取得型別的 schema
+func (g *schemaGenerator) schemaOf(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, exists := g.schemas[t.Name()]; !exists {
+			g.schemas[t.Name()] = map[string]interface{}{} // 先佔位，避免遞迴型別無限展開
+			g.schemas[t.Name()] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		// interface{} 等任意值
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema 產生 struct 的 object schema（依 json tag 命名，omitempty 以外的欄位視為必填）
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Name
+		omitempty := false
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					omitempty = true
+				}
+			}
+		}
+
+		properties[name] = g.schemaOf(field.Type)
+		if !omitempty && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
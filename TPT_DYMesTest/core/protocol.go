@@ -3,41 +3,33 @@ package core
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
-	"os"
+	"log/slog"
 )
 
 // ReadMessage 從 TCP 連線讀取一個完整的訊息
 // 格式: [JSON]\r\n
 func ReadMessage(reader io.Reader) ([]byte, error) {
+	return readMessage(reader, nil)
+}
+
+// readMessage 同 ReadMessage，並將解析失敗與跳脫字元修正記錄到 m（nil 表示不記錄）
+func readMessage(reader io.Reader, m *Metrics) ([]byte, error) {
 	// 確保使用 bufio.Reader
 	bufReader, ok := reader.(*bufio.Reader)
 	if !ok {
-		log.Printf("[Protocol] Creating new bufio.Reader")
 		bufReader = bufio.NewReader(reader)
-	} else {
-		log.Printf("[Protocol] Using existing bufio.Reader")
 	}
 
-	// 檢查 buffer 中是否已有資料
-	buffered := bufReader.Buffered()
-	log.Printf("[Protocol] Buffered data available: %d bytes", buffered)
-
 	// 持續讀取直到獲得完整訊息
 	// 使用真正的 \r\n (0x0D 0x0A) 作為結束符號
-	loopCount := 0
 	var lineBytes []byte
 
 	for {
-		loopCount++
-		log.Printf("[Protocol] ========== Read Loop #%d ==========", loopCount)
-		log.Printf("[Protocol] >>> Reading byte by byte until \\r\\n (0x0D 0x0A)...")
-		os.Stdout.Sync()
-
 		// 逐 byte 讀取，直到遇到 \r\n (0x0D 0x0A)
 		for {
 			b, err := bufReader.ReadByte()
@@ -48,64 +40,51 @@ func ReadMessage(reader io.Reader) ([]byte, error) {
 
 			// 檢查是否已收到完整的 \r\n 結束符號 (0x0D 0x0A)
 			if len(lineBytes) >= 2 &&
-            lineBytes[len(lineBytes)-2] == 0x0D && 
-            lineBytes[len(lineBytes)-1] == 0x0A {
-            break // 找到結尾，跳出迴圈
-        	}
+				lineBytes[len(lineBytes)-2] == 0x0D &&
+				lineBytes[len(lineBytes)-1] == 0x0A {
+				break // 找到結尾，跳出迴圈
+			}
 
 			// 防止無限讀取（最大 10MB）
 			if len(lineBytes) > 10*1024*1024 {
-				log.Printf("[Protocol] ❌ Message too large: %d bytes", len(lineBytes))
-				os.Stdout.Sync()
+				logProtocol.Warn("message too large", "bytes", len(lineBytes))
+				m.countParseError()
 				return nil, fmt.Errorf("message too large: %d bytes", len(lineBytes))
 			}
 		}
 
-		log.Printf("[Protocol] <<< Complete message received!")
-		log.Printf("[Protocol] ✓ Received %d bytes (including terminator)", len(lineBytes))
-		
-
-		// 記錄原始資料（Hex dump 前 100 bytes）
-		dumpLen := min(100, len(lineBytes))
-		log.Printf("[Protocol] Raw bytes (hex): %s", hex.EncodeToString(lineBytes[:dumpLen]))
-		log.Printf("[Protocol] Raw string: %q", string(lineBytes[:dumpLen]))
-
-		// 顯示最後 2 bytes（應該是 \r\n）
-		log.Printf("[Protocol] Last 2 bytes (hex): %s", hex.EncodeToString(lineBytes[len(lineBytes)-2:]))
-		log.Printf("[Protocol] Last 2 bytes (string): %q", string(lineBytes[len(lineBytes)-2:]))
+		// 記錄原始資料（Hex dump 前 100 bytes，需開啟 hex_dump 且 protocol 為 debug 等級）
+		if hexDump.Load() && logProtocol.Enabled(context.Background(), slog.LevelDebug) {
+			dumpLen := min(100, len(lineBytes))
+			logProtocol.Debug("frame received",
+				"bytes", len(lineBytes),
+				"hex", hex.EncodeToString(lineBytes[:dumpLen]),
+				"raw", string(lineBytes[:dumpLen]))
+		}
 
 		// 移除結尾的 \r\n (2 bytes)
 		lineBytes = lineBytes[:len(lineBytes)-2]
-		log.Printf("[Protocol] ✓ Removed terminator, message length: %d bytes", len(lineBytes))
-
-		// 轉換為字串
-		line := string(lineBytes)
 
 		// 如果是空行，繼續讀取下一行
-		if len(line) == 0 {
-			log.Printf("[Protocol] Empty line, reading next...")
+		if len(lineBytes) == 0 {
+			logProtocol.Debug("empty line skipped")
 			continue
 		}
 
-		// 轉換為 bytes
-		jsonData := []byte(line)
-
-		// 驗證長度合理性（防止記憶體溢出攻擊）
-		if len(jsonData) > 10*1024*1024 { // 最大 10MB
-			log.Printf("[Protocol] ❌ Message too large: %d bytes", len(jsonData))
-			return nil, fmt.Errorf("message too large: %d bytes", len(jsonData))
-		}
+		jsonData := lineBytes
 
 		// 檢查是否以 { 開頭（基本的 JSON 格式檢查）
 		if jsonData[0] != '{' {
-			log.Printf("[Protocol] ❌ Invalid JSON: does not start with '{', got: %c", jsonData[0])
+			logProtocol.Warn("invalid JSON: does not start with '{'", "first_byte", string(jsonData[:1]))
+			m.countParseError()
 			return nil, fmt.Errorf("invalid JSON format: does not start with '{', got: %s", string(jsonData[:min(50, len(jsonData))]))
 		}
 
-		log.Printf("[Protocol] ✓ Valid JSON format detected, length: %d", len(jsonData))
+		logProtocol.Debug("message received", "bytes", len(jsonData))
 
 		// 修正不合法的 JSON 跳脫字元（處理 Windows 路徑）
-		jsonData = fixInvalidEscapeSequences(jsonData)
+		jsonData, fixedCount := repairEscapeSequences(jsonData)
+		m.countEscapeRepairs(fixedCount)
 
 		return jsonData, nil
 	}
@@ -130,6 +109,12 @@ func max(a, b int) int {
 // fixInvalidEscapeSequences 修正 JSON 中不合法的跳脫字元
 // 主要處理 Windows 路徑中的單一反斜線問題
 func fixInvalidEscapeSequences(data []byte) []byte {
+	fixed, _ := repairEscapeSequences(data)
+	return fixed
+}
+
+// repairEscapeSequences 同 fixInvalidEscapeSequences，並回傳修正的數量
+func repairEscapeSequences(data []byte) ([]byte, int) {
 	var result bytes.Buffer
 	inString := false
 	escaped := false
@@ -164,7 +149,6 @@ func fixInvalidEscapeSequences(data []byte) []byte {
 				// 不合法的跳脫字元，在反斜線前再加一個反斜線
 				result.WriteByte('\\')
 				fixedCount++
-				log.Printf("[Protocol] Fixed invalid escape sequence: \\%c", ch)
 			}
 			result.WriteByte(ch)
 			escaped = false
@@ -177,15 +161,20 @@ func fixInvalidEscapeSequences(data []byte) []byte {
 	}
 
 	if fixedCount > 0 {
-		log.Printf("[Protocol] ✓ Fixed %d invalid escape sequences", fixedCount)
+		logProtocol.Debug("fixed invalid escape sequences", "count", fixedCount)
 	}
 
-	return result.Bytes()
+	return result.Bytes(), fixedCount
 }
 
 // WriteMessage 將訊息寫入 TCP 連線
 // 格式: [JSON] + \r\n (0x0D 0x0A)
 func WriteMessage(writer io.Writer, data interface{}) error {
+	return writeMessage(writer, data, nil)
+}
+
+// writeMessage 同 WriteMessage，並將送出的訊息記錄到 m（nil 表示不記錄）
+func writeMessage(writer io.Writer, data interface{}, m *Metrics) error {
 	// 1. 將資料序列化為 JSON
 	jsonData, err := json.Marshal(data)
 	if err != nil {
@@ -204,7 +193,12 @@ func WriteMessage(writer io.Writer, data interface{}) error {
 		return fmt.Errorf("failed to write terminator: %w", err)
 	}
 
-	log.Printf("[Protocol] ✓ Sent message with \\r\\n terminator (0x0D 0x0A)")
+	msgType, _ := ParseMessageType(jsonData)
+	m.countMessage(msgType, DirectionOut)
+	logProtocol.Debug("message sent", "type", msgType, "bytes", len(jsonData)+2, "payload", string(jsonData))
+	if hexDump.Load() && logProtocol.Enabled(context.Background(), slog.LevelDebug) {
+		logProtocol.Debug("frame sent", "hex", hex.EncodeToString(jsonData[:min(100, len(jsonData))]))
+	}
 
 	return nil
 }
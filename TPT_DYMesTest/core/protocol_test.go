@@ -0,0 +1,313 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string // 依序讀出的訊息
+		wantErr bool     // 讀完 want 之後的下一次讀取是否為非 EOF 錯誤
+	}{
+		{
+			name:  "single message",
+			input: `{"type":"LINK"}` + "\r\n",
+			want:  []string{`{"type":"LINK"}`},
+		},
+		{
+			name:  "multiple messages in one read",
+			input: `{"a":1}` + "\r\n" + `{"b":2}` + "\r\n",
+			want:  []string{`{"a":1}`, `{"b":2}`},
+		},
+		{
+			name:  "empty lines are skipped",
+			input: "\r\n\r\n" + `{"type":"STATUS"}` + "\r\n",
+			want:  []string{`{"type":"STATUS"}`},
+		},
+		{
+			name:  "bare LF is not a terminator",
+			input: "{\"a\":\n1}\r\n",
+			want:  []string{"{\"a\":\n1}"},
+		},
+		{
+			name:  "bare CR is not a terminator",
+			input: "{\"a\":\r1}\r\n",
+			want:  []string{"{\"a\":\r1}"},
+		},
+		{
+			name:  "windows path is repaired",
+			input: `{"data_path":"D:\data\mes"}` + "\r\n",
+			want:  []string{`{"data_path":"D:\\data\\mes"}`},
+		},
+		{
+			name:    "not a JSON object",
+			input:   "hello\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "JSON array",
+			input:   "[1,2]\r\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(tt.input))
+			for i, want := range tt.want {
+				got, err := ReadMessage(reader)
+				if err != nil {
+					t.Fatalf("message %d: unexpected error: %v", i, err)
+				}
+				if string(got) != want {
+					t.Fatalf("message %d: got %q, want %q", i, got, want)
+				}
+			}
+
+			_, err := ReadMessage(reader)
+			if tt.wantErr {
+				if err == nil || errors.Is(err, io.EOF) {
+					t.Fatalf("expected a framing error, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, io.EOF) {
+				t.Fatalf("expected EOF after the last message, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReadMessageIncompleteFrame(t *testing.T) {
+	_, err := ReadMessage(strings.NewReader(`{"type":"LINK"}` + "\r"))
+	if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF for a frame without terminator, got %v", err)
+	}
+}
+
+func TestReadMessageAcceptsPlainReader(t *testing.T) {
+	got, err := ReadMessage(strings.NewReader(`{"type":"LINK"}` + "\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `{"type":"LINK"}` {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestFixInvalidEscapeSequences(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"no escapes", `{"a":"b"}`, `{"a":"b"}`},
+		{"valid escapes untouched", `{"a":"\" \\ \/ \b \f \n \r \t \u00e9"}`, `{"a":"\" \\ \/ \b \f \n \r \t \u00e9"}`},
+		{"windows path", `{"p":"C:\Users\demo"}`, `{"p":"C:\\Users\\demo"}`},
+		{"valid escape inside a path is kept", `{"p":"C:\temp"}`, `{"p":"C:\temp"}`},
+		{"escaped backslash before letter", `{"p":"C:\\Users"}`, `{"p":"C:\\Users"}`},
+		{"escaped quote keeps string open", `{"p":"a\"\d"}`, `{"p":"a\"\\d"}`},
+		{"outside of strings untouched", `{"a":1}\x`, `{"a":1}\x`},
+		{"mixed", `{"p":"D:\data\new","q":"\t"}`, `{"p":"D:\\data\new","q":"\t"}`},
+		{"multiple keys", `{"a":"\q","b":"\z"}`, `{"a":"\\q","b":"\\z"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(fixInvalidEscapeSequences([]byte(tt.input)))
+			if got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFixInvalidEscapeSequencesProducesValidJSON(t *testing.T) {
+	input := `{"type":"START","data_path":"D:\TPT\data\2024","barcode":"A\B"}`
+	got := fixInvalidEscapeSequences([]byte(input))
+
+	var msg map[string]string
+	if err := json.Unmarshal(got, &msg); err != nil {
+		t.Fatalf("repaired JSON is invalid: %v (%s)", err, got)
+	}
+	if msg["data_path"] != `D:\TPT\data\2024` {
+		t.Fatalf("data_path = %q", msg["data_path"])
+	}
+	if msg["barcode"] != `A\B` {
+		t.Fatalf("barcode = %q", msg["barcode"])
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	var buf bytes.Buffer
+	msg := map[string]string{"type": "START", "data_path": `D:\data`}
+	if err := WriteMessage(&buf, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.Bytes()
+	if !bytes.HasSuffix(out, []byte{0x0D, 0x0A}) {
+		t.Fatalf("frame does not end with CRLF: %q", out)
+	}
+	if bytes.Count(out, []byte{0x0D, 0x0A}) != 1 {
+		t.Fatalf("frame contains more than one CRLF: %q", out)
+	}
+
+	var decoded map[string]string
+	if err := json.Unmarshal(out[:len(out)-2], &decoded); err != nil {
+		t.Fatalf("payload is not JSON: %v", err)
+	}
+	if decoded["data_path"] != `D:\data` {
+		t.Fatalf("data_path = %q", decoded["data_path"])
+	}
+}
+
+func TestWriteMessageMarshalError(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, map[string]interface{}{"bad": make(chan int)}); err == nil {
+		t.Fatal("expected marshal error")
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("nothing should be written on marshal error, got %q", buf.Bytes())
+	}
+}
+
+// failingWriter 在寫入指定次數後回傳錯誤
+type failingWriter struct {
+	okWrites int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.okWrites == 0 {
+		return 0, errors.New("broken pipe")
+	}
+	w.okWrites--
+	return len(p), nil
+}
+
+func TestWriteMessageWriteError(t *testing.T) {
+	for okWrites, want := range []string{"payload", "terminator"} {
+		err := WriteMessage(&failingWriter{okWrites: okWrites}, map[string]string{"type": "STOP"})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("okWrites=%d: expected %s write error, got %v", okWrites, want, err)
+		}
+	}
+}
+
+func TestWriteReadRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	sent := map[string]string{"type": "USER", "text": "line1\r\nline2", "path": `C:\x`}
+	if err := WriteMessage(&buf, sent); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadMessage(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received map[string]string
+	if err := json.Unmarshal(got, &received); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range sent {
+		if received[k] != v {
+			t.Fatalf("%s = %q, want %q", k, received[k], v)
+		}
+	}
+}
+
+func TestParseMessageType(t *testing.T) {
+	msgType, err := ParseMessageType([]byte(`{"type":"STATUS_ALL","channels":[]}`))
+	if err != nil || msgType != "STATUS_ALL" {
+		t.Fatalf("got %q, %v", msgType, err)
+	}
+	if _, err := ParseMessageType([]byte(`{"type":`)); err == nil {
+		t.Fatal("expected error for truncated JSON")
+	}
+}
+
+func FuzzReadMessage(f *testing.F) {
+	f.Add([]byte(`{"type":"LINK"}` + "\r\n"))
+	f.Add([]byte("\r\n\r\n{\"a\":\"C:\\x\"}\r\n"))
+	f.Add([]byte("{\"a\":\r\n"))
+	f.Add([]byte("\r\r\n\n"))
+	f.Add([]byte("plain text\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bufio.NewReader(bytes.NewReader(data))
+		for i := 0; i < 100; i++ {
+			msg, err := ReadMessage(reader)
+			if err != nil {
+				return
+			}
+			if len(msg) == 0 || msg[0] != '{' {
+				t.Fatalf("message does not start with '{': %q", msg)
+			}
+			if bytes.Contains(msg, []byte("\r\n")) {
+				t.Fatalf("message contains the frame terminator: %q", msg)
+			}
+		}
+	})
+}
+
+func FuzzFixInvalidEscapeSequences(f *testing.F) {
+	f.Add([]byte(`{"p":"C:\Users\demo"}`))
+	f.Add([]byte(`{"a":"\" \\ \/ \b \f \n \r \t \u00e9"}`))
+	f.Add([]byte(`{"a":"\`))
+	f.Add([]byte(`"\\\"`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		got := fixInvalidEscapeSequences(data)
+
+		// 合法的 JSON 不應被修改
+		if json.Valid(data) && !bytes.Equal(got, data) {
+			t.Fatalf("valid JSON was modified: %q -> %q", data, got)
+		}
+		// 只會插入反斜線，不會刪除或改動其他位元組
+		if len(got) < len(data) {
+			t.Fatalf("output is shorter than input: %q -> %q", data, got)
+		}
+		if !bytes.Equal(bytes.ReplaceAll(got, []byte(`\`), nil), bytes.ReplaceAll(data, []byte(`\`), nil)) {
+			t.Fatalf("non-backslash bytes changed: %q -> %q", data, got)
+		}
+		// 修正是冪等的
+		if again := fixInvalidEscapeSequences(got); !bytes.Equal(again, got) {
+			t.Fatalf("not idempotent: %q -> %q -> %q", data, got, again)
+		}
+	})
+}
+
+func FuzzWriteReadRoundTrip(f *testing.F) {
+	f.Add("START", `D:\data`)
+	f.Add("", "\r\n")
+	f.Add("X", "\u2028\x00")
+
+	f.Fuzz(func(t *testing.T, msgType, value string) {
+		var buf bytes.Buffer
+		if err := WriteMessage(&buf, map[string]string{"type": msgType, "value": value}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadMessage(bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatalf("read back failed: %v", err)
+		}
+		var decoded map[string]string
+		if err := json.Unmarshal(got, &decoded); err != nil {
+			t.Fatalf("read back invalid JSON: %v", err)
+		}
+		// json.Marshal 會將不合法的 UTF-8 換成 U+FFFD，比對重新編碼後的結果
+		want, _ := json.Marshal(value)
+		gotValue, _ := json.Marshal(decoded["value"])
+		if !bytes.Equal(want, gotValue) {
+			t.Fatalf("value = %s, want %s", gotValue, want)
+		}
+	})
+}
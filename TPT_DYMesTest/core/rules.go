@@ -0,0 +1,390 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RuleCondition 規則觸發條件（"when X"）
+type RuleCondition struct {
+	MessageType string `json:"message_type"`       // 觸發的訊息類型：LINK/STATUS/STATUS_ALL/REPORT
+	Channel     string `json:"channel,omitempty"`  // 通道選擇器（STATUS/REPORT），空字串表示任意通道
+	State       string `json:"state,omitempty"`    // STATUS: 轉換後的新狀態；STATUS_ALL: 要計數的狀態
+	CountGT     int    `json:"count_gt,omitempty"` // STATUS_ALL: 指定狀態的通道數由不大於此值變為大於此值時觸發
+}
+
+// RuleAction 規則動作（"then Y"）
+// Command.Channel 為空或 "$channel" 時使用觸發訊息的通道；設定 Selector 時改為批次執行
+// START 的 barcode 支援 {channel}、{seq} 佔位符，process/data_path 為空時沿用通道上一次的設定
+type RuleAction struct {
+	Command  Command `json:"command"`
+	Selector string  `json:"selector,omitempty"`
+	DelayMs  int64   `json:"delay_ms,omitempty"`
+}
+
+// Rule 事件觸發規則
+type Rule struct {
+	ID        string        `json:"id"`
+	Name      string        `json:"name,omitempty"`
+	Enabled   bool          `json:"enabled"`
+	When      RuleCondition `json:"when"`
+	Then      RuleAction    `json:"then"`
+	FireCount int           `json:"fire_count"`
+	LastFired time.Time     `json:"last_fired"`
+	LastError string        `json:"last_error,omitempty"`
+
+	lastCount int // STATUS_ALL: 上一次的通道數（只在超過 count_gt 的那一次觸發）
+}
+
+// ruleSpec 規則設定檔與 API 的輸入格式（省略 enabled 欄位時預設為啟用）
+type ruleSpec struct {
+	Rule
+	Enabled *bool `json:"enabled"`
+}
+
+// rule 轉換為 Rule
+func (spec ruleSpec) rule() Rule {
+	rule := spec.Rule
+	rule.Enabled = spec.Enabled == nil || *spec.Enabled
+	return rule
+}
+
+// RulesEngine 規則引擎：在每則 TPT 訊息處理後評估規則
+type RulesEngine struct {
+	mu            sync.Mutex
+	stateManager  *StateManager
+	rules         []*Rule
+	batchInterval time.Duration
+	stopped       bool // 已停止（不再觸發規則，尚未執行的動作也會取消）
+}
+
+// NewRulesEngine 建立新的規則引擎，並掛載到 StateManager
+func NewRulesEngine(stateManager *StateManager) *RulesEngine {
+	engine := &RulesEngine{
+		stateManager:  stateManager,
+		batchInterval: DefaultBatchInterval,
+	}
+	stateManager.SetMessageHook(engine.Evaluate)
+	return engine
+}
+
+// SetBatchInterval 設定選擇器動作的批次發送間隔
+func (e *RulesEngine) SetBatchInterval(interval time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.batchInterval = interval
+}
+
+// LoadFile 從 JSON 設定檔載入規則（陣列格式）
+func (e *RulesEngine) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	var specs []ruleSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return fmt.Errorf("failed to parse rules file: %w", err)
+	}
+
+	for _, spec := range specs {
+		if _, err := e.Add(spec.rule()); err != nil {
+			return fmt.Errorf("rule %q: %w", spec.ID, err)
+		}
+	}
+
+	logState.Info("rules loaded", "count", len(specs), "file", path)
+	return nil
+}
+
+// Add 新增規則
+func (e *RulesEngine) Add(rule Rule) (*Rule, error) {
+	rule.When.MessageType = strings.ToUpper(rule.When.MessageType)
+	switch rule.When.MessageType {
+	case "LINK", "STATUS", "STATUS_ALL", "REPORT":
+	default:
+		return nil, newError(ErrInvalidRequest, nil, "unsupported message_type: %s", rule.When.MessageType)
+	}
+	if rule.When.State != "" && !isKnownState(rule.When.State) {
+		return nil, newError(ErrInvalidRequest, nil, "unknown state: %s", rule.When.State)
+	}
+	if rule.When.Channel != "" {
+		if _, err := e.stateManager.ResolveChannels(rule.When.Channel); err != nil {
+			return nil, err
+		}
+	}
+
+	rule.Then.Command.Type = strings.ToUpper(rule.Then.Command.Type)
+	switch rule.Then.Command.Type {
+	case CmdStart, CmdStop, CmdPause, CmdResume, CmdRspStatus:
+	default:
+		return nil, newError(ErrInvalidRequest, nil, "unsupported command type: %s", rule.Then.Command.Type)
+	}
+	if rule.Then.Selector != "" {
+		if err := checkBatchCommand(rule.Then.Command.Type); err != nil {
+			return nil, err
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if rule.ID == "" {
+		rule.ID = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	for _, existing := range e.rules {
+		if existing.ID == rule.ID {
+			return nil, newError(ErrConflict, nil, "rule %s already exists", rule.ID)
+		}
+	}
+	rule.FireCount = 0
+	rule.LastFired = time.Time{}
+	rule.LastError = ""
+
+	r := &rule
+	e.rules = append(e.rules, r)
+
+	copied := *r
+	return &copied, nil
+}
+
+// Remove 刪除規則
+func (e *RulesEngine) Remove(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, rule := range e.rules {
+		if rule.ID == id {
+			e.rules = append(e.rules[:i], e.rules[i+1:]...)
+			logState.Info("rule removed", "rule", id)
+			return nil
+		}
+	}
+	return newError(ErrNotFound, nil, "rule %s does not exist", id)
+}
+
+// SetEnabled 啟用或停用規則
+func (e *RulesEngine) SetEnabled(id string, enabled bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, rule := range e.rules {
+		if rule.ID == id {
+			rule.Enabled = enabled
+			rule.lastCount = 0
+			logState.Info("rule toggled", "rule", id, "enabled", enabled)
+			return nil
+		}
+	}
+	return newError(ErrNotFound, nil, "rule %s does not exist", id)
+}
+
+// List 取得所有規則
+func (e *RulesEngine) List() []Rule {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	list := make([]Rule, 0, len(e.rules))
+	for _, rule := range e.rules {
+		list = append(list, *rule)
+	}
+	return list
+}
+
+// Evaluate 評估所有規則（由 StateManager.HandleMessage 在處理完訊息後呼叫）
+// previousState 為通道處理訊息前的狀態，STATUS 規則只在狀態改變時觸發
+func (e *RulesEngine) Evaluate(msgType string, msg map[string]interface{}, previousState string) {
+	channelID := normalizeChannelID(stringField(msg, "channel"))
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.stopped {
+		return
+	}
+	for _, rule := range e.rules {
+		if !rule.Enabled || rule.When.MessageType != msgType {
+			continue
+		}
+		if !e.matches(rule, channelID, msg, previousState) {
+			continue
+		}
+
+		rule.FireCount++
+		rule.LastFired = time.Now()
+		logState.Info("rule fired", "rule", rule.ID, "name", rule.Name,
+			"trigger", msgType, "channel", channelID, "command", rule.Then.Command.Type)
+
+		e.stateManager.broadcast(map[string]interface{}{
+			"type":    "rule_fired",
+			"rule_id": rule.ID,
+			"name":    rule.Name,
+			"trigger": msgType,
+			"channel": channelID,
+			"action":  rule.Then.Command.Type,
+		})
+
+		cmd := e.buildCommand(rule, channelID)
+		id := rule.ID
+		selector := rule.Then.Selector
+		delay := time.Duration(rule.Then.DelayMs) * time.Millisecond
+		interval := e.batchInterval
+
+		// 動作一律非同步執行，避免在 TCP 讀取迴圈中送出命令（ACK 尚未回覆）
+		time.AfterFunc(delay, func() {
+			e.execute(id, cmd, selector, interval)
+		})
+	}
+}
+
+// Stop 停止規則引擎（之後收到的訊息不再觸發規則，延遲中的動作不會執行）
+func (e *RulesEngine) Stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stopped = true
+}
+
+// matches 檢查條件是否成立（呼叫端需持有鎖）
+func (e *RulesEngine) matches(rule *Rule, channelID string, msg map[string]interface{}, previousState string) bool {
+	cond := rule.When
+	switch cond.MessageType {
+	case "STATUS_ALL":
+		if cond.State == "" {
+			return true
+		}
+		count := 0
+		if channels, ok := msg["channels"].([]interface{}); ok {
+			for _, item := range channels {
+				if info, ok := item.(map[string]interface{}); ok &&
+					strings.EqualFold(stringField(info, "state"), cond.State) {
+					count++
+				}
+			}
+		}
+		// TPT 會定期重送 STATUS_ALL，只在通道數超過門檻的那一次觸發
+		previous := rule.lastCount
+		rule.lastCount = count
+		return previous <= cond.CountGT && count > cond.CountGT
+
+	case "STATUS", "REPORT":
+		// TPT 會定期重送相同狀態，STATUS 只在狀態轉換時觸發
+		if cond.MessageType == "STATUS" && strings.EqualFold(stringField(msg, "state"), previousState) {
+			return false
+		}
+		if cond.State != "" && !strings.EqualFold(stringField(msg, "state"), cond.State) {
+			return false
+		}
+		if cond.Channel != "" {
+			channelIDs, err := e.stateManager.ResolveChannels(cond.Channel)
+			if err != nil {
+				return false
+			}
+			for _, id := range channelIDs {
+				if id == channelID {
+					return true
+				}
+			}
+			return false
+		}
+		return true
+	}
+
+	return true
+}
+
+// buildCommand 依觸發通道組出要執行的命令（呼叫端需持有鎖）
+func (e *RulesEngine) buildCommand(rule *Rule, channelID string) Command {
+	cmd := rule.Then.Command
+	if cmd.Channel == "" || cmd.Channel == "$channel" {
+		cmd.Channel = channelID
+	}
+
+	if cmd.Type == CmdStart {
+		replacer := strings.NewReplacer(
+			"{channel}", cmd.Channel,
+			"{seq}", strconv.Itoa(rule.FireCount),
+		)
+		cmd.Barcode = replacer.Replace(cmd.Barcode)
+
+		if ch, ok := e.stateManager.GetChannel(cmd.Channel); ok {
+			if cmd.Process == "" {
+				cmd.Process = ch.Process
+			}
+			if cmd.DataPath == "" {
+				cmd.DataPath = ch.DataPath
+			}
+		}
+	}
+
+	return cmd
+}
+
+// execute 執行規則動作並記錄結果
+func (e *RulesEngine) execute(id string, cmd Command, selector string, interval time.Duration) {
+	e.mu.Lock()
+	stopped := e.stopped
+	e.mu.Unlock()
+	if stopped {
+		return
+	}
+
+	var runErr error
+	if selector != "" {
+		channelIDs, err := e.stateManager.ResolveChannels(selector)
+		if err != nil {
+			runErr = err
+		} else {
+			results, err := e.stateManager.ExecuteBatch(cmd, channelIDs, nil, interval)
+			failed := 0
+			for _, result := range results {
+				if !result.OK {
+					failed++
+				}
+			}
+			switch {
+			case err != nil:
+				runErr = err
+			case failed > 0:
+				runErr = fmt.Errorf("%d of %d channel(s) failed", failed, len(channelIDs))
+			}
+		}
+	} else {
+		runErr = e.stateManager.ExecuteCommand(cmd)
+	}
+
+	if runErr != nil {
+		logState.Warn("rule action failed", "rule", id, "command", cmd.Type, "error", runErr)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, rule := range e.rules {
+		if rule.ID == id {
+			rule.LastError = ""
+			if runErr != nil {
+				rule.LastError = runErr.Error()
+			}
+		}
+	}
+}
+
+// stringField 從 JSON map 取出字串欄位
+func stringField(msg map[string]interface{}, key string) string {
+	if v, ok := msg[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// normalizeChannelID 將 "ch003" 轉換為 "CH003"
+func normalizeChannelID(channelID string) string {
+	if len(channelID) >= 2 && channelID[:2] == "ch" {
+		return "CH" + channelID[2:]
+	}
+	return channelID
+}
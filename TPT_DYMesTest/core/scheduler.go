@@ -0,0 +1,344 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MinScheduleInterval 週期排程的最小間隔（每次執行都會寫入排程檔案，過短的間隔會持續灌命令與寫檔）
+const MinScheduleInterval = 100 * time.Millisecond
+
+// Schedule 排程命令
+type Schedule struct {
+	ID         string    `json:"id"`
+	Command    Command   `json:"command"`               // 要執行的命令
+	Selector   string    `json:"selector,omitempty"`    // 通道選擇器（設定時以批次方式執行，覆寫 command.channel）
+	NextRun    time.Time `json:"next_run"`              // 下次執行時間
+	IntervalMs int64     `json:"interval_ms,omitempty"` // 重複間隔（毫秒），0 表示只執行一次
+	CreatedAt  time.Time `json:"created_at"`
+	LastRun    time.Time `json:"last_run"`
+	RunCount   int       `json:"run_count"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// Scheduler 排程管理器（定時、延遲、週期性命令）
+type Scheduler struct {
+	mu            sync.Mutex
+	stateManager  *StateManager
+	filePath      string // 持久化檔案路徑（空字串表示不保存）
+	schedules     map[string]*Schedule
+	timers        map[string]*time.Timer
+	batchInterval time.Duration
+	stopped       bool
+}
+
+// NewScheduler 建立新的排程管理器
+func NewScheduler(stateManager *StateManager, filePath string) *Scheduler {
+	return &Scheduler{
+		stateManager:  stateManager,
+		filePath:      filePath,
+		schedules:     make(map[string]*Schedule),
+		timers:        make(map[string]*time.Timer),
+		batchInterval: DefaultBatchInterval,
+	}
+}
+
+// SetBatchInterval 設定選擇器排程的批次發送間隔
+func (sc *Scheduler) SetBatchInterval(interval time.Duration) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.batchInterval = interval
+}
+
+// Load 從檔案載入排程並啟動計時器
+// 已過期的單次排程會被捨棄（避免重啟後意外發送命令），週期排程則順延至下一個時間點
+func (sc *Scheduler) Load() error {
+	if sc.filePath == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(sc.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read schedule file: %w", err)
+	}
+
+	var schedules []*Schedule
+	if err := json.Unmarshal(data, &schedules); err != nil {
+		return fmt.Errorf("failed to parse schedule file: %w", err)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	now := time.Now()
+	minInterval := sc.minIntervalLocked().Milliseconds()
+	for _, schedule := range schedules {
+		// 手動編輯過的檔案可能含有過短的間隔
+		if schedule.IntervalMs > 0 && schedule.IntervalMs < minInterval {
+			logState.Warn("raising schedule interval to minimum", "schedule", schedule.ID,
+				"interval_ms", schedule.IntervalMs, "minimum_ms", minInterval)
+			schedule.IntervalMs = minInterval
+		}
+		if schedule.NextRun.Before(now) {
+			if schedule.IntervalMs <= 0 {
+				logState.Warn("dropping missed schedule", "schedule", schedule.ID,
+					"command", schedule.Command.Type, "at", schedule.NextRun.Format(time.RFC3339))
+				continue
+			}
+			interval := time.Duration(schedule.IntervalMs) * time.Millisecond
+			missed := now.Sub(schedule.NextRun)/interval + 1
+			schedule.NextRun = schedule.NextRun.Add(missed * interval)
+		}
+		sc.schedules[schedule.ID] = schedule
+		sc.armLocked(schedule)
+	}
+
+	logState.Info("schedules loaded", "count", len(sc.schedules), "file", sc.filePath)
+	return sc.saveLocked()
+}
+
+// Add 新增排程
+func (sc *Scheduler) Add(schedule Schedule) (*Schedule, error) {
+	if schedule.Command.Type == "" {
+		return nil, newError(ErrInvalidRequest, nil, "missing command type")
+	}
+	switch strings.ToUpper(schedule.Command.Type) {
+	case CmdStart, CmdStop, CmdPause, CmdResume:
+		if schedule.Command.Channel == "" && schedule.Selector == "" {
+			return nil, newError(ErrInvalidRequest, nil, "%s requires channel or selector", schedule.Command.Type)
+		}
+	case CmdRspStatus:
+	default:
+		return nil, newError(ErrInvalidRequest, nil, "unsupported command type: %s", schedule.Command.Type)
+	}
+	if schedule.IntervalMs < 0 {
+		return nil, newError(ErrInvalidRequest, nil, "interval_ms must not be negative")
+	}
+	if schedule.Selector != "" {
+		if err := checkBatchCommand(schedule.Command.Type); err != nil {
+			return nil, err
+		}
+		if _, err := sc.stateManager.ResolveChannels(schedule.Selector); err != nil {
+			return nil, err
+		}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.stopped {
+		return nil, newError(ErrFeatureDisabled, nil, "scheduler is stopped")
+	}
+	if minInterval := sc.minIntervalLocked(); schedule.IntervalMs > 0 && schedule.IntervalMs < minInterval.Milliseconds() {
+		return nil, newError(ErrInvalidRequest, map[string]interface{}{"minimum_ms": minInterval.Milliseconds()},
+			"interval_ms must be at least %d", minInterval.Milliseconds())
+	}
+
+	// 同一時間刻度內新增的排程 ID 相同時加上序號
+	base := strconv.FormatInt(time.Now().UnixNano(), 36)
+	schedule.ID = base
+	for n := 1; sc.schedules[schedule.ID] != nil; n++ {
+		schedule.ID = base + "-" + strconv.Itoa(n)
+	}
+	schedule.Command.Type = strings.ToUpper(schedule.Command.Type)
+	schedule.CreatedAt = time.Now()
+	if schedule.NextRun.IsZero() {
+		schedule.NextRun = schedule.CreatedAt
+	}
+
+	s := &schedule
+	sc.schedules[s.ID] = s
+	sc.armLocked(s)
+
+	logState.Info("schedule added", "schedule", s.ID, "command", s.Command.Type,
+		"at", s.NextRun.Format(time.RFC3339), "interval_ms", s.IntervalMs)
+
+	if err := sc.saveLocked(); err != nil {
+		logState.Error("failed to save schedules", "error", err)
+	}
+
+	copied := *s
+	return &copied, nil
+}
+
+// minIntervalLocked 週期排程允許的最小間隔（不小於批次發送間隔，呼叫端需持有鎖）
+func (sc *Scheduler) minIntervalLocked() time.Duration {
+	if sc.batchInterval > MinScheduleInterval {
+		return sc.batchInterval
+	}
+	return MinScheduleInterval
+}
+
+// Cancel 取消排程
+func (sc *Scheduler) Cancel(id string) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if _, exists := sc.schedules[id]; !exists {
+		return newError(ErrNotFound, nil, "schedule %s does not exist", id)
+	}
+
+	if timer, ok := sc.timers[id]; ok {
+		timer.Stop()
+		delete(sc.timers, id)
+	}
+	delete(sc.schedules, id)
+
+	logState.Info("schedule cancelled", "schedule", id)
+	return sc.saveLocked()
+}
+
+// List 取得所有排程（依下次執行時間排序）
+func (sc *Scheduler) List() []Schedule {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	list := make([]Schedule, 0, len(sc.schedules))
+	for _, schedule := range sc.schedules {
+		list = append(list, *schedule)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].NextRun.Before(list[j].NextRun)
+	})
+	return list
+}
+
+// Stop 停止所有計時器並保存排程
+func (sc *Scheduler) Stop() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.stopped = true
+	for id, timer := range sc.timers {
+		timer.Stop()
+		delete(sc.timers, id)
+	}
+	if err := sc.saveLocked(); err != nil {
+		logState.Error("failed to save schedules", "error", err)
+	}
+}
+
+// armLocked 為排程建立計時器（呼叫端需持有鎖）
+func (sc *Scheduler) armLocked(schedule *Schedule) {
+	id := schedule.ID
+	delay := time.Until(schedule.NextRun)
+	if delay < 0 {
+		delay = 0
+	}
+	sc.timers[id] = time.AfterFunc(delay, func() { sc.run(id) })
+}
+
+// run 執行排程（計時器觸發）
+func (sc *Scheduler) run(id string) {
+	sc.mu.Lock()
+	schedule, exists := sc.schedules[id]
+	if !exists || sc.stopped {
+		sc.mu.Unlock()
+		return
+	}
+	cmd := schedule.Command
+	selector := schedule.Selector
+	interval := sc.batchInterval
+	sc.mu.Unlock()
+
+	// 執行命令時不持有鎖，避免與 StateManager 互鎖
+	var runErr error
+	if selector != "" {
+		channelIDs, err := sc.stateManager.ResolveChannels(selector)
+		if err != nil {
+			runErr = err
+		} else {
+			results, err := sc.stateManager.ExecuteBatch(cmd, channelIDs, nil, interval)
+			failed := 0
+			for _, result := range results {
+				if !result.OK {
+					failed++
+				}
+			}
+			switch {
+			case err != nil:
+				runErr = err
+			case failed > 0:
+				runErr = fmt.Errorf("%d of %d channel(s) failed", failed, len(channelIDs))
+			}
+		}
+	} else {
+		runErr = sc.stateManager.ExecuteCommand(cmd)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	// 執行期間可能已被取消
+	schedule, exists = sc.schedules[id]
+	if !exists {
+		return
+	}
+
+	schedule.LastRun = time.Now()
+	schedule.RunCount++
+	schedule.LastError = ""
+	if runErr != nil {
+		schedule.LastError = runErr.Error()
+		logState.Warn("schedule failed", "schedule", id, "command", cmd.Type, "error", runErr)
+	} else {
+		logState.Info("schedule executed", "schedule", id, "command", cmd.Type)
+	}
+
+	delete(sc.timers, id)
+	if schedule.IntervalMs > 0 {
+		schedule.NextRun = schedule.NextRun.Add(time.Duration(schedule.IntervalMs) * time.Millisecond)
+		if schedule.NextRun.Before(schedule.LastRun) {
+			schedule.NextRun = schedule.LastRun.Add(time.Duration(schedule.IntervalMs) * time.Millisecond)
+		}
+		// 執行期間已停止時保留週期排程（重啟後載入），只是不再設定計時器
+		if !sc.stopped {
+			sc.armLocked(schedule)
+		}
+	} else {
+		delete(sc.schedules, id)
+	}
+
+	if err := sc.saveLocked(); err != nil {
+		logState.Error("failed to save schedules", "error", err)
+	}
+}
+
+// saveLocked 將排程寫入檔案（呼叫端需持有鎖）
+func (sc *Scheduler) saveLocked() error {
+	if sc.filePath == "" {
+		return nil
+	}
+
+	list := make([]*Schedule, 0, len(sc.schedules))
+	for _, schedule := range sc.schedules {
+		list = append(list, schedule)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedules: %w", err)
+	}
+
+	// 先寫入暫存檔再改名，避免寫到一半當機造成檔案損毀
+	tmpPath := sc.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write schedule file: %w", err)
+	}
+	if err := os.Rename(tmpPath, sc.filePath); err != nil {
+		return fmt.Errorf("failed to write schedule file: %w", err)
+	}
+	return nil
+}
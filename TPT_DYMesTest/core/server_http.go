@@ -1,12 +1,18 @@
 package core
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
-	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -14,136 +20,472 @@ import (
 // HTTPServer HTTP 與 WebSocket 伺服器
 type HTTPServer struct {
 	port         int
+	addr         string // 監聽位址（覆寫 port，例如 127.0.0.1:0）
 	stateManager *StateManager
 	tcpServer    *TCPServer
 	staticFS     fs.FS
 	upgrader     websocket.Upgrader
-	wsClients    map[*websocket.Conn]bool
-	wsClientsMu  sync.RWMutex
-	broadcast    chan interface{}
+	wsClients    map[*wsClient]bool
+	wsClientsMu  sync.Mutex
+	eventSeq     uint64         // 最後一則廣播事件的序號
+	evictedCount uint64         // 因佇列已滿而被踢除的客戶端數量
+	droppedCount uint64         // 因踢除而未送達的事件數量
+	history      *eventHistory  // 最近的廣播事件（供重連時補送）
+	rpcAcks      *rpcAckTracker // 等待 TPT ACK 的 WebSocket 命令
+
+	batchInterval time.Duration  // 批次命令預設發送間隔
+	scheduler     *Scheduler     // 排程管理器（可選）
+	rulesEngine   *RulesEngine   // 規則引擎（可選）
+	templates     *TemplateStore // 自訂命令範本（可選）
+
+	apiRoutes []apiRoute // /api/v1 路由表
+
+	auth           *Authenticator  // 身分驗證（nil 表示不驗證）
+	allowedOrigins map[string]bool // 允許的跨來源 Origin
+	audit          *AuditLog       // 操作稽核記錄（可選）
+	tlsConfig      *tls.Config     // HTTPS 設定（nil 表示 HTTP）
+
+	serverMu sync.Mutex
+	server   *http.Server // Start 後才有值
+	listener net.Listener
 }
 
 // NewHTTPServer 建立新的 HTTP 伺服器
 func NewHTTPServer(port int, stateManager *StateManager, tcpServer *TCPServer, staticFS fs.FS) *HTTPServer {
 	server := &HTTPServer{
-		port:         port,
-		stateManager: stateManager,
-		tcpServer:    tcpServer,
-		staticFS:     staticFS, // 儲存傳入的檔案系統
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool { return true },
-		},
-		wsClients: make(map[*websocket.Conn]bool),
-		broadcast: make(chan interface{}, 100),
+		port:          port,
+		stateManager:  stateManager,
+		tcpServer:     tcpServer,
+		staticFS:      staticFS, // 儲存傳入的檔案系統
+		wsClients:     make(map[*wsClient]bool),
+		history:       newEventHistory(DefaultEventHistorySize),
+		rpcAcks:       newRPCAckTracker(),
+		batchInterval: DefaultBatchInterval,
 	}
 
+	server.upgrader.CheckOrigin = server.checkOrigin
 	stateManager.SetBroadcastFunc(server.BroadcastToWebSocket)
-	go server.handleBroadcast()
 
 	return server
 }
 
-// Start 啟動 HTTP 伺服器
-func (s *HTTPServer) Start() error {
+// SetBatchInterval 設定批次命令預設發送間隔
+func (s *HTTPServer) SetBatchInterval(interval time.Duration) {
+	s.batchInterval = interval
+}
+
+// SetEventHistorySize 設定保留的廣播事件數量（需在 Start 之前呼叫）
+func (s *HTTPServer) SetEventHistorySize(size int) {
+	s.wsClientsMu.Lock()
+	defer s.wsClientsMu.Unlock()
+	s.history = newEventHistory(size)
+}
+
+// SetScheduler 設定排程管理器
+func (s *HTTPServer) SetScheduler(scheduler *Scheduler) {
+	s.scheduler = scheduler
+}
+
+// SetRulesEngine 設定規則引擎
+func (s *HTTPServer) SetRulesEngine(engine *RulesEngine) {
+	s.rulesEngine = engine
+}
+
+// SetTemplateStore 設定自訂命令範本儲存區
+func (s *HTTPServer) SetTemplateStore(templates *TemplateStore) {
+	s.templates = templates
+}
+
+// SetTLSConfig 啟用 HTTPS（需在 Start 之前呼叫）
+func (s *HTTPServer) SetTLSConfig(config *tls.Config) {
+	s.tlsConfig = config
+}
+
+// Scheme 回傳 Web 介面使用的協定（http 或 https）
+func (s *HTTPServer) Scheme() string {
+	if s.tlsConfig != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Handler 建立包含所有路由與身分驗證的 http.Handler（Start 使用，也可直接搭配 httptest）
+func (s *HTTPServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+
 	// 3. 修改：直接使用傳入的 staticFS
 	// 這裡不需要 fs.Sub，因為 main.go 已經處理好了
-	http.Handle("/", http.FileServer(http.FS(s.staticFS)))
+	mux.Handle("/", http.FileServer(http.FS(s.staticFS)))
+
+	mux.HandleFunc("/ws", s.handleWebSocket)
+	mux.HandleFunc("/api/events", s.handleEvents)
+	mux.HandleFunc("/api/status", s.handleGetStatus)
+	mux.HandleFunc("/api/channels", s.handleGetChannels)
+	mux.HandleFunc("/api/channels/", s.handleGetChannelDetail)
+	mux.HandleFunc("/api/snapshot", s.handleGetSnapshot)
+	mux.HandleFunc("/api/cmd/start", s.handleStartCommand)
+	mux.HandleFunc("/api/cmd/stop", s.handleStopCommand)
+	mux.HandleFunc("/api/cmd/pause", s.handlePauseCommand)
+	mux.HandleFunc("/api/cmd/resume", s.handleResumeCommand)
+	mux.HandleFunc("/api/cmd/rsp_status", s.handleRspStatusCommand)
+	mux.HandleFunc("/api/cmd/user_command", s.handleUserCommand)
+	mux.HandleFunc("/api/cmd/batch", s.handleBatchCommand)
+	mux.HandleFunc("/api/schedules", s.handleSchedules)
+	mux.HandleFunc("/api/schedules/", s.handleScheduleByID)
+	mux.HandleFunc("/api/rules", s.handleRules)
+	mux.HandleFunc("/api/rules/", s.handleRuleByID)
+	mux.HandleFunc("/api/templates", s.handleTemplates)
+	mux.HandleFunc("/api/templates/", s.handleTemplateByName)
+	mux.HandleFunc("/api/connections", s.handleGetConnections)
+	mux.HandleFunc("/api/raw", s.handleRawInject)
+	mux.HandleFunc("/api/raw/fuzz", s.handleRawFuzz)
+	mux.HandleFunc("/api/whoami", s.handleWhoAmI)
+	mux.HandleFunc("/api/audit", s.handleAudit)
+	mux.HandleFunc("/api/logging", s.handleLogging)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+
+	// 版本化 API（統一的錯誤格式與 OpenAPI 文件）
+	s.apiRoutes = s.apiV1Routes()
+	mux.HandleFunc(APIPrefix+"/", s.handleAPIv1)
+
+	return s.withAuth(mux)
+}
+
+// SetListenAddr 指定監聽位址（例如 "127.0.0.1:0" 由系統分配連接埠），需在 Start 之前呼叫
+func (s *HTTPServer) SetListenAddr(addr string) {
+	s.addr = addr
+}
 
-	http.HandleFunc("/ws", s.handleWebSocket)
-	http.HandleFunc("/api/status", s.handleGetStatus)
-	http.HandleFunc("/api/channels", s.handleGetChannels)
-	http.HandleFunc("/api/cmd/start", s.handleStartCommand)
-	http.HandleFunc("/api/cmd/stop", s.handleStopCommand)
-	http.HandleFunc("/api/cmd/pause", s.handlePauseCommand)
-	http.HandleFunc("/api/cmd/resume", s.handleResumeCommand)
-	http.HandleFunc("/api/cmd/rsp_status", s.handleRspStatusCommand)
-	http.HandleFunc("/api/cmd/user_command", s.handleUserCommand)
+// Start 啟動 HTTP 伺服器（連接埠綁定失敗時直接回傳錯誤）
+func (s *HTTPServer) Start() error {
+	addr := s.addr
+	if addr == "" {
+		addr = fmt.Sprintf(":%d", s.port)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP server: %w", err)
+	}
 
-	addr := fmt.Sprintf(":%d", s.port)
-	log.Printf("[HTTP] Server starting on http://localhost%s", addr)
+	server := &http.Server{
+		Handler:   s.Handler(),
+		TLSConfig: s.tlsConfig,
+	}
+	s.serverMu.Lock()
+	s.server = server
+	s.listener = listener
+	s.serverMu.Unlock()
+
+	logHTTP.Info("server starting", "url", fmt.Sprintf("%s://localhost:%d", s.Scheme(), s.Port()))
 
 	go func() {
-		if err := http.ListenAndServe(addr, nil); err != nil {
-			// 使用 Printf 避免 Port 佔用時直接閃退
-			log.Printf("[HTTP] ❌ Server Start Error (Check if port %d is used): %v", s.port, err)
+		var err error
+		if s.tlsConfig != nil {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logHTTP.Error("server stopped with error", "port", s.port, "error", err)
 		}
 	}()
 
 	return nil
 }
 
+// Addr 回傳實際監聽的位址（尚未啟動時回傳 nil）
+func (s *HTTPServer) Addr() net.Addr {
+	s.serverMu.Lock()
+	defer s.serverMu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Port 回傳實際監聽的連接埠（設定為 0 時由系統分配）
+func (s *HTTPServer) Port() int {
+	if addr, ok := s.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return s.port
+}
+
+// Shutdown 停止接受新連線並等待進行中的請求完成（ctx 結束時強制關閉）
+// WebSocket 客戶端會收到 1001 (going away) 關閉訊框，SSE 串流則直接結束
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	s.serverMu.Lock()
+	server := s.server
+	s.serverMu.Unlock()
+	if server == nil {
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Shutdown(ctx)
+	}()
+
+	// 事件串流不會自行結束，需主動關閉 Shutdown 才能完成
+	s.closeEventClients()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		server.Close()
+	}
+
+	// 關閉期間才完成升級的連線
+	s.closeEventClients()
+	logHTTP.Info("server stopped")
+	return err
+}
+
+// closeEventClients 關閉所有 WebSocket 與 SSE 客戶端
+func (s *HTTPServer) closeEventClients() {
+	s.wsClientsMu.Lock()
+	clients := make([]*wsClient, 0, len(s.wsClients))
+	for client := range s.wsClients {
+		clients = append(clients, client)
+	}
+	s.wsClients = make(map[*wsClient]bool)
+	s.wsClientsMu.Unlock()
+
+	for _, client := range clients {
+		client.shutdown()
+	}
+	if len(clients) > 0 {
+		logWS.Info("event clients closed", "count", len(clients))
+	}
+}
+
 // handleWebSocket 處理 WebSocket 連線
 func (s *HTTPServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// 連線時即可帶入訂閱條件，斷線續傳補送的事件也會套用
+	filter, err := compileFilter(EventFilterFromQuery(r.URL.Query()), s.stateManager.ChannelCount())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("[WS] Upgrade error: %v", err)
+		logWS.Warn("upgrade failed", "remote", r.RemoteAddr, "error", err)
 		return
 	}
 
-	log.Printf("[WS] New WebSocket connection from %s", conn.RemoteAddr())
+	identity, ok := IdentityFromContext(r.Context())
+	if !ok {
+		identity = Identity{Role: RoleAdmin, RemoteAddr: r.RemoteAddr}
+	}
+	logWS.Info("connection opened", "client", identity.String())
 
-	s.wsClientsMu.Lock()
-	s.wsClients[conn] = true
-	s.wsClientsMu.Unlock()
+	// 重連時帶 ?since=N，補送序號 N 之後的事件
+	newClient := func(queueSize int) *wsClient {
+		client := newWSClient(conn, conn.RemoteAddr().String(), queueSize, filter)
+		client.identity = identity
+		return client
+	}
+	var client *wsClient
+	if since, err := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64); err == nil {
+		var marker []byte
+		client, marker = s.resumeClient(conn.RemoteAddr().String(), since, filter, newClient)
+		if marker != nil {
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			conn.WriteMessage(websocket.TextMessage, marker)
+		}
+	}
 
-	// 發送當前狀態給新連線的客戶端
-	s.sendCurrentState(conn)
+	if client == nil {
+		client = newClient(wsSendQueueSize)
+		if err := s.registerWithSnapshot(client); err != nil {
+			logWS.Error("failed to encode initial state", "error", err)
+			conn.Close()
+			return
+		}
+	}
+
+	go client.writePump()
 
-	// 處理客戶端訊息（如果需要）
+	// 處理客戶端訊息（命令請求，以及偵測斷線與 pong）
 	go func() {
 		defer func() {
-			s.wsClientsMu.Lock()
-			delete(s.wsClients, conn)
-			s.wsClientsMu.Unlock()
-			conn.Close()
-			log.Printf("[WS] Connection closed: %s", conn.RemoteAddr())
+			s.removeWSClient(client)
+			logWS.Info("connection closed", "remote", conn.RemoteAddr().String())
 		}()
 
+		conn.SetReadLimit(64 * 1024)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+
 		for {
-			_, _, err := conn.ReadMessage()
+			_, data, err := conn.ReadMessage()
 			if err != nil {
 				break
 			}
+			// 每個請求各自處理，避免等待回覆的命令阻塞後續請求
+			go s.handleWSRequest(client, data)
 		}
 	}()
 }
 
-// sendCurrentState 發送當前狀態給 WebSocket 客戶端
-func (s *HTTPServer) sendCurrentState(conn *websocket.Conn) {
+// resumeClient 嘗試從歷史事件補送序號 since 之後的事件
+// 成功時回傳已註冊的客戶端；缺口過大時回傳 gap_too_large 標記（由呼叫端送出標記後改送快照）
+func (s *HTTPServer) resumeClient(remoteAddr string, since uint64, filter *eventFilter, newClient func(queueSize int) *wsClient) (*wsClient, []byte) {
+	s.wsClientsMu.Lock()
+	defer s.wsClientsMu.Unlock()
+
+	msgs, ok := s.history.since(since, s.eventSeq)
+	if !ok {
+		marker, _ := json.Marshal(map[string]interface{}{
+			"type":       "gap_too_large",
+			"since":      since,
+			"oldest_seq": s.history.oldestSeq(),
+			"latest_seq": s.eventSeq,
+		})
+		logWS.Info("resume not possible, sending snapshot", "remote", remoteAddr, "since", since, "latest", s.eventSeq)
+		return nil, marker
+	}
+
+	// 補送與註冊在同一個鎖內完成，確保不會遺漏或重複事件
+	client := newClient(len(msgs) + wsSendQueueSize)
+	for _, msg := range msgs {
+		if filter.match(parseEventMeta(msg)) {
+			client.enqueue(msg)
+		}
+	}
+	s.wsClients[client] = true
+
+	logWS.Info("resumed", "remote", remoteAddr, "since", since, "replayed", len(msgs))
+	return client, nil
+}
+
+// registerWithSnapshot 送出目前狀態快照並註冊客戶端
+func (s *HTTPServer) registerWithSnapshot(client *wsClient) error {
+	// 先記錄目前的 seq 再取得快照：若期間有新事件，客戶端會偵測到缺口並重新取得快照
+	s.wsClientsMu.Lock()
+	seq := s.eventSeq
+	s.wsClientsMu.Unlock()
+
+	snapshot, err := encodeEvent(s.currentState(), seq)
+	if err != nil {
+		return err
+	}
+
+	// 發送當前狀態給新連線的客戶端（經由佇列，確保與後續事件的順序一致）
+	s.wsClientsMu.Lock()
+	client.enqueue(snapshot)
+	s.wsClients[client] = true
+	s.wsClientsMu.Unlock()
+	return nil
+}
+
+// removeWSClient 移除並關閉 WebSocket 客戶端
+func (s *HTTPServer) removeWSClient(client *wsClient) {
+	s.wsClientsMu.Lock()
+	delete(s.wsClients, client)
+	s.wsClientsMu.Unlock()
+	client.close()
+}
+
+// currentState 取得當前狀態快照（initial_state 事件）
+func (s *HTTPServer) currentState() map[string]interface{} {
 	status := s.stateManager.GetConnectionStatus()
 	channels := s.stateManager.GetAllChannels()
 
-	data := map[string]interface{}{
+	return map[string]interface{}{
 		"type":     "initial_state",
 		"status":   status,
 		"channels": channels,
 	}
-
-	if err := conn.WriteJSON(data); err != nil {
-		log.Printf("[WS] Failed to send initial state: %v", err)
-	}
 }
 
-// BroadcastToWebSocket 廣播訊息到所有 WebSocket 客戶端
+// BroadcastToWebSocket 廣播訊息到所有 WebSocket 客戶端（每則事件附帶遞增的 seq）
 func (s *HTTPServer) BroadcastToWebSocket(data interface{}) {
-	select {
-	case s.broadcast <- data:
-	default:
-		log.Printf("[WS] Broadcast channel full, dropping message")
+	s.wsClientsMu.Lock()
+	defer s.wsClientsMu.Unlock()
+
+	s.eventSeq++
+	msg, err := encodeEvent(data, s.eventSeq)
+	if err != nil {
+		logWS.Error("failed to encode broadcast event", "error", err)
+		return
+	}
+
+	s.history.add(s.eventSeq, msg)
+	s.handleBroadcast(msg)
+	s.forwardAckLocked(data)
+
+	// 在 StateManager 與 wsClientsMu 的鎖內只排入佇列，由背景寫入稽核檔案
+	if result, ok := data.(CommandResult); ok && result.Status == CommandAcked && s.audit != nil {
+		s.audit.QueueAck(result)
 	}
 }
 
-// handleBroadcast 處理廣播訊息
-func (s *HTTPServer) handleBroadcast() {
-	for data := range s.broadcast {
-		s.wsClientsMu.RLock()
-		for conn := range s.wsClients {
-			if err := conn.WriteJSON(data); err != nil {
-				log.Printf("[WS] Broadcast error: %v", err)
+// handleBroadcast 將事件放入每個客戶端的發送佇列（呼叫端需持有 wsClientsMu）
+// 設有訂閱條件的客戶端只會收到符合條件的事件（事件欄位只在需要時解析一次）
+// 佇列已滿的慢速客戶端會被踢除，避免拖慢其他客戶端；重連後可依 seq 偵測缺口並重新取得快照
+func (s *HTTPServer) handleBroadcast(msg []byte) {
+	var meta *eventMeta
+	for client := range s.wsClients {
+		if client.filter != nil {
+			if meta == nil {
+				parsed := parseEventMeta(msg)
+				meta = &parsed
 			}
+			if !client.filter.match(*meta) {
+				continue
+			}
+		}
+		if client.enqueue(msg) {
+			continue
 		}
-		s.wsClientsMu.RUnlock()
+
+		s.evictWSClientLocked(client)
+	}
+}
+
+// evictWSClientLocked 踢除佇列已滿的客戶端（呼叫端需持有 wsClientsMu）
+func (s *HTTPServer) evictWSClientLocked(client *wsClient) {
+	logWS.Warn("client too slow (queue full), evicting", "remote", client.remoteAddr)
+	delete(s.wsClients, client)
+	client.close()
+	s.evictedCount++
+	s.droppedCount += uint64(len(client.send)) + 1
+}
+
+// GetWSClientCount 取得 WebSocket 客戶端數量
+func (s *HTTPServer) GetWSClientCount() int {
+	s.wsClientsMu.Lock()
+	defer s.wsClientsMu.Unlock()
+	return len(s.wsClients)
+}
+
+// handleGetSnapshot 取得當前狀態快照（附帶最後事件的 seq，供前端偵測到缺口後重新同步）
+func (s *HTTPServer) handleGetSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.wsClientsMu.Lock()
+	seq := s.eventSeq
+	s.wsClientsMu.Unlock()
+
+	snapshot, err := encodeEvent(s.currentState(), seq)
+	if err != nil {
+		http.Error(w, "Failed to encode snapshot", http.StatusInternalServerError)
+		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(snapshot)
 }
 
 // handleGetStatus 取得連線狀態
@@ -164,19 +506,54 @@ func (s *HTTPServer) handleGetStatus(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(status)
 }
 
-// handleGetChannels 取得所有通道狀態
+// handleGetChannels 取得通道狀態（可依 state、barcode_prefix、process 篩選，offset/limit 分頁）
+// 符合條件的總筆數放在 X-Total-Count 標頭，回應內容維持通道陣列
 func (s *HTTPServer) handleGetChannels(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	channels := s.stateManager.GetAllChannels()
+	query, err := ChannelQueryFromURL(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	channels, total := s.stateManager.QueryChannels(query)
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
 	json.NewEncoder(w).Encode(channels)
 }
 
+// handleGetChannelDetail 取得單一通道詳細資訊 GET /api/channels/{id}
+func (s *HTTPServer) handleGetChannelDetail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	detail, err := s.channelDetail(strings.TrimPrefix(r.URL.Path, "/api/channels/"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+// channelDetail 依通道編號（CH001、ch001、1）取得詳細資訊
+func (s *HTTPServer) channelDetail(id string) (ChannelDetail, error) {
+	channelID, err := canonicalChannelID(id)
+	if err != nil {
+		return ChannelDetail{}, err
+	}
+	detail, ok := s.stateManager.GetChannelDetail(channelID)
+	if !ok {
+		return ChannelDetail{}, errChannelNotFound(channelID)
+	}
+	return detail, nil
+}
+
 // CommandRequest 命令請求結構
 type CommandRequest struct {
 	Channel  string `json:"channel"`
@@ -346,9 +723,20 @@ func (s *HTTPServer) handleRspStatusCommand(w http.ResponseWriter, r *http.Reque
 	})
 }
 
+// 等待自訂命令回覆的逾時
+const (
+	DefaultReplyTimeout = 5 * time.Second  // 預設逾時
+	MaxReplyTimeout     = 60 * time.Second // timeout_ms 上限（避免請求長時間佔用等待回覆的資源）
+)
+
 // UserCommandRequest 自訂命令請求結構
 type UserCommandRequest struct {
-	Type string `json:"type"`
+	Type      string                 `json:"type,omitempty"`       // 命令類型（僅指定 type 時的簡易寫法）
+	Payload   map[string]interface{} `json:"payload,omitempty"`    // 任意 JSON 內容（覆寫範本欄位）
+	Template  string                 `json:"template,omitempty"`   // 使用已儲存的範本
+	SaveAs    string                 `json:"save_as,omitempty"`    // 將組合後的內容另存為範本
+	WaitReply bool                   `json:"wait_reply,omitempty"` // 等待 reply_to 相符的回覆
+	TimeoutMs int                    `json:"timeout_ms,omitempty"` // 等待逾時（毫秒），預設 5000，上限 60000
 }
 
 // handleUserCommand 處理自訂命令
@@ -364,12 +752,185 @@ func (s *HTTPServer) handleUserCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Type == "" {
-		http.Error(w, "Missing type field", http.StatusBadRequest)
+	timeout, err := req.replyTimeout()
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	payload, err := s.buildUserPayload(req)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	sent, reply, err := s.stateManager.SendUserCommandPayload(payload, timeout)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrReplyTimeout) {
+			status = http.StatusGatewayTimeout
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": err.Error(),
+			"sent":  sent,
+		})
+		return
+	}
+
+	result := map[string]interface{}{
+		"status": "ok",
+		"sent":   sent,
+	}
+	if req.WaitReply {
+		result["reply"] = reply
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// buildUserPayload 組合自訂命令內容：範本 -> type -> payload（後者覆寫前者）
+func (s *HTTPServer) buildUserPayload(req UserCommandRequest) (map[string]interface{}, error) {
+	payload := make(map[string]interface{})
+	if req.Template != "" {
+		if s.templates == nil {
+			return nil, errTemplatesDisabled
+		}
+		t, ok := s.templates.Get(req.Template)
+		if !ok {
+			return nil, newError(ErrNotFound, map[string]interface{}{"template": req.Template}, "Template not found")
+		}
+		for k, v := range t.Payload {
+			payload[k] = v
+		}
+	}
+	if req.Type != "" {
+		payload["type"] = req.Type
+	}
+	for k, v := range req.Payload {
+		payload[k] = v
+	}
+
+	if commandType, _ := payload["type"].(string); commandType == "" {
+		return nil, newError(ErrInvalidRequest, nil, "Missing type field")
+	}
+
+	if req.SaveAs != "" && s.templates != nil {
+		if err := s.templates.Save(CommandTemplate{Name: req.SaveAs, Payload: payload}); err != nil {
+			logHTTP.Warn("failed to save template", "template", req.SaveAs, "error", err)
+		}
+	}
+
+	return payload, nil
+}
+
+// replyTimeout 取得等待自訂命令回覆的逾時（不等待時回傳 0，超過 MaxReplyTimeout 時回傳錯誤）
+func (req UserCommandRequest) replyTimeout() (time.Duration, error) {
+	if req.TimeoutMs < 0 || int64(req.TimeoutMs) > MaxReplyTimeout.Milliseconds() {
+		return 0, newError(ErrInvalidRequest, map[string]interface{}{"timeout_ms": req.TimeoutMs},
+			"timeout_ms must be between 0 and %d", MaxReplyTimeout.Milliseconds())
+	}
+	if !req.WaitReply {
+		return 0, nil
+	}
+	if req.TimeoutMs > 0 {
+		return time.Duration(req.TimeoutMs) * time.Millisecond, nil
+	}
+	return DefaultReplyTimeout, nil
+}
+
+// handleTemplates 列出或儲存自訂命令範本
+func (s *HTTPServer) handleTemplates(w http.ResponseWriter, r *http.Request) {
+	if s.templates == nil {
+		http.Error(w, "Templates are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.templates.List())
+
+	case http.MethodPost:
+		var t CommandTemplate
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := s.templates.Save(t); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "ok",
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTemplateByName 刪除自訂命令範本 (DELETE /api/templates/{name})
+func (s *HTTPServer) handleTemplateByName(w http.ResponseWriter, r *http.Request) {
+	if s.templates == nil {
+		http.Error(w, "Templates are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/api/templates/")
+	if err := s.templates.Delete(name); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+	})
+}
+
+// BatchCommandRequest 批次命令請求結構
+type BatchCommandRequest struct {
+	Command    string            `json:"command"`               // START/STOP/PAUSE/RESUME
+	Channels   []string          `json:"channels,omitempty"`    // 通道列表（每項可為單一通道或範圍）
+	Selector   string            `json:"selector,omitempty"`    // 選擇器，例如 "CH001-CH032" 或 "all StandBy"
+	Barcode    string            `json:"barcode,omitempty"`     // START 共用條碼
+	Barcodes   map[string]string `json:"barcodes,omitempty"`    // START 個別通道條碼（覆寫 barcode）
+	Process    string            `json:"process,omitempty"`     // START 製程名稱
+	DataPath   string            `json:"data_path,omitempty"`   // START 資料路徑
+	IntervalMs *int              `json:"interval_ms,omitempty"` // 發送間隔（毫秒），未指定時使用伺服器預設值
+}
+
+// handleBatchCommand 處理批次命令
+func (s *HTTPServer) handleBatchCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req BatchCommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	err := s.stateManager.SendUserCommand(req.Type)
+	result, err := s.executeBatch(req)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
@@ -379,8 +940,449 @@ func (s *HTTPServer) handleUserCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// BatchSummary 批次命令執行摘要
+type BatchSummary struct {
+	Status    string        `json:"status"`
+	Total     int           `json:"total"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []BatchResult `json:"results"`
+}
+
+// executeBatch 解析通道選擇器並執行批次命令，回傳執行摘要
+func (s *HTTPServer) executeBatch(req BatchCommandRequest) (*BatchSummary, error) {
+	switch strings.ToUpper(req.Command) {
+	case CmdStart, CmdStop, CmdPause, CmdResume:
+	default:
+		return nil, newError(ErrInvalidRequest, nil, "Invalid command field")
+	}
+
+	selectors := append([]string{}, req.Channels...)
+	if req.Selector != "" {
+		selectors = append(selectors, req.Selector)
+	}
+	if len(selectors) == 0 {
+		return nil, newError(ErrInvalidRequest, nil, "Missing channels or selector field")
+	}
+
+	channelIDs, err := s.stateManager.ResolveChannels(selectors...)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := s.batchInterval
+	if req.IntervalMs != nil && *req.IntervalMs >= 0 {
+		interval = time.Duration(*req.IntervalMs) * time.Millisecond
+	}
+
+	cmd := Command{
+		Type:     req.Command,
+		Barcode:  req.Barcode,
+		Process:  req.Process,
+		DataPath: req.DataPath,
+	}
+	results, err := s.stateManager.ExecuteBatch(cmd, channelIDs, req.Barcodes, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	succeeded := 0
+	for _, result := range results {
+		if result.OK {
+			succeeded++
+		}
+	}
+
+	return &BatchSummary{
+		Status:    "ok",
+		Total:     len(results),
+		Succeeded: succeeded,
+		Failed:    len(results) - succeeded,
+		Results:   results,
+	}, nil
+}
+
+// ScheduleRequest 建立排程請求結構
+type ScheduleRequest struct {
+	Command    Command `json:"command"`               // 要執行的命令
+	Selector   string  `json:"selector,omitempty"`    // 通道選擇器（批次執行）
+	At         string  `json:"at,omitempty"`          // 絕對時間 (RFC3339)
+	DelayMs    int64   `json:"delay_ms,omitempty"`    // 延遲執行（毫秒）
+	IntervalMs int64   `json:"interval_ms,omitempty"` // 重複間隔（毫秒）
+}
+
+// schedule 將請求轉為排程（未指定時間時由 Scheduler 決定）
+func (req ScheduleRequest) schedule() (Schedule, error) {
+	schedule := Schedule{
+		Command:    req.Command,
+		Selector:   req.Selector,
+		IntervalMs: req.IntervalMs,
+	}
+	switch {
+	case req.At != "":
+		at, err := time.Parse(time.RFC3339, req.At)
+		if err != nil {
+			return Schedule{}, newError(ErrInvalidRequest, nil, "Invalid at field (RFC3339 expected)")
+		}
+		schedule.NextRun = at
+	case req.DelayMs > 0:
+		schedule.NextRun = time.Now().Add(time.Duration(req.DelayMs) * time.Millisecond)
+	case req.IntervalMs > 0:
+		schedule.NextRun = time.Now().Add(time.Duration(req.IntervalMs) * time.Millisecond)
+	}
+	return schedule, nil
+}
+
+// handleSchedules 列出或建立排程
+func (s *HTTPServer) handleSchedules(w http.ResponseWriter, r *http.Request) {
+	if s.scheduler == nil {
+		http.Error(w, "Scheduler is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.scheduler.List())
+
+	case http.MethodPost:
+		var req ScheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		schedule, err := req.schedule()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		created, err := s.scheduler.Add(schedule)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(created)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleScheduleByID 取消排程 (DELETE /api/schedules/{id})
+func (s *HTTPServer) handleScheduleByID(w http.ResponseWriter, r *http.Request) {
+	if s.scheduler == nil {
+		http.Error(w, "Scheduler is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/schedules/")
+	if err := s.scheduler.Cancel(id); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"status": "ok",
 	})
 }
+
+// handleRules 列出或新增規則
+func (s *HTTPServer) handleRules(w http.ResponseWriter, r *http.Request) {
+	if s.rulesEngine == nil {
+		http.Error(w, "Rules engine is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.rulesEngine.List())
+
+	case http.MethodPost:
+		var spec ruleSpec
+		if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		created, err := s.rulesEngine.Add(spec.rule())
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(created)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// RuleToggleRequest 啟用/停用規則請求結構
+type RuleToggleRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// handleRuleByID 啟用/停用 (PUT) 或刪除 (DELETE) 規則
+func (s *HTTPServer) handleRuleByID(w http.ResponseWriter, r *http.Request) {
+	if s.rulesEngine == nil {
+		http.Error(w, "Rules engine is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/rules/")
+
+	var err error
+	switch r.Method {
+	case http.MethodPut:
+		var req RuleToggleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		err = s.rulesEngine.SetEnabled(id, req.Enabled)
+	case http.MethodDelete:
+		err = s.rulesEngine.Remove(id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+	})
+}
+
+// handleGetConnections 取得所有 TPT 連線
+func (s *HTTPServer) handleGetConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.tcpServer.GetClients())
+}
+
+// RawInjectRequest 原始封包注入請求結構
+type RawInjectRequest struct {
+	Connection   string `json:"connection,omitempty"`     // 目標連線 ID（空白表示所有連線）
+	Data         string `json:"data"`                     // 封包內容
+	Encoding     string `json:"encoding,omitempty"`       // text（預設）或 hex
+	Terminator   *bool  `json:"terminator,omitempty"`     // 是否附加 \r\n（預設 true）
+	ChunkSize    int    `json:"chunk_size,omitempty"`     // 分段寫入大小（0 表示一次寫入）
+	ChunkDelayMs int    `json:"chunk_delay_ms,omitempty"` // 分段之間的延遲（毫秒）
+}
+
+// handleRawInject 將原始封包寫入 TPT 連線
+func (s *HTTPServer) handleRawInject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RawInjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	n, err := s.injectRaw(req)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RawInjectResponse{Status: "ok", Bytes: n})
+}
+
+// RawInjectResponse 原始封包注入結果
+type RawInjectResponse struct {
+	Status string `json:"status"`
+	Bytes  int    `json:"bytes"` // 封包長度（不含 \r\n）
+}
+
+// injectRaw 解析並注入原始封包，回傳封包長度
+func (s *HTTPServer) injectRaw(req RawInjectRequest) (int, error) {
+	data, err := DecodeRawData(req.Data, req.Encoding)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) == 0 && (req.Terminator != nil && !*req.Terminator) {
+		return 0, newError(ErrInvalidRequest, nil, "nothing to send")
+	}
+
+	frame := RawFrame{
+		Data:       data,
+		Terminator: req.Terminator == nil || *req.Terminator,
+		ChunkSize:  req.ChunkSize,
+		ChunkDelay: time.Duration(req.ChunkDelayMs) * time.Millisecond,
+	}
+	if frame.ChunkDelay < 0 || frame.ChunkDelay > MaxChunkDelay {
+		return 0, newError(ErrInvalidRequest, nil, "chunk_delay_ms must be between 0 and %d", MaxChunkDelay.Milliseconds())
+	}
+	if total := frame.TotalDelay(); total > MaxRawInjectDelay {
+		return 0, newError(ErrInvalidRequest, nil, "total chunk delay %v exceeds the maximum of %v; use a larger chunk_size", total, MaxRawInjectDelay)
+	}
+
+	err = s.tcpServer.InjectRaw(req.Connection, frame)
+	return len(data), err
+}
+
+// FuzzRequest 封包變異測試請求結構
+type FuzzRequest struct {
+	Connection string `json:"connection,omitempty"` // 目標連線 ID（空白表示所有連線）
+	Template   string `json:"template"`             // 命令範本，例如 START、STOP、RSP_STATUS、STATUS_ACK
+	Mutation   string `json:"mutation,omitempty"`   // 指定變異方式（空白表示隨機）
+	Count      int    `json:"count,omitempty"`      // 發送數量（預設 10，最多 1000）
+	DelayMs    *int   `json:"delay_ms,omitempty"`   // 每個封包之間的延遲（毫秒，預設 100）
+	Seed       int64  `json:"seed,omitempty"`       // 亂數種子（0 表示使用目前時間）
+}
+
+// handleRawFuzz 以變異後的命令範本測試 TPT 的封包解析
+func (s *HTTPServer) handleRawFuzz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req FuzzRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.runFuzz(r.Context(), req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if resp == nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  err.Error(),
+			"seed":   resp.Seed,
+			"frames": resp.Frames,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// FuzzResponse 封包變異測試結果
+type FuzzResponse struct {
+	Status string      `json:"status"`
+	Seed   int64       `json:"seed"`   // 亂數種子（可用於重現）
+	Frames []FuzzFrame `json:"frames"` // 已發送的封包
+}
+
+// runFuzz 產生並發送變異封包（ctx 結束時停止發送，例如客戶端中斷連線或伺服器關閉）
+// 發送途中失敗時同時回傳已發送的封包；請求內容錯誤時回傳 nil
+func (s *HTTPServer) runFuzz(ctx context.Context, req FuzzRequest) (*FuzzResponse, error) {
+	if req.Mutation != "" && !IsFuzzMutation(req.Mutation) {
+		return nil, newError(ErrInvalidRequest, nil, "Unsupported mutation")
+	}
+	if req.Count <= 0 {
+		req.Count = 10
+	}
+	if req.Count > 1000 {
+		req.Count = 1000
+	}
+	delay := 100 * time.Millisecond
+	if req.DelayMs != nil && *req.DelayMs >= 0 {
+		delay = time.Duration(*req.DelayMs) * time.Millisecond
+	}
+	if delay > MaxChunkDelay {
+		return nil, newError(ErrInvalidRequest, nil, "delay_ms must not exceed %d", MaxChunkDelay.Milliseconds())
+	}
+	if total := delay * time.Duration(req.Count-1); total > MaxBatchDuration {
+		return nil, newError(ErrInvalidRequest, nil, "%d frame(s) at %v intervals exceed the maximum duration of %v",
+			req.Count, delay, MaxBatchDuration)
+	}
+	if req.Seed == 0 {
+		req.Seed = time.Now().UnixNano()
+	}
+
+	workStationName, _ := s.stateManager.GetConnectionStatus()["work_station_name"].(string)
+	fuzzer := NewFuzzer(req.Seed)
+	template, err := fuzzer.Template(req.Template, workStationName)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &FuzzResponse{Status: "ok", Seed: req.Seed, Frames: make([]FuzzFrame, 0, req.Count)}
+	for i := 0; i < req.Count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+			if err := ctx.Err(); err != nil {
+				return resp, fmt.Errorf("fuzz cancelled after %d frame(s): %w", len(resp.Frames), err)
+			}
+		}
+
+		var frame FuzzFrame
+		if req.Mutation != "" {
+			frame = fuzzer.MutateWith(template, req.Mutation)
+		} else {
+			frame = fuzzer.Mutate(template)
+		}
+
+		err := s.tcpServer.InjectRaw(req.Connection, RawFrame{
+			Data:       frame.Data,
+			Terminator: frame.Terminator,
+		})
+		if err != nil {
+			return resp, err
+		}
+		resp.Frames = append(resp.Frames, frame)
+	}
+
+	return resp, nil
+}
@@ -1,24 +1,68 @@
 package core
 
 import (
+	"GoTestMES/models"
 	"bufio"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net"
 	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // TCPServer TCP 伺服器
 type TCPServer struct {
 	port         int
+	addr         string // 監聽位址（覆寫 port，例如 127.0.0.1:0）
 	listener     net.Listener
 	stateManager *StateManager
-	clients      map[net.Conn]bool
+	clients      map[net.Conn]*tcpClient
 	clientsMu    sync.RWMutex
 	stopChan     chan struct{}
+	stopOnce     sync.Once
+	stopped      bool           // 已停止（由 clientsMu 保護）
+	draining     atomic.Bool    // 關閉中，不再發送新命令
+	connWG       sync.WaitGroup // 進行中的連線處理 goroutine
+
+	tlsConfig     *tls.Config       // TLS 設定（nil 表示明文）
+	clientCertMap map[string]string // 客戶端憑證 CN 或指紋 -> 工作站名稱（mutual TLS）
+	admission     AdmissionPolicy   // 連線准入條件
+	linkPolicy    HandshakePolicy   // LINK 握手規則
+}
+
+// tcpClient 單一 TPT 連線
+type tcpClient struct {
+	conn        net.Conn
+	id          string     // 連線 ID（遠端位址）
+	connectedAt time.Time  // 連線建立時間
+	writeMu     sync.Mutex // 確保同一連線的寫入不會交錯
+	workStation string     // 工作站名稱（客戶端憑證或 LINK，由 clientsMu 保護）
+	certBound   bool       // 工作站名稱由客戶端憑證決定
+	linked      bool       // 已完成 LINK（由 clientsMu 保護）
+	metrics     *Metrics   // 所屬伺服器的統計
+}
+
+// write 以 [JSON]\r\n 格式寫入訊息
+func (c *tcpClient) write(data interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return writeMessage(c.conn, data, c.metrics)
+}
+
+// ClientInfo TPT 連線資訊（用於前端顯示）
+type ClientInfo struct {
+	ID          string    `json:"id"`
+	LocalAddr   string    `json:"local_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+	TLS         bool      `json:"tls"`
+	WorkStation string    `json:"work_station,omitempty"` // 工作站名稱（客戶端憑證或 LINK）
+	Linked      bool      `json:"linked"`                 // 已完成 LINK
 }
 
 // NewTCPServer 建立新的 TCP 伺服器
@@ -26,20 +70,42 @@ func NewTCPServer(port int, stateManager *StateManager) *TCPServer {
 	return &TCPServer{
 		port:         port,
 		stateManager: stateManager,
-		clients:      make(map[net.Conn]bool),
+		clients:      make(map[net.Conn]*tcpClient),
 		stopChan:     make(chan struct{}),
 	}
 }
 
+// SetTLSConfig 啟用 TLS（需在 Start 之前呼叫）
+// config 要求客戶端憑證時，以 certMap 將憑證的 CN 或 SHA-256 指紋對應到工作站名稱；
+// certMap 為空時以憑證的 CN 作為工作站名稱
+func (s *TCPServer) SetTLSConfig(config *tls.Config, certMap map[string]string) {
+	s.tlsConfig = config
+	s.clientCertMap = certMap
+}
+
+// SetListenAddr 指定監聽位址（例如 "127.0.0.1:0" 由系統分配連接埠），需在 Start 之前呼叫
+func (s *TCPServer) SetListenAddr(addr string) {
+	s.addr = addr
+}
+
 // Start 啟動 TCP 伺服器
 func (s *TCPServer) Start() error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
+	addr := s.addr
+	if addr == "" {
+		addr = fmt.Sprintf(":%d", s.port)
+	}
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return fmt.Errorf("failed to start TCP server: %w", err)
 	}
 
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+		logTCP.Info("TLS enabled", "client_certificates", s.tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert)
+	}
+
 	s.listener = listener
-	log.Printf("[TCP] Server listening on port %d", s.port)
+	logTCP.Info("server listening", "addr", listener.Addr().String())
 
 	// 設定 StateManager 的發送函數
 	s.stateManager.SetSendToTPTFunc(s.SendToAllClients)
@@ -63,106 +129,131 @@ func (s *TCPServer) acceptConnections() {
 			case <-s.stopChan:
 				return
 			default:
-				log.Printf("[TCP] Accept error: %v", err)
+				logTCP.Error("accept failed", "error", err)
 				continue
 			}
 		}
 
-		log.Printf("[TCP] ==========================================")
-		log.Printf("[TCP] ✓ New connection accepted!")
-		log.Printf("[TCP]   Remote address: %s", conn.RemoteAddr())
-		log.Printf("[TCP]   Local address:  %s", conn.LocalAddr())
-		log.Printf("[TCP]   Connection type: %T", conn)
-		log.Printf("[TCP] ==========================================")
-
 		s.clientsMu.Lock()
-		s.clients[conn] = true
+		if s.stopped {
+			s.clientsMu.Unlock()
+			conn.Close()
+			return
+		}
+		if reason := s.admitLocked(conn); reason != "" {
+			s.clientsMu.Unlock()
+			logTCP.Warn("connection rejected", "remote", conn.RemoteAddr().String(), "reason", reason)
+			conn.Close()
+			continue
+		}
+		client := &tcpClient{
+			conn:        conn,
+			id:          conn.RemoteAddr().String(),
+			connectedAt: time.Now(),
+			metrics:     s.stateManager.metrics,
+		}
+		s.clients[conn] = client
 		clientCount := len(s.clients)
+		s.connWG.Add(1)
 		s.clientsMu.Unlock()
+		s.stateManager.UpdateTCPClientCount(1)
 
-		log.Printf("[TCP] Total active connections: %d", clientCount)
+		logTCP.Info("connection accepted",
+			"remote", conn.RemoteAddr().String(),
+			"local", conn.LocalAddr().String(),
+			"active", clientCount)
 
-		go s.handleConnection(conn)
+		go s.handleConnection(client)
 	}
 }
 
 // handleConnection 處理單一連線
-func (s *TCPServer) handleConnection(conn net.Conn) {
+func (s *TCPServer) handleConnection(client *tcpClient) {
+	conn := client.conn
+	defer s.connWG.Done()
 	defer func() {
 		conn.Close()
 		s.clientsMu.Lock()
 		delete(s.clients, conn)
 		s.clientsMu.Unlock()
-		log.Printf("[TCP] Connection closed: %s", conn.RemoteAddr())
+		s.stateManager.UpdateTCPClientCount(-1)
+		logTCP.Info("connection closed", "remote", client.id)
 	}()
 
+	netConn := conn
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		netConn = tlsConn.NetConn()
+		if !s.handshake(client, tlsConn) {
+			return
+		}
+	}
+
 	// 啟用 TCP Keep-Alive（每 30 秒發送一次 keep-alive 封包）
-	if tcpConn, ok := conn.(*net.TCPConn); ok {
+	if tcpConn, ok := netConn.(*net.TCPConn); ok {
 		tcpConn.SetKeepAlive(true)
 		tcpConn.SetKeepAlivePeriod(30 * time.Second)
-		log.Printf("[TCP] ✓ TCP Keep-Alive enabled (30s interval)")
 	}
 
 	// 建立 bufio.Reader 用於讀取訊息
 	reader := bufio.NewReader(conn)
 
-	log.Printf("[TCP] ========================================")
-	log.Printf("[TCP] Connection established from %s", conn.RemoteAddr())
-	log.Printf("[TCP] Local address: %s", conn.LocalAddr())
-	log.Printf("[TCP] Reader created, buffer size: %d", reader.Size())
-	log.Printf("[TCP] ========================================")
-	os.Stdout.Sync() // 強制輸出 log
+	// 連線後需在期限內送出 LINK（完成 LINK 後取消期限）
+	conn.SetReadDeadline(s.linkDeadline())
 
-	messageCount := 0
 	for {
-		messageCount++
-		log.Printf("[TCP] ========== Message #%d ==========", messageCount)
-		log.Printf("[TCP] >>> Calling ReadMessage()...")
-		os.Stdout.Sync() // 強制輸出 log
-
 		// 不設定讀取超時，讓連線保持開啟
 		// TCP Keep-Alive 會自動檢測連線是否斷開
 		// conn.SetReadDeadline(time.Now().Add(30 * time.Second))
 
 		// 讀取訊息（這會阻塞直到收到資料或超時）
-		jsonData, err := ReadMessage(reader)
-
-		log.Printf("[TCP] <<< ReadMessage() returned")
-		os.Stdout.Sync() // 強制輸出 log
-
+		jsonData, err := readMessage(reader, s.stateManager.metrics)
+		if err != nil && !client.linked && errors.Is(err, os.ErrDeadlineExceeded) {
+			logTCP.Warn("no LINK received, closing connection",
+				"remote", client.id, "timeout", s.linkPolicy.LinkTimeout)
+			return
+		}
 		if err != nil {
-			log.Printf("[TCP] ❌ Read error from %s: %v", conn.RemoteAddr(), err)
-			os.Stdout.Sync()
 			// 任何讀取錯誤都表示連線有問題，關閉連線
+			if errors.Is(err, io.EOF) {
+				logTCP.Debug("connection closed by peer", "remote", client.id)
+			} else if errors.Is(err, net.ErrClosed) {
+				logTCP.Debug("connection closed by server", "remote", client.id)
+			} else {
+				logTCP.Warn("read failed", "remote", client.id, "error", err)
+			}
 			return
 		}
 
-		// 記錄收到的訊息（完整內容）
-		log.Printf("[TCP] ✓ Received %d bytes from %s", len(jsonData), conn.RemoteAddr())
-		log.Printf("[TCP] Raw data: %s", string(jsonData))
+		// 檢查握手狀態與工作站名稱
+		msgType, err := ParseMessageType(jsonData)
+		if err != nil || msgType == "" {
+			s.stateManager.metrics.countParseError()
+		}
+		s.stateManager.metrics.countMessage(msgType, DirectionIn)
+		logTCP.Debug("message received", "remote", client.id, "type", msgType, "payload", string(jsonData))
+		process, keep := s.checkHandshake(client, msgType, jsonData)
+		if !keep {
+			return
+		}
+		if !process {
+			continue
+		}
 
 		// 處理訊息
 		response, err := s.stateManager.HandleMessage(jsonData)
 		if err != nil {
-			log.Printf("[TCP] ❌ Handle message error: %v", err)
-			log.Printf("[TCP] Failed message content: %s", string(jsonData))
+			logTCP.Warn("message rejected", "remote", client.id, "type", msgType, "error", err, "payload", string(jsonData))
 			// 即使處理失敗，也不中斷連線
 			continue
 		}
 
-		log.Printf("[TCP] ✓ Message handled successfully")
-
 		// 發送回覆
 		if response != nil {
-			if err := WriteMessage(conn, response); err != nil {
-				log.Printf("[TCP] Write error to %s: %v", conn.RemoteAddr(), err)
+			if err := client.write(response); err != nil {
+				logTCP.Warn("write failed", "remote", client.id, "error", err)
 				return
 			}
 
-			// 記錄發送的訊息
-			respJSON, _ := json.Marshal(response)
-			log.Printf("[TCP] Sent to %s: %s", conn.RemoteAddr(), string(respJSON))
-
 			// 廣播到前端
 			if s.stateManager.broadcastFunc != nil {
 				s.stateManager.broadcast(map[string]interface{}{
@@ -174,46 +265,146 @@ func (s *TCPServer) handleConnection(conn net.Conn) {
 	}
 }
 
+// handshake 完成 TLS 交握，並依客戶端憑證決定工作站名稱（失敗時回傳 false）
+func (s *TCPServer) handshake(client *tcpClient, conn *tls.Conn) bool {
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := conn.Handshake(); err != nil {
+		logTCP.Warn("TLS handshake failed", "remote", client.id, "error", err)
+		return false
+	}
+	conn.SetDeadline(time.Time{})
+
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		logTCP.Info("TLS handshake completed", "remote", client.id, "version", tls.VersionName(state.Version))
+		return true
+	}
+
+	leaf := state.PeerCertificates[0]
+	workStation := leaf.Subject.CommonName
+	if len(s.clientCertMap) > 0 {
+		var ok bool
+		if workStation, ok = s.clientCertMap[certFingerprint(leaf)]; !ok {
+			workStation, ok = s.clientCertMap[leaf.Subject.CommonName]
+		}
+		if !ok {
+			logTCP.Warn("client certificate is not mapped to a workstation",
+				"remote", client.id, "cn", leaf.Subject.CommonName, "sha256", certFingerprint(leaf))
+			return false
+		}
+	}
+
+	s.clientsMu.Lock()
+	client.workStation = workStation
+	client.certBound = true
+	s.clientsMu.Unlock()
+
+	logTCP.Info("client certificate accepted",
+		"remote", client.id, "cn", leaf.Subject.CommonName, "work_station", workStation)
+	return true
+}
+
+// rejectLink 回覆 LINK_ACK NG（之後由呼叫端關閉連線）
+func (s *TCPServer) rejectLink(client *tcpClient, jsonData []byte, reason string) {
+	var link models.LinkMessage
+	json.Unmarshal(jsonData, &link)
+
+	ack := models.LinkAckMessage{
+		Type:            "LINK_ACK",
+		Timestamp:       models.GetTimestamp(),
+		MsgID:           models.GenerateMsgID(),
+		WorkStationName: link.WorkStationName,
+		ReplyTo:         link.MsgID,
+		Ack:             models.AckNG,
+		Message:         reason,
+	}
+	if err := client.write(ack); err != nil {
+		logTCP.Warn("write failed", "remote", client.id, "error", err)
+	}
+	s.stateManager.broadcast(map[string]interface{}{
+		"direction": "MES->TPT",
+		"data":      ack,
+	})
+}
+
+// messageWorkStation 取得訊息中的 work_station_name（沒有或無法解析時回傳空字串）
+func messageWorkStation(jsonData []byte) string {
+	var msg struct {
+		WorkStationName string `json:"work_station_name"`
+	}
+	json.Unmarshal(jsonData, &msg)
+	return msg.WorkStationName
+}
+
 // SendToAllClients 發送訊息給所有連線的 TPT 客戶端
+// 檢查 LINK 時（握手模式不是 off）只發送給已完成 LINK 的連線
 func (s *TCPServer) SendToAllClients(data interface{}) error {
 	s.clientsMu.RLock()
 	defer s.clientsMu.RUnlock()
 
 	if len(s.clients) == 0 {
-		return fmt.Errorf("no TPT clients connected")
+		return newError(ErrTPTOffline, nil, "no TPT clients connected")
+	}
+	if s.draining.Load() {
+		return errShuttingDown
+	}
+
+	targets := s.commandTargetsLocked()
+	if len(targets) == 0 {
+		return newError(ErrTPTOffline, nil, "no linked TPT clients connected")
 	}
 
 	var lastErr error
-	for conn := range s.clients {
-		if err := WriteMessage(conn, data); err != nil {
-			log.Printf("[TCP] Failed to send to %s: %v", conn.RemoteAddr(), err)
+	for _, client := range targets {
+		if err := client.write(data); err != nil {
+			logTCP.Warn("send failed", "remote", client.id, "error", err)
 			lastErr = err
-		} else {
-			// 記錄發送的訊息
-			jsonData, _ := json.Marshal(data)
-			log.Printf("[TCP] Sent to %s: %s", conn.RemoteAddr(), string(jsonData))
 		}
 	}
 
 	return lastErr
 }
 
-// Stop 停止 TCP 伺服器
-func (s *TCPServer) Stop() {
-	close(s.stopChan)
-	if s.listener != nil {
-		s.listener.Close()
+// commandTargetsLocked 可接收命令的連線（呼叫端需持有 clientsMu）
+func (s *TCPServer) commandTargetsLocked() []*tcpClient {
+	checkLink := s.linkPolicy.Mode != LinkModeOff && s.linkPolicy.Mode != ""
+	targets := make([]*tcpClient, 0, len(s.clients))
+	for _, client := range s.clients {
+		if checkLink && !client.linked {
+			continue
+		}
+		targets = append(targets, client)
 	}
+	return targets
+}
 
-	// 關閉所有客戶端連線
-	s.clientsMu.Lock()
-	for conn := range s.clients {
-		conn.Close()
-	}
-	s.clients = make(map[net.Conn]bool)
-	s.clientsMu.Unlock()
+// Stop 停止 TCP 伺服器，關閉所有連線並等待連線處理結束（可重複呼叫）
+func (s *TCPServer) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+		if s.listener != nil {
+			s.listener.Close()
+		}
+
+		// 關閉所有客戶端連線（連線處理結束時會自行從 clients 移除）
+		s.clientsMu.Lock()
+		s.stopped = true
+		for conn := range s.clients {
+			conn.Close()
+		}
+		s.clientsMu.Unlock()
 
-	log.Printf("[TCP] Server stopped")
+		s.connWG.Wait()
+		logTCP.Info("server stopped")
+	})
+}
+
+// Addr 回傳實際監聽的位址（尚未啟動時回傳 nil）
+func (s *TCPServer) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
 }
 
 // GetClientCount 取得連線的客戶端數量
@@ -222,3 +413,25 @@ func (s *TCPServer) GetClientCount() int {
 	defer s.clientsMu.RUnlock()
 	return len(s.clients)
 }
+
+// GetClients 取得所有 TPT 連線資訊（依連線時間排序）
+func (s *TCPServer) GetClients() []ClientInfo {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+
+	clients := make([]ClientInfo, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, ClientInfo{
+			ID:          client.id,
+			LocalAddr:   client.conn.LocalAddr().String(),
+			ConnectedAt: client.connectedAt,
+			TLS:         s.tlsConfig != nil,
+			WorkStation: client.workStation,
+			Linked:      client.linked,
+		})
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ConnectedAt.Before(clients[j].ConnectedAt)
+	})
+	return clients
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	sseRetryMs           = 3000             // 建議瀏覽器重連的間隔
+	sseKeepAlivePeriod   = 15 * time.Second // 註解行心跳間隔（避免代理伺服器中斷閒置連線）
+	sseLastEventIDHeader = "Last-Event-ID"
+)
+
+// handleEvents 以 Server-Sent Events 推送與 /ws 相同的事件
+//
+//	GET /api/events                     從目前狀態快照開始
+//	GET /api/events?since=N             補送序號 N 之後的事件
+//	Last-Event-ID: N                    瀏覽器 EventSource 自動重連時帶入
+//
+// 支援與 /ws 相同的訂閱條件參數（channels、types、directions、work_stations）
+func (s *HTTPServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := compileFilter(EventFilterFromQuery(r.URL.Query()), s.stateManager.ChannelCount())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	remoteAddr := r.RemoteAddr
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetryMs)
+
+	logWS.Info("event stream opened", "remote", remoteAddr)
+
+	// Last-Event-ID 優先，其次為 ?since=N
+	sinceValue := r.Header.Get(sseLastEventIDHeader)
+	if sinceValue == "" {
+		sinceValue = r.URL.Query().Get("since")
+	}
+
+	newClient := func(queueSize int) *wsClient {
+		return newWSClient(nil, remoteAddr, queueSize, filter)
+	}
+	var client *wsClient
+	if since, err := strconv.ParseUint(sinceValue, 10, 64); err == nil {
+		var marker []byte
+		client, marker = s.resumeClient(remoteAddr, since, filter, newClient)
+		if marker != nil {
+			writeSSEEvent(w, marker)
+		}
+	}
+
+	if client == nil {
+		client = newClient(wsSendQueueSize)
+		if err := s.registerWithSnapshot(client); err != nil {
+			logWS.Error("failed to encode initial state", "error", err)
+			return
+		}
+	}
+
+	defer func() {
+		s.removeWSClient(client)
+		logWS.Info("event stream closed", "remote", remoteAddr)
+	}()
+
+	if err := rc.Flush(); err != nil {
+		logWS.Warn("streaming not supported", "remote", remoteAddr, "error", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlivePeriod)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-client.done:
+			// 佇列已滿被踢除
+			return
+
+		case msg := <-client.send:
+			rc.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := writeSSEEvent(w, msg); err != nil {
+				logWS.Debug("write failed", "remote", remoteAddr, "error", err)
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+
+		case <-keepAlive.C:
+			rc.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeSSEEvent 寫出一則 SSE 事件（帶 seq 的事件同時作為 id，供 Last-Event-ID 續傳）
+func writeSSEEvent(w http.ResponseWriter, msg []byte) error {
+	var buf bytes.Buffer
+	if seq, ok := eventSeqOf(msg); ok {
+		fmt.Fprintf(&buf, "id: %d\n", seq)
+	}
+	// 事件內容為單行 JSON；保險起見仍逐行加上 data: 前綴
+	for _, line := range bytes.Split(msg, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
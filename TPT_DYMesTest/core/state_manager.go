@@ -3,9 +3,11 @@ package core
 import (
 	"GoTestMES/models"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"strings"
 	"sync"
+	"time"
 )
 
 // ChannelState 通道狀態資訊
@@ -21,22 +23,32 @@ type ChannelState struct {
 // StateManager 狀態管理器
 type StateManager struct {
 	mu              sync.RWMutex
-	channels        map[string]*ChannelState // 通道狀態 map[ChannelID]State
-	workStationName string                   // 工作站名稱
-	isConnected     bool                     // TPT 是否已連線
-	tptState        string                   // TPT 連線狀態 (Online-Auto, Online-Manual, Offline)
-	channelCount    int                      // 通道數量
-	broadcastFunc   func(interface{})        // 廣播函數（發送到 WebSocket）
-	sendToTPTFunc   func(interface{}) error  // 發送到 TPT 的函數
+	channels        map[string]*ChannelState                     // 通道狀態 map[ChannelID]State
+	workStationName string                                       // 工作站名稱
+	isConnected     bool                                         // TPT 是否已連線
+	tptState        string                                       // TPT 連線狀態 (Online-Auto, Online-Manual, Offline)
+	channelCount    int                                          // 通道數量
+	broadcastFunc   func(interface{})                            // 廣播函數（發送到 WebSocket）
+	sendToTPTFunc   func(interface{}) error                      // 發送到 TPT 的函數
+	messageHook     func(string, map[string]interface{}, string) // 訊息處理完成後的掛勾（規則引擎）
+	pendingReplies  map[string]chan map[string]interface{}       // 等待回覆的命令 map[msg_id]回覆通道
+	pendingCommands map[string]*pendingCommand                   // 等待 ACK 的命令 map[msg_id]命令
+	tcpClients      int                                          // TCP 連線數量
+	channelHistory  map[string]*channelHistory                   // 通道附加資訊（狀態變更時間、最後訊息、測試記錄）
+	metrics         *Metrics                                     // 此伺服器的統計（TCP 與 HTTP 伺服器共用）
 }
 
 // NewStateManager 建立新的狀態管理器
 func NewStateManager(channelCount int) *StateManager {
 	sm := &StateManager{
-		channels:     make(map[string]*ChannelState),
-		channelCount: channelCount,
-		isConnected:  false,
-		tptState:     models.ConnOffline,
+		channels:        make(map[string]*ChannelState),
+		channelCount:    channelCount,
+		isConnected:     false,
+		tptState:        models.ConnOffline,
+		pendingReplies:  make(map[string]chan map[string]interface{}),
+		pendingCommands: make(map[string]*pendingCommand),
+		channelHistory:  make(map[string]*channelHistory),
+		metrics:         newMetrics(),
 	}
 
 	// 初始化所有通道為 OffLine 狀態
@@ -65,6 +77,14 @@ func (sm *StateManager) SetSendToTPTFunc(fn func(interface{}) error) {
 	sm.sendToTPTFunc = fn
 }
 
+// SetMessageHook 設定訊息處理完成後呼叫的掛勾函數
+// previousState 為處理訊息前該通道的狀態（訊息未指定通道時為空字串）
+func (sm *StateManager) SetMessageHook(fn func(msgType string, msg map[string]interface{}, previousState string)) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.messageHook = fn
+}
+
 // broadcast 內部廣播函數
 func (sm *StateManager) broadcast(data interface{}) {
 	if sm.broadcastFunc != nil {
@@ -80,7 +100,7 @@ func (sm *StateManager) HandleMessage(jsonData []byte) (interface{}, error) {
 		return nil, err
 	}
 
-	log.Printf("[StateManager] Received message type: %s", msgType)
+	logState.Debug("message received", "type", msgType)
 
 	// 廣播原始訊息到前端
 	var rawMsg map[string]interface{}
@@ -90,19 +110,51 @@ func (sm *StateManager) HandleMessage(jsonData []byte) (interface{}, error) {
 		"data":      rawMsg,
 	})
 
+	// 若為等待中命令的回覆，交給等待者
+	if replyTo, ok := rawMsg["reply_to"].(string); ok && replyTo != "" {
+		sm.deliverReply(replyTo, rawMsg)
+	}
+
+	// 記錄各通道最後收到的訊息，並保留處理前的狀態供掛勾判斷狀態變化
+	var previousState string
+	sm.mu.Lock()
+	if ch, exists := sm.channels[stringField(rawMsg, "channel")]; exists {
+		previousState = ch.State
+	}
+	sm.recordIncomingLocked(msgType, rawMsg)
+	sm.mu.Unlock()
+
 	// 根據訊息類型處理
+	var response interface{}
 	switch msgType {
 	case "LINK":
-		return sm.handleLink(jsonData)
+		response, err = sm.handleLink(jsonData)
 	case "STATUS":
-		return sm.handleStatus(jsonData)
+		response, err = sm.handleStatus(jsonData)
 	case "STATUS_ALL":
-		return sm.handleStatusAll(jsonData)
+		response, err = sm.handleStatusAll(jsonData)
 	case "REPORT":
-		return sm.handleReport(jsonData)
+		response, err = sm.handleReport(jsonData)
 	default:
-		return nil, fmt.Errorf("unknown message type: %s", msgType)
+		// TPT 對 MES 命令的回覆（START_ACK、STOP_ACK...）
+		if !strings.HasSuffix(msgType, "_ACK") {
+			return nil, fmt.Errorf("unknown message type: %s", msgType)
+		}
+		response, err = sm.handleCommandAck(msgType, rawMsg)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 狀態更新後呼叫掛勾（不持有鎖）
+	sm.mu.RLock()
+	hook := sm.messageHook
+	sm.mu.RUnlock()
+	if hook != nil {
+		hook(msgType, rawMsg, previousState)
+	}
+
+	return response, nil
 }
 
 // handleLink 處理 LINK 訊息
@@ -116,10 +168,10 @@ func (sm *StateManager) handleLink(jsonData []byte) (interface{}, error) {
 	sm.isConnected = true
 	sm.workStationName = msg.WorkStationName
 	sm.tptState = msg.State
+	sm.emitConnectionUpdateLocked()
 	sm.mu.Unlock()
 
-	log.Printf("[LINK] TPT connected: %s, State: %s, Channels: %s",
-		msg.WorkStationName, msg.State, msg.ChannelCount)
+	logState.Info("TPT linked", "work_station", msg.WorkStationName, "state", msg.State, "channels", msg.ChannelCount)
 
 	// 回覆 LINK_ACK
 	ack := models.LinkAckMessage{
@@ -144,11 +196,12 @@ func (sm *StateManager) handleStatus(jsonData []byte) (interface{}, error) {
 
 	sm.mu.Lock()
 	if ch, exists := sm.channels[msg.Channel]; exists {
-		ch.State = msg.State
+		sm.setStateLocked(ch, msg.State)
 		if msg.Message != "" {
 			ch.Message = msg.Message
 		}
-		log.Printf("[STATUS] Channel %s -> %s (msg: %s)", msg.Channel, msg.State, msg.Message)
+		logState.Info("channel status", "channel", msg.Channel, "state", msg.State, "message", msg.Message)
+		sm.emitChannelUpdateLocked(msg.Channel)
 	}
 	sm.mu.Unlock()
 
@@ -176,14 +229,19 @@ func (sm *StateManager) handleStatusAll(jsonData []byte) (interface{}, error) {
 
 	sm.mu.Lock()
 	// 更新所有通道狀態
+	changed := make([]string, 0, len(msg.Channels))
 	for _, chInfo := range msg.Channels {
 		// STATUS_ALL 使用 "ch": "001" 格式，需要轉換為 "CH001"
 		channelID := fmt.Sprintf("CH%s", chInfo.Ch)
 		if ch, exists := sm.channels[channelID]; exists {
-			ch.State = chInfo.State
-			log.Printf("[STATUS_ALL] Channel %s -> %s", channelID, chInfo.State)
+			if ch.State != chInfo.State {
+				changed = append(changed, channelID)
+			}
+			sm.setStateLocked(ch, chInfo.State)
+			logState.Debug("channel status", "channel", channelID, "state", chInfo.State)
 		}
 	}
+	sm.emitChannelUpdateLocked(changed...)
 	sm.mu.Unlock()
 
 	// 回覆 STATUS_ALL_ACK
@@ -216,8 +274,12 @@ func (sm *StateManager) handleReport(jsonData []byte) (interface{}, error) {
 	sm.mu.Lock()
 	if ch, exists := sm.channels[channelID]; exists {
 		// 完工後設定為 Finish 或 StandBy 狀態
-		ch.State = models.StateFinish
-		log.Printf("[REPORT] Channel %s finished, record: %s", channelID, msg.RecordPath)
+		if h := sm.channelHistory[channelID]; h != nil && h.run != nil {
+			h.run.RecordPath = msg.RecordPath
+		}
+		sm.setStateLocked(ch, models.StateFinish)
+		logState.Info("channel finished", "channel", channelID, "record", msg.RecordPath)
+		sm.emitChannelUpdateLocked(channelID)
 	}
 	sm.mu.Unlock()
 
@@ -238,30 +300,37 @@ func (sm *StateManager) handleReport(jsonData []byte) (interface{}, error) {
 
 // ValidateAndSendStart 驗證並發送 START 命令（Level 3 邏輯）
 func (sm *StateManager) ValidateAndSendStart(channelID, barcode, process, dataPath string) error {
+	_, err := sm.sendStart(channelID, barcode, process, dataPath)
+	return err
+}
+
+// sendStart 驗證並發送 START 命令，回傳命令的 msg_id
+func (sm *StateManager) sendStart(channelID, barcode, process, dataPath string) (msgID string, err error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	defer sm.reportFailureLocked(CmdStart, channelID, &err)
 
 	// 檢查 TPT 是否已連線
 	if !sm.isConnected {
-		return fmt.Errorf("TPT is not connected")
+		return "", errTPTNotConnected
 	}
 
 	// 檢查通道是否存在
 	ch, exists := sm.channels[channelID]
 	if !exists {
-		return fmt.Errorf("channel %s does not exist", channelID)
+		return "", errChannelNotFound(channelID)
 	}
 
 	// Level 3 邏輯：檢查通道狀態
 	switch ch.State {
 	case models.StateRunning:
-		return fmt.Errorf("channel %s is already running", channelID)
+		return "", errChannelState(ch, "channel %s is already running", channelID)
 	case models.StateOffLine:
-		return fmt.Errorf("channel %s is offline", channelID)
+		return "", errChannelState(ch, "channel %s is offline", channelID)
 	case models.StatePaused:
-		return fmt.Errorf("channel %s is paused, use RESUME instead", channelID)
+		return "", errChannelState(ch, "channel %s is paused, use RESUME instead", channelID)
 	case models.StateAlarm:
-		return fmt.Errorf("channel %s is in alarm state", channelID)
+		return "", errChannelState(ch, "channel %s is in alarm state", channelID)
 	}
 
 	// 建立 START 命令
@@ -279,7 +348,7 @@ func (sm *StateManager) ValidateAndSendStart(channelID, barcode, process, dataPa
 	// 發送到 TPT
 	if sm.sendToTPTFunc != nil {
 		if err := sm.sendToTPTFunc(startCmd); err != nil {
-			return fmt.Errorf("failed to send START command: %w", err)
+			return "", newError(ErrSendFailed, nil, "failed to send START command: %w", err)
 		}
 	}
 
@@ -287,8 +356,12 @@ func (sm *StateManager) ValidateAndSendStart(channelID, barcode, process, dataPa
 	ch.Barcode = barcode
 	ch.Process = process
 	ch.DataPath = dataPath
+	sm.commandSentLocked(CmdStart, channelID, startCmd.MsgID)
+	sm.beginRunLocked(ch, startCmd.MsgID)
+	sm.recordMessageLocked(channelID, "MES->TPT", startCmd.Type, startCmd)
+	sm.emitChannelUpdateLocked(channelID)
 
-	log.Printf("[START] Sent to channel %s (barcode: %s, process: %s)", channelID, barcode, process)
+	logState.Info("command sent", "command", CmdStart, "channel", channelID, "barcode", barcode, "process", process)
 
 	// 廣播到前端
 	sm.broadcast(map[string]interface{}{
@@ -296,21 +369,28 @@ func (sm *StateManager) ValidateAndSendStart(channelID, barcode, process, dataPa
 		"data":      startCmd,
 	})
 
-	return nil
+	return startCmd.MsgID, nil
 }
 
 // ValidateAndSendStop 驗證並發送 STOP 命令
 func (sm *StateManager) ValidateAndSendStop(channelID string) error {
+	_, err := sm.sendStop(channelID)
+	return err
+}
+
+// sendStop 驗證並發送 STOP 命令，回傳命令的 msg_id
+func (sm *StateManager) sendStop(channelID string) (msgID string, err error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	defer sm.reportFailureLocked(CmdStop, channelID, &err)
 
 	if !sm.isConnected {
-		return fmt.Errorf("TPT is not connected")
+		return "", errTPTNotConnected
 	}
 
 	_, exists := sm.channels[channelID]
 	if !exists {
-		return fmt.Errorf("channel %s does not exist", channelID)
+		return "", errChannelNotFound(channelID)
 	}
 
 	stopCmd := models.StopMessage{
@@ -323,32 +403,41 @@ func (sm *StateManager) ValidateAndSendStop(channelID string) error {
 
 	if sm.sendToTPTFunc != nil {
 		if err := sm.sendToTPTFunc(stopCmd); err != nil {
-			return fmt.Errorf("failed to send STOP command: %w", err)
+			return "", newError(ErrSendFailed, nil, "failed to send STOP command: %w", err)
 		}
 	}
 
-	log.Printf("[STOP] Sent to channel %s", channelID)
+	logState.Info("command sent", "command", CmdStop, "channel", channelID)
+	sm.commandSentLocked(CmdStop, channelID, stopCmd.MsgID)
+	sm.recordMessageLocked(channelID, "MES->TPT", stopCmd.Type, stopCmd)
 
 	sm.broadcast(map[string]interface{}{
 		"direction": "MES->TPT",
 		"data":      stopCmd,
 	})
 
-	return nil
+	return stopCmd.MsgID, nil
 }
 
 // ValidateAndSendPause 驗證並發送 PAUSE 命令
 func (sm *StateManager) ValidateAndSendPause(channelID string) error {
+	_, err := sm.sendPause(channelID)
+	return err
+}
+
+// sendPause 驗證並發送 PAUSE 命令，回傳命令的 msg_id
+func (sm *StateManager) sendPause(channelID string) (msgID string, err error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	defer sm.reportFailureLocked(CmdPause, channelID, &err)
 
 	if !sm.isConnected {
-		return fmt.Errorf("TPT is not connected")
+		return "", errTPTNotConnected
 	}
 
 	_, exists := sm.channels[channelID]
 	if !exists {
-		return fmt.Errorf("channel %s does not exist", channelID)
+		return "", errChannelNotFound(channelID)
 	}
 
 	pauseCmd := models.PauseMessage{
@@ -361,32 +450,41 @@ func (sm *StateManager) ValidateAndSendPause(channelID string) error {
 
 	if sm.sendToTPTFunc != nil {
 		if err := sm.sendToTPTFunc(pauseCmd); err != nil {
-			return fmt.Errorf("failed to send PAUSE command: %w", err)
+			return "", newError(ErrSendFailed, nil, "failed to send PAUSE command: %w", err)
 		}
 	}
 
-	log.Printf("[PAUSE] Sent to channel %s", channelID)
+	logState.Info("command sent", "command", CmdPause, "channel", channelID)
+	sm.commandSentLocked(CmdPause, channelID, pauseCmd.MsgID)
+	sm.recordMessageLocked(channelID, "MES->TPT", pauseCmd.Type, pauseCmd)
 
 	sm.broadcast(map[string]interface{}{
 		"direction": "MES->TPT",
 		"data":      pauseCmd,
 	})
 
-	return nil
+	return pauseCmd.MsgID, nil
 }
 
 // ValidateAndSendResume 驗證並發送 RESUME 命令
 func (sm *StateManager) ValidateAndSendResume(channelID string) error {
+	_, err := sm.sendResume(channelID)
+	return err
+}
+
+// sendResume 驗證並發送 RESUME 命令，回傳命令的 msg_id
+func (sm *StateManager) sendResume(channelID string) (msgID string, err error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	defer sm.reportFailureLocked(CmdResume, channelID, &err)
 
 	if !sm.isConnected {
-		return fmt.Errorf("TPT is not connected")
+		return "", errTPTNotConnected
 	}
 
 	_, exists := sm.channels[channelID]
 	if !exists {
-		return fmt.Errorf("channel %s does not exist", channelID)
+		return "", errChannelNotFound(channelID)
 	}
 
 	resumeCmd := models.ResumeMessage{
@@ -399,18 +497,20 @@ func (sm *StateManager) ValidateAndSendResume(channelID string) error {
 
 	if sm.sendToTPTFunc != nil {
 		if err := sm.sendToTPTFunc(resumeCmd); err != nil {
-			return fmt.Errorf("failed to send RESUME command: %w", err)
+			return "", newError(ErrSendFailed, nil, "failed to send RESUME command: %w", err)
 		}
 	}
 
-	log.Printf("[RESUME] Sent to channel %s", channelID)
+	logState.Info("command sent", "command", CmdResume, "channel", channelID)
+	sm.commandSentLocked(CmdResume, channelID, resumeCmd.MsgID)
+	sm.recordMessageLocked(channelID, "MES->TPT", resumeCmd.Type, resumeCmd)
 
 	sm.broadcast(map[string]interface{}{
 		"direction": "MES->TPT",
 		"data":      resumeCmd,
 	})
 
-	return nil
+	return resumeCmd.MsgID, nil
 }
 
 // GetAllChannels 取得所有通道狀態（用於前端顯示）
@@ -428,26 +528,80 @@ func (sm *StateManager) GetAllChannels() []ChannelState {
 	return channels
 }
 
+// GetChannel 取得單一通道狀態
+func (sm *StateManager) GetChannel(channelID string) (ChannelState, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	ch, exists := sm.channels[channelID]
+	if !exists {
+		return ChannelState{}, false
+	}
+	return *ch, true
+}
+
+// ChannelCount 取得通道數量
+func (sm *StateManager) ChannelCount() int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.channelCount
+}
+
 // GetConnectionStatus 取得連線狀態
 func (sm *StateManager) GetConnectionStatus() map[string]interface{} {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
+	return sm.connectionStatusLocked()
+}
 
+// connectionStatusLocked 組出連線狀態（呼叫端需持有鎖）
+func (sm *StateManager) connectionStatusLocked() map[string]interface{} {
 	return map[string]interface{}{
 		"tpt_connected":     sm.isConnected, // TPT 狀態（收到 LINK 後為 true）
 		"work_station_name": sm.workStationName,
 		"tpt_state":         sm.tptState,
 		"channel_count":     sm.channelCount,
+		"tcp_connected":     sm.tcpClients > 0, // TCP 連線狀態（純粹的 socket 連接）
+		"tcp_clients":       sm.tcpClients,
+	}
+}
+
+// UpdateTCPClientCount 以增減量更新 TCP 連線數量（由 TCPServer 在連線建立/關閉時呼叫）
+// 所有連線都中斷時，TPT 視為離線，需要重新 LINK
+func (sm *StateManager) UpdateTCPClientCount(delta int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.tcpClients += delta
+	if sm.tcpClients == 0 && sm.isConnected {
+		sm.isConnected = false
+		sm.tptState = models.ConnOffline
+		logState.Info("all TCP connections closed, TPT is now offline")
+	}
+	sm.emitConnectionUpdateLocked()
+}
+
+// reportFailureLocked 命令失敗時推送 command_result（以 defer 呼叫，呼叫端需持有鎖）
+func (sm *StateManager) reportFailureLocked(cmdType, channelID string, err *error) {
+	if *err != nil {
+		sm.commandFailedLocked(cmdType, channelID, *err)
 	}
 }
 
 // SendRspStatus 發送 RSP_STATUS 命令
 func (sm *StateManager) SendRspStatus() error {
+	_, err := sm.sendRspStatus()
+	return err
+}
+
+// sendRspStatus 驗證並發送 RSP_STATUS 命令，回傳命令的 msg_id
+func (sm *StateManager) sendRspStatus() (msgID string, err error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	defer sm.reportFailureLocked(CmdRspStatus, "", &err)
 
 	if !sm.isConnected {
-		return fmt.Errorf("TPT is not connected")
+		return "", errTPTNotConnected
 	}
 
 	// 建立 RSP_STATUS 命令
@@ -461,11 +615,12 @@ func (sm *StateManager) SendRspStatus() error {
 	// 發送到 TPT
 	if sm.sendToTPTFunc != nil {
 		if err := sm.sendToTPTFunc(rspStatusCmd); err != nil {
-			return fmt.Errorf("failed to send RSP_STATUS command: %w", err)
+			return "", newError(ErrSendFailed, nil, "failed to send RSP_STATUS command: %w", err)
 		}
 	}
 
-	log.Printf("[RSP_STATUS] Sent to TPT")
+	logState.Info("command sent", "command", CmdRspStatus)
+	sm.commandSentLocked(CmdRspStatus, "", rspStatusCmd["msg_id"].(string))
 
 	// 廣播到前端
 	sm.broadcast(map[string]interface{}{
@@ -473,33 +628,93 @@ func (sm *StateManager) SendRspStatus() error {
 		"data":      rspStatusCmd,
 	})
 
-	return nil
+	return rspStatusCmd["msg_id"].(string), nil
 }
 
-// SendUserCommand 發送自訂命令
+// SendUserCommand 發送自訂命令（僅指定 type）
 func (sm *StateManager) SendUserCommand(commandType string) error {
+	_, _, err := sm.SendUserCommandPayload(map[string]interface{}{"type": commandType}, 0)
+	return err
+}
+
+// BuildUserCommand 依 payload 組出自訂命令
+// type 為必填；timestamp、msg_id、work_station_name 未指定時自動補上
+func (sm *StateManager) BuildUserCommand(payload map[string]interface{}) (map[string]interface{}, error) {
+	commandType, _ := payload["type"].(string)
+	if commandType == "" {
+		return nil, newError(ErrInvalidRequest, nil, "missing type field")
+	}
+
+	sm.mu.RLock()
+	workStationName := sm.workStationName
+	sm.mu.RUnlock()
+
+	userCmd := make(map[string]interface{}, len(payload)+3)
+	for k, v := range payload {
+		userCmd[k] = v
+	}
+	if _, ok := userCmd["timestamp"]; !ok {
+		userCmd["timestamp"] = models.GetTimestamp()
+	}
+	if _, ok := userCmd["msg_id"]; !ok {
+		userCmd["msg_id"] = models.GenerateMsgID()
+	}
+	if _, ok := userCmd["work_station_name"]; !ok {
+		userCmd["work_station_name"] = workStationName
+	}
+
+	return userCmd, nil
+}
+
+// SendUserCommandPayload 發送任意 JSON 自訂命令
+// waitTimeout > 0 時會等待 reply_to 與 msg_id 相符的回覆（逾時回傳 ErrReplyTimeout）
+func (sm *StateManager) SendUserCommandPayload(payload map[string]interface{}, waitTimeout time.Duration) (map[string]interface{}, map[string]interface{}, error) {
+	userCmd, err := sm.BuildUserCommand(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	msgID := fmt.Sprint(userCmd["msg_id"])
+
+	// 先登記等待，避免回覆比登記更早到達
+	var replyChan chan map[string]interface{}
+	if waitTimeout > 0 {
+		replyChan = sm.registerReply(msgID)
+		defer sm.cancelReply(msgID)
+	}
+
+	if err := sm.sendUserCommand(userCmd); err != nil {
+		return nil, nil, err
+	}
+
+	if replyChan == nil {
+		return userCmd, nil, nil
+	}
+
+	select {
+	case reply := <-replyChan:
+		return userCmd, reply, nil
+	case <-time.After(waitTimeout):
+		return userCmd, nil, ErrReplyTimeout
+	}
+}
+
+// sendUserCommand 發送已組好的自訂命令
+func (sm *StateManager) sendUserCommand(userCmd map[string]interface{}) error {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
 	if !sm.isConnected {
-		return fmt.Errorf("TPT is not connected")
-	}
-
-	// 建立自訂命令
-	userCmd := map[string]interface{}{
-		"type":      commandType,
-		"timestamp": models.GetTimestamp(),
-		"msg_id":    models.GenerateMsgID(),
+		return errTPTNotConnected
 	}
 
 	// 發送到 TPT
 	if sm.sendToTPTFunc != nil {
 		if err := sm.sendToTPTFunc(userCmd); err != nil {
-			return fmt.Errorf("failed to send user command: %w", err)
+			return newError(ErrSendFailed, nil, "failed to send user command: %w", err)
 		}
 	}
 
-	log.Printf("[USER_COMMAND] Sent command with type: %s", commandType)
+	logState.Info("user command sent", "type", userCmd["type"], "msg_id", userCmd["msg_id"])
 
 	// 廣播到前端
 	sm.broadcast(map[string]interface{}{
@@ -509,3 +724,37 @@ func (sm *StateManager) SendUserCommand(commandType string) error {
 
 	return nil
 }
+
+// ErrReplyTimeout 等待回覆逾時
+var ErrReplyTimeout = errors.New("timed out waiting for reply")
+
+// registerReply 登記等待指定 msg_id 的回覆
+func (sm *StateManager) registerReply(msgID string) chan map[string]interface{} {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	replyChan := make(chan map[string]interface{}, 1)
+	sm.pendingReplies[msgID] = replyChan
+	return replyChan
+}
+
+// cancelReply 取消等待
+func (sm *StateManager) cancelReply(msgID string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	delete(sm.pendingReplies, msgID)
+}
+
+// deliverReply 將回覆交給等待者（若有）
+func (sm *StateManager) deliverReply(replyTo string, msg map[string]interface{}) {
+	sm.mu.Lock()
+	replyChan, exists := sm.pendingReplies[replyTo]
+	if exists {
+		delete(sm.pendingReplies, replyTo)
+	}
+	sm.mu.Unlock()
+
+	if exists {
+		replyChan <- msg
+	}
+}
@@ -0,0 +1,776 @@
+package core
+
+import (
+	"GoTestMES/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder 記錄 StateManager 發送給 TPT 的命令與廣播的事件
+type recorder struct {
+	mu      sync.Mutex
+	sent    []map[string]interface{}
+	events  []interface{}
+	sendErr error
+}
+
+func (r *recorder) send(data interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sendErr != nil {
+		return r.sendErr
+	}
+	r.sent = append(r.sent, toMap(data))
+	return nil
+}
+
+func (r *recorder) broadcast(data interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, data)
+}
+
+// lastSent 取得最後一筆發送的命令
+func (r *recorder) lastSent(t *testing.T) map[string]interface{} {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.sent) == 0 {
+		t.Fatal("no command was sent")
+	}
+	return r.sent[len(r.sent)-1]
+}
+
+// commandResults 取得所有 command_result 事件
+func (r *recorder) commandResults() []CommandResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var results []CommandResult
+	for _, event := range r.events {
+		if result, ok := event.(CommandResult); ok {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// toMap 將訊息結構轉為 map 以便比對欄位
+func toMap(data interface{}) map[string]interface{} {
+	raw, _ := json.Marshal(data)
+	var m map[string]interface{}
+	json.Unmarshal(raw, &m)
+	return m
+}
+
+// newTestStateManager 建立已設定發送與廣播函數的 StateManager
+func newTestStateManager(t *testing.T, channelCount int) (*StateManager, *recorder) {
+	t.Helper()
+	sm := NewStateManager(channelCount)
+	rec := &recorder{}
+	sm.SetSendToTPTFunc(rec.send)
+	sm.SetBroadcastFunc(rec.broadcast)
+	return sm, rec
+}
+
+// handle 將訊息交給 HandleMessage 並回傳轉為 map 的回覆
+func handle(t *testing.T, sm *StateManager, msg map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	raw, _ := json.Marshal(msg)
+	response, err := sm.HandleMessage(raw)
+	if err != nil {
+		t.Fatalf("HandleMessage(%s): %v", raw, err)
+	}
+	if response == nil {
+		return nil
+	}
+	return toMap(response)
+}
+
+// linkTPT 送出 LINK 與 STATUS_ALL，將所有通道設定為指定狀態
+func linkTPT(t *testing.T, sm *StateManager, channelCount int, state string) {
+	t.Helper()
+	handle(t, sm, map[string]interface{}{
+		"type": "LINK", "msg_id": "L1", "work_station_name": "WS1", "state": "Online-Auto", "channel_count": fmt.Sprint(channelCount),
+	})
+	channels := make([]map[string]string, channelCount)
+	for i := range channels {
+		channels[i] = map[string]string{"ch": fmt.Sprintf("%03d", i+1), "state": state}
+	}
+	handle(t, sm, map[string]interface{}{
+		"type": "STATUS_ALL", "msg_id": "SA1", "work_station_name": "WS1", "channels": channels,
+	})
+}
+
+// setChannelState 以 STATUS 訊息設定單一通道狀態
+func setChannelState(t *testing.T, sm *StateManager, channelID, state string) {
+	t.Helper()
+	handle(t, sm, map[string]interface{}{
+		"type": "STATUS", "msg_id": "S-" + channelID, "work_station_name": "WS1", "channel": channelID, "state": state,
+	})
+}
+
+func assertAck(t *testing.T, ack map[string]interface{}, wantType, replyTo string) {
+	t.Helper()
+	if ack["type"] != wantType {
+		t.Fatalf("type = %v, want %s", ack["type"], wantType)
+	}
+	if ack["reply_to"] != replyTo {
+		t.Fatalf("reply_to = %v, want %s", ack["reply_to"], replyTo)
+	}
+	if ack["ack"] != models.AckOK {
+		t.Fatalf("ack = %v, want OK", ack["ack"])
+	}
+	if ack["msg_id"] == "" || ack["timestamp"] == "" {
+		t.Fatalf("msg_id and timestamp must be set: %v", ack)
+	}
+}
+
+func TestNewStateManagerChannels(t *testing.T) {
+	sm, _ := newTestStateManager(t, 4)
+	channels := sm.GetAllChannels()
+	if len(channels) != 4 {
+		t.Fatalf("got %d channels, want 4", len(channels))
+	}
+	for i, ch := range channels {
+		if want := fmt.Sprintf("CH%03d", i+1); ch.ChannelID != want {
+			t.Fatalf("channel %d = %s, want %s", i, ch.ChannelID, want)
+		}
+		if ch.State != models.StateOffLine {
+			t.Fatalf("%s initial state = %s, want OffLine", ch.ChannelID, ch.State)
+		}
+	}
+}
+
+func TestHandleLink(t *testing.T) {
+	sm, _ := newTestStateManager(t, 4)
+	ack := handle(t, sm, map[string]interface{}{
+		"type": "LINK", "msg_id": "M1", "work_station_name": "WS7", "state": "Online-Auto", "channel_count": "4",
+	})
+
+	assertAck(t, ack, "LINK_ACK", "M1")
+	if ack["work_station_name"] != "WS7" {
+		t.Fatalf("work_station_name = %v", ack["work_station_name"])
+	}
+
+	status := sm.GetConnectionStatus()
+	if status["tpt_connected"] != true || status["work_station_name"] != "WS7" || status["tpt_state"] != "Online-Auto" {
+		t.Fatalf("unexpected status after LINK: %v", status)
+	}
+}
+
+func TestHandleStatus(t *testing.T) {
+	sm, _ := newTestStateManager(t, 4)
+	linkTPT(t, sm, 4, models.StateStandBy)
+
+	ack := handle(t, sm, map[string]interface{}{
+		"type": "STATUS", "msg_id": "M2", "work_station_name": "WS1", "channel": "CH002", "state": models.StateAlarm, "message": "over voltage",
+	})
+	assertAck(t, ack, "STATUS_ACK", "M2")
+	if ack["channel"] != "CH002" {
+		t.Fatalf("channel = %v", ack["channel"])
+	}
+
+	ch, _ := sm.GetChannel("CH002")
+	if ch.State != models.StateAlarm || ch.Message != "over voltage" {
+		t.Fatalf("CH002 = %+v", ch)
+	}
+	if other, _ := sm.GetChannel("CH001"); other.State != models.StateStandBy {
+		t.Fatalf("CH001 changed to %s", other.State)
+	}
+}
+
+func TestHandleStatusUnknownChannelStillAcks(t *testing.T) {
+	sm, _ := newTestStateManager(t, 2)
+	ack := handle(t, sm, map[string]interface{}{
+		"type": "STATUS", "msg_id": "M3", "work_station_name": "WS1", "channel": "CH099", "state": models.StateRunning,
+	})
+	assertAck(t, ack, "STATUS_ACK", "M3")
+	if _, exists := sm.GetChannel("CH099"); exists {
+		t.Fatal("unknown channel must not be created")
+	}
+}
+
+func TestHandleStatusAll(t *testing.T) {
+	sm, rec := newTestStateManager(t, 4)
+	ack := handle(t, sm, map[string]interface{}{
+		"type": "STATUS_ALL", "msg_id": "M4", "work_station_name": "WS1",
+		"channels": []map[string]string{
+			{"ch": "001", "state": models.StateStandBy},
+			{"ch": "003", "state": models.StateRunning},
+			{"ch": "999", "state": models.StateRunning},
+		},
+	})
+	assertAck(t, ack, "STATUS_ALL_ACK", "M4")
+
+	want := map[string]string{
+		"CH001": models.StateStandBy,
+		"CH002": models.StateOffLine,
+		"CH003": models.StateRunning,
+		"CH004": models.StateOffLine,
+	}
+	for id, state := range want {
+		if ch, _ := sm.GetChannel(id); ch.State != state {
+			t.Fatalf("%s = %s, want %s", id, ch.State, state)
+		}
+	}
+
+	// 只推送有變更的通道
+	var updated []string
+	for _, event := range rec.events {
+		m, ok := event.(map[string]interface{})
+		if !ok || m["type"] != EventChannelUpdate {
+			continue
+		}
+		for _, ch := range m["channels"].([]ChannelState) {
+			updated = append(updated, ch.ChannelID)
+		}
+	}
+	if fmt.Sprint(updated) != "[CH001 CH003]" {
+		t.Fatalf("channel_update channels = %v", updated)
+	}
+}
+
+func TestHandleReport(t *testing.T) {
+	sm, _ := newTestStateManager(t, 4)
+	linkTPT(t, sm, 4, models.StateStandBy)
+	setChannelState(t, sm, "CH003", models.StateRunning)
+
+	ack := handle(t, sm, map[string]interface{}{
+		"type": "REPORT", "msg_id": "M5", "work_station_name": "WS1", "channel": "ch003", "record_path": `D:\records\003.csv`,
+	})
+	assertAck(t, ack, "REPORT_ACK", "M5")
+	if ack["channel"] != "ch003" {
+		t.Fatalf("REPORT_ACK must echo the original channel, got %v", ack["channel"])
+	}
+
+	ch, _ := sm.GetChannel("CH003")
+	if ch.State != models.StateFinish {
+		t.Fatalf("CH003 = %s, want Finish", ch.State)
+	}
+	detail, _ := sm.GetChannelDetail("CH003")
+	if detail.LastRun == nil || detail.LastRun.RecordPath != `D:\records\003.csv` {
+		t.Fatalf("record path not stored: %+v", detail.LastRun)
+	}
+}
+
+func TestHandleCommandAck(t *testing.T) {
+	sm, rec := newTestStateManager(t, 4)
+	linkTPT(t, sm, 4, models.StateStandBy)
+
+	msgID, err := sm.SendCommand(Command{Type: CmdStart, Channel: "CH001", Barcode: "B1", Process: "P1", DataPath: "D1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := sm.pendingCommandCount(); pending != 1 {
+		t.Fatalf("pending = %d, want 1", pending)
+	}
+
+	response := handle(t, sm, map[string]interface{}{
+		"type": "START_ACK", "msg_id": "A1", "reply_to": msgID, "channel": "CH001", "ack": models.AckNG, "message": "no battery",
+	})
+	if response != nil {
+		t.Fatalf("command ACKs must not be answered, got %v", response)
+	}
+	if pending, _ := sm.pendingCommandCount(); pending != 0 {
+		t.Fatalf("pending = %d after ACK, want 0", pending)
+	}
+
+	results := rec.commandResults()
+	last := results[len(results)-1]
+	if last.Status != CommandAcked || last.Command != CmdStart || last.Ack != models.AckNG ||
+		last.MsgID != msgID || last.Channel != "CH001" || last.Message != "no battery" {
+		t.Fatalf("unexpected command_result: %+v", last)
+	}
+}
+
+func TestHandleCommandAckWithoutPendingCommand(t *testing.T) {
+	sm, rec := newTestStateManager(t, 2)
+	handle(t, sm, map[string]interface{}{
+		"type": "STOP_ACK", "msg_id": "A2", "reply_to": "unknown", "channel": "ch002", "ack": models.AckOK,
+	})
+
+	results := rec.commandResults()
+	if len(results) != 1 || results[0].Command != CmdStop || results[0].Channel != "CH002" {
+		t.Fatalf("unexpected command_result: %+v", results)
+	}
+}
+
+func TestHandleMessageErrors(t *testing.T) {
+	sm, _ := newTestStateManager(t, 2)
+	for _, input := range []string{
+		`{"type":"UNKNOWN"}`,
+		`{"type":`,
+		`{"type":"LINK","channel_count":[]}`,
+	} {
+		if _, err := sm.HandleMessage([]byte(input)); err == nil {
+			t.Fatalf("HandleMessage(%s): expected error", input)
+		}
+	}
+}
+
+func TestHandleMessageHook(t *testing.T) {
+	sm, _ := newTestStateManager(t, 2)
+	var gotType, gotPrevious string
+	var gotMsg map[string]interface{}
+	sm.SetMessageHook(func(msgType string, msg map[string]interface{}, previousState string) {
+		gotType, gotMsg, gotPrevious = msgType, msg, previousState
+		// 掛勾在不持有鎖時呼叫，可以查詢狀態
+		sm.GetChannel("CH001")
+	})
+
+	setChannelState(t, sm, "CH001", models.StateStandBy)
+	if gotType != "STATUS" || gotMsg["channel"] != "CH001" {
+		t.Fatalf("hook got %s %v", gotType, gotMsg)
+	}
+	setChannelState(t, sm, "CH001", models.StateAlarm)
+	if gotPrevious != models.StateStandBy {
+		t.Fatalf("previous state = %q, want %s", gotPrevious, models.StateStandBy)
+	}
+}
+
+func TestRulesFireOnStatusTransition(t *testing.T) {
+	sm, _ := newTestStateManager(t, 2)
+	engine := NewRulesEngine(sm)
+	t.Cleanup(engine.Stop)
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	rules := `[{"id":"alarm-stop","when":{"message_type":"STATUS","state":"Alarm"},"then":{"command":{"type":"STOP"}}}]`
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if !engine.List()[0].Enabled {
+		t.Fatal("a rule without enabled must default to enabled")
+	}
+
+	fireCount := func() int { return engine.List()[0].FireCount }
+
+	// TPT 定期重送相同狀態時只觸發一次
+	setChannelState(t, sm, "CH001", models.StateAlarm)
+	setChannelState(t, sm, "CH001", models.StateAlarm)
+	setChannelState(t, sm, "CH002", models.StateStandBy)
+	if got := fireCount(); got != 1 {
+		t.Fatalf("fire count = %d after repeated Alarm, want 1", got)
+	}
+
+	// 離開後再次進入 Alarm 會重新觸發
+	setChannelState(t, sm, "CH001", models.StateStandBy)
+	setChannelState(t, sm, "CH001", models.StateAlarm)
+	if got := fireCount(); got != 2 {
+		t.Fatalf("fire count = %d after re-entering Alarm, want 2", got)
+	}
+}
+
+func TestExecuteBatchLimits(t *testing.T) {
+	sm, rec := newTestStateManager(t, 4)
+	linkTPT(t, sm, 4, models.StateRunning)
+	channelIDs := []string{"CH001", "CH002", "CH003", "CH004"}
+
+	for _, tc := range []struct {
+		name     string
+		cmd      Command
+		interval time.Duration
+	}{
+		{"rsp_status", Command{Type: CmdRspStatus}, 0},
+		{"interval", Command{Type: CmdStop}, MaxBatchInterval + time.Millisecond},
+		{"duration", Command{Type: CmdStop}, MaxBatchDuration/3 + time.Millisecond},
+	} {
+		if _, err := sm.ExecuteBatch(tc.cmd, channelIDs, nil, tc.interval); !errors.Is(err, ErrInvalidRequest) {
+			t.Fatalf("%s: err = %v, want ErrInvalidRequest", tc.name, err)
+		}
+	}
+	rec.mu.Lock()
+	sent := len(rec.sent)
+	rec.mu.Unlock()
+	if sent != 0 {
+		t.Fatalf("rejected batches sent %d command(s)", sent)
+	}
+
+	results, err := sm.ExecuteBatch(Command{Type: CmdStop}, channelIDs, nil, 0)
+	if err != nil || len(results) != 4 {
+		t.Fatalf("ExecuteBatch = %v, %v", results, err)
+	}
+}
+
+func TestResolveChannels(t *testing.T) {
+	sm, _ := newTestStateManager(t, 4)
+	linkTPT(t, sm, 4, models.StateStandBy)
+	setChannelState(t, sm, "CH002", models.StateAlarm)
+	setChannelState(t, sm, "CH004", models.StateAlarm)
+
+	tests := []struct {
+		name      string
+		selectors []string
+		want      string
+		wantErr   error
+	}{
+		{"single", []string{"ch003"}, "CH003", nil},
+		{"number", []string{"1"}, "CH001", nil},
+		{"range", []string{"CH002-CH004"}, "CH002,CH003,CH004", nil},
+		{"numeric range", []string{"1-2"}, "CH001,CH002", nil},
+		{"list sorted and deduplicated", []string{"4, 1,4", "CH001"}, "CH001,CH004", nil},
+		{"all", []string{"all"}, "CH001,CH002,CH003,CH004", nil},
+		{"all by state", []string{"all Alarm"}, "CH002,CH004", nil},
+		{"state", []string{"standby"}, "CH001,CH003", nil},
+		{"empty", []string{" , "}, "", nil},
+		{"range over count", []string{"CH003-CH005"}, "", ErrNotFound},
+		{"channel over count", []string{"CH005"}, "", ErrNotFound},
+		{"reversed range", []string{"CH004-CH002"}, "", ErrInvalidRequest},
+		{"invalid range", []string{"CH000-CH002"}, "", ErrInvalidRequest},
+		{"unknown state", []string{"Sleeping"}, "", ErrInvalidRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sm.ResolveChannels(tt.selectors...)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if joined := strings.Join(got, ","); joined != tt.want {
+				t.Fatalf("ResolveChannels(%q) = %s, want %s", tt.selectors, joined, tt.want)
+			}
+		})
+	}
+}
+
+func TestRulesFireOnStatusAllThreshold(t *testing.T) {
+	sm, _ := newTestStateManager(t, 4)
+	linkTPT(t, sm, 4, models.StateStandBy)
+	engine := NewRulesEngine(sm)
+	t.Cleanup(engine.Stop)
+	if _, err := engine.Add(Rule{ID: "mass-alarm", Enabled: true,
+		When: RuleCondition{MessageType: "STATUS_ALL", State: models.StateAlarm, CountGT: 1},
+		Then: RuleAction{Command: Command{Type: CmdPause}, Selector: "all Running"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	statusAll := func(alarms int) {
+		t.Helper()
+		channels := make([]interface{}, 4)
+		for i := range channels {
+			state := models.StateStandBy
+			if i < alarms {
+				state = models.StateAlarm
+			}
+			channels[i] = map[string]interface{}{"ch": fmt.Sprintf("%03d", i+1), "state": state}
+		}
+		handle(t, sm, map[string]interface{}{"type": "STATUS_ALL", "msg_id": "SA", "work_station_name": "WS1", "channels": channels})
+	}
+
+	// 超過門檻後重送相同的 STATUS_ALL 不會重複觸發
+	for _, tc := range []struct{ alarms, want int }{{1, 0}, {2, 1}, {3, 1}, {2, 1}, {1, 1}, {3, 2}} {
+		statusAll(tc.alarms)
+		if got := engine.List()[0].FireCount; got != tc.want {
+			t.Fatalf("after %d alarm(s): fire count = %d, want %d", tc.alarms, got, tc.want)
+		}
+	}
+}
+
+func TestSchedulerMinimumInterval(t *testing.T) {
+	sm, _ := newTestStateManager(t, 2)
+	path := filepath.Join(t.TempDir(), "schedules.json")
+	scheduler := NewScheduler(sm, path)
+	t.Cleanup(scheduler.Stop)
+
+	at := time.Now().Add(time.Hour)
+	_, err := scheduler.Add(Schedule{Command: Command{Type: CmdRspStatus}, NextRun: at, IntervalMs: 1})
+	if !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("interval_ms 1: err = %v, want ErrInvalidRequest", err)
+	}
+	if _, err := scheduler.Add(Schedule{Command: Command{Type: CmdRspStatus}, NextRun: at, IntervalMs: MinScheduleInterval.Milliseconds()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 排程檔案中過短的間隔在載入時提高到最小值
+	data, _ := json.Marshal([]Schedule{{ID: "fast", Command: Command{Type: CmdRspStatus}, NextRun: at, IntervalMs: 5}})
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewScheduler(sm, path)
+	t.Cleanup(loaded.Stop)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if list := loaded.List(); len(list) != 1 || list[0].IntervalMs != MinScheduleInterval.Milliseconds() {
+		t.Fatalf("loaded schedules = %+v", list)
+	}
+}
+
+func TestSchedulerStopDuringRun(t *testing.T) {
+	sm, _ := newTestStateManager(t, 2)
+	linkTPT(t, sm, 2, models.StateStandBy)
+	started, release := make(chan struct{}), make(chan struct{})
+	sm.SetSendToTPTFunc(func(interface{}) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	path := filepath.Join(t.TempDir(), "schedules.json")
+	scheduler := NewScheduler(sm, path)
+	added, err := scheduler.Add(Schedule{Command: Command{Type: CmdRspStatus}, IntervalMs: time.Hour.Milliseconds()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 排程執行中停止：週期排程不得從檔案中消失
+	<-started
+	scheduler.Stop()
+	close(release)
+	waitUntil(t, "schedule run finished", func() bool {
+		list := scheduler.List()
+		return len(list) == 1 && list[0].RunCount == 1
+	})
+
+	loaded := NewScheduler(sm, path)
+	t.Cleanup(loaded.Stop)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if list := loaded.List(); len(list) != 1 || list[0].ID != added.ID {
+		t.Fatalf("reloaded schedules = %+v", list)
+	}
+}
+
+func TestSchedulerUniqueIDs(t *testing.T) {
+	sm, _ := newTestStateManager(t, 2)
+	scheduler := NewScheduler(sm, "")
+	t.Cleanup(scheduler.Stop)
+
+	at := time.Now().Add(time.Hour)
+	for i := 0; i < 50; i++ {
+		if _, err := scheduler.Add(Schedule{Command: Command{Type: CmdRspStatus}, NextRun: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(scheduler.List()); got != 50 {
+		t.Fatalf("%d schedule(s) kept, want 50", got)
+	}
+}
+
+func TestHandleMessageDeliversReply(t *testing.T) {
+	sm, rec := newTestStateManager(t, 2)
+	linkTPT(t, sm, 2, models.StateStandBy)
+
+	done := make(chan map[string]interface{}, 1)
+	go func() {
+		_, reply, err := sm.SendUserCommandPayload(map[string]interface{}{"type": "GET_INFO"}, 2*time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- reply
+	}()
+
+	// 等待命令送出後回覆
+	var sent map[string]interface{}
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		rec.mu.Lock()
+		n := len(rec.sent)
+		rec.mu.Unlock()
+		if n > 0 {
+			sent = rec.lastSent(t)
+			break
+		}
+	}
+	if sent == nil || sent["type"] != "GET_INFO" || sent["work_station_name"] != "WS1" {
+		t.Fatalf("unexpected user command: %v", sent)
+	}
+	handle(t, sm, map[string]interface{}{"type": "GET_INFO_ACK", "msg_id": "R1", "reply_to": sent["msg_id"], "ack": "OK", "version": "1.2"})
+
+	if reply := <-done; reply["version"] != "1.2" {
+		t.Fatalf("reply = %v", reply)
+	}
+}
+
+func TestValidateAndSendStart(t *testing.T) {
+	tests := []struct {
+		state   string
+		wantErr error
+	}{
+		{models.StateStandBy, nil},
+		{models.StateFinish, nil},
+		{models.StateNoLoad, nil},
+		{models.StateRunning, ErrStateConflict},
+		{models.StatePaused, ErrStateConflict},
+		{models.StateAlarm, ErrStateConflict},
+		{models.StateOffLine, ErrStateConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			sm, rec := newTestStateManager(t, 2)
+			linkTPT(t, sm, 2, tt.state)
+
+			err := sm.ValidateAndSendStart("CH001", "BC1", "PROC", `D:\data`)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				if len(rec.sent) != 0 {
+					t.Fatalf("rejected START must not be sent: %v", rec.sent)
+				}
+				results := rec.commandResults()
+				if len(results) != 1 || results[0].Status != CommandFailed {
+					t.Fatalf("expected a failed command_result, got %+v", results)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd := rec.lastSent(t)
+			if cmd["type"] != "START" || cmd["channel"] != "CH001" || cmd["barcode"] != "BC1" ||
+				cmd["process"] != "PROC" || cmd["data_path"] != `D:\data` || cmd["work_station_name"] != "WS1" {
+				t.Fatalf("unexpected START: %v", cmd)
+			}
+			ch, _ := sm.GetChannel("CH001")
+			if ch.Barcode != "BC1" || ch.Process != "PROC" {
+				t.Fatalf("channel not updated: %+v", ch)
+			}
+		})
+	}
+}
+
+func TestSendCommandsRequireConnection(t *testing.T) {
+	sm, rec := newTestStateManager(t, 2)
+	sends := map[string]func() error{
+		CmdStart:     func() error { return sm.ValidateAndSendStart("CH001", "B", "P", "D") },
+		CmdStop:      func() error { return sm.ValidateAndSendStop("CH001") },
+		CmdPause:     func() error { return sm.ValidateAndSendPause("CH001") },
+		CmdResume:    func() error { return sm.ValidateAndSendResume("CH001") },
+		CmdRspStatus: sm.SendRspStatus,
+		"USER":       func() error { return sm.SendUserCommand("PING") },
+	}
+	for name, send := range sends {
+		if err := send(); !errors.Is(err, ErrTPTOffline) {
+			t.Fatalf("%s: err = %v, want ErrTPTOffline", name, err)
+		}
+	}
+	if len(rec.sent) != 0 {
+		t.Fatalf("nothing should be sent while offline: %v", rec.sent)
+	}
+}
+
+func TestSendCommandsUnknownChannel(t *testing.T) {
+	sm, _ := newTestStateManager(t, 2)
+	linkTPT(t, sm, 2, models.StateStandBy)
+
+	for _, cmd := range []string{CmdStart, CmdStop, CmdPause, CmdResume} {
+		_, err := sm.SendCommand(Command{Type: cmd, Channel: "CH009", Barcode: "B", Process: "P", DataPath: "D"})
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s: err = %v, want ErrNotFound", cmd, err)
+		}
+		if details := ErrorDetails(err); details["channel"] != "CH009" {
+			t.Fatalf("%s: details = %v", cmd, details)
+		}
+	}
+}
+
+func TestSendStopPauseResume(t *testing.T) {
+	sm, rec := newTestStateManager(t, 2)
+	linkTPT(t, sm, 2, models.StateRunning)
+
+	for _, cmd := range []string{CmdStop, CmdPause, CmdResume} {
+		msgID, err := sm.SendCommand(Command{Type: cmd, Channel: "CH002"})
+		if err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+		sent := rec.lastSent(t)
+		if sent["type"] != cmd || sent["channel"] != "CH002" || sent["msg_id"] != msgID {
+			t.Fatalf("%s: unexpected command %v", cmd, sent)
+		}
+	}
+	if pending, _ := sm.pendingCommandCount(); pending != 3 {
+		t.Fatalf("pending = %d, want 3", pending)
+	}
+}
+
+func TestSendRspStatus(t *testing.T) {
+	sm, rec := newTestStateManager(t, 2)
+	linkTPT(t, sm, 2, models.StateStandBy)
+
+	if err := sm.SendRspStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if sent := rec.lastSent(t); sent["type"] != "RSP_STATUS" || sent["work_station_name"] != "WS1" {
+		t.Fatalf("unexpected RSP_STATUS: %v", sent)
+	}
+}
+
+func TestSendFailureIsClassified(t *testing.T) {
+	sm, rec := newTestStateManager(t, 2)
+	linkTPT(t, sm, 2, models.StateStandBy)
+	rec.sendErr = errors.New("connection reset")
+
+	err := sm.ValidateAndSendStop("CH001")
+	if !errors.Is(err, ErrSendFailed) {
+		t.Fatalf("err = %v, want ErrSendFailed", err)
+	}
+	if pending, _ := sm.pendingCommandCount(); pending != 0 {
+		t.Fatalf("failed command must not be pending, got %d", pending)
+	}
+}
+
+func TestBuildUserCommand(t *testing.T) {
+	sm, _ := newTestStateManager(t, 2)
+	linkTPT(t, sm, 2, models.StateStandBy)
+
+	if _, err := sm.BuildUserCommand(map[string]interface{}{"value": 1}); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("missing type: err = %v", err)
+	}
+
+	cmd, err := sm.BuildUserCommand(map[string]interface{}{"type": "SET", "msg_id": "fixed", "value": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd["msg_id"] != "fixed" || cmd["work_station_name"] != "WS1" || cmd["timestamp"] == nil || cmd["value"] != 1 {
+		t.Fatalf("unexpected user command: %v", cmd)
+	}
+}
+
+func TestUpdateTCPClientCount(t *testing.T) {
+	sm, _ := newTestStateManager(t, 2)
+	sm.UpdateTCPClientCount(1)
+	linkTPT(t, sm, 2, models.StateStandBy)
+	sm.UpdateTCPClientCount(1)
+
+	sm.UpdateTCPClientCount(-1)
+	if status := sm.GetConnectionStatus(); status["tpt_connected"] != true || status["tcp_clients"] != 1 {
+		t.Fatalf("TPT must stay linked while a connection remains: %v", status)
+	}
+
+	sm.UpdateTCPClientCount(-1)
+	status := sm.GetConnectionStatus()
+	if status["tpt_connected"] != false || status["tpt_state"] != models.ConnOffline || status["tcp_connected"] != false {
+		t.Fatalf("TPT must be offline after the last connection closes: %v", status)
+	}
+}
+
+func TestQueryChannelsNumericOrder(t *testing.T) {
+	sm := NewStateManager(1001)
+	channels, total := sm.QueryChannels(ChannelQuery{Offset: 998})
+	if total != 1001 || len(channels) != 3 {
+		t.Fatalf("total = %d, len = %d", total, len(channels))
+	}
+	for i, want := range []string{"CH999", "CH1000", "CH1001"} {
+		if channels[i].ChannelID != want {
+			t.Errorf("channels[%d] = %s, want %s", i, channels[i].ChannelID, want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// CommandTemplate 自訂命令範本
+type CommandTemplate struct {
+	Name    string                 `json:"name"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+// TemplateStore 自訂命令範本儲存區
+type TemplateStore struct {
+	mu        sync.RWMutex
+	filePath  string // 持久化檔案路徑（空字串表示不保存）
+	templates map[string]CommandTemplate
+}
+
+// NewTemplateStore 建立新的範本儲存區
+func NewTemplateStore(filePath string) *TemplateStore {
+	return &TemplateStore{
+		filePath:  filePath,
+		templates: make(map[string]CommandTemplate),
+	}
+}
+
+// Load 從檔案載入範本
+func (ts *TemplateStore) Load() error {
+	if ts.filePath == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(ts.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read template file: %w", err)
+	}
+
+	var templates []CommandTemplate
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return fmt.Errorf("failed to parse template file: %w", err)
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, t := range templates {
+		ts.templates[t.Name] = t
+	}
+
+	logState.Info("templates loaded", "count", len(templates), "file", ts.filePath)
+	return nil
+}
+
+// Get 取得範本
+func (ts *TemplateStore) Get(name string) (CommandTemplate, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	t, ok := ts.templates[name]
+	return t, ok
+}
+
+// Save 新增或覆寫範本
+func (ts *TemplateStore) Save(t CommandTemplate) error {
+	if t.Name == "" {
+		return newError(ErrInvalidRequest, nil, "missing template name")
+	}
+	if commandType, _ := t.Payload["type"].(string); commandType == "" {
+		return newError(ErrInvalidRequest, nil, "template payload must contain type")
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.templates[t.Name] = t
+	return ts.saveLocked()
+}
+
+// Delete 刪除範本
+func (ts *TemplateStore) Delete(name string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if _, ok := ts.templates[name]; !ok {
+		return newError(ErrNotFound, nil, "template %s does not exist", name)
+	}
+	delete(ts.templates, name)
+	return ts.saveLocked()
+}
+
+// List 取得所有範本（依名稱排序）
+func (ts *TemplateStore) List() []CommandTemplate {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	list := make([]CommandTemplate, 0, len(ts.templates))
+	for _, t := range ts.templates {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// saveLocked 將範本寫入檔案（呼叫端需持有鎖）
+func (ts *TemplateStore) saveLocked() error {
+	if ts.filePath == "" {
+		return nil
+	}
+
+	list := make([]CommandTemplate, 0, len(ts.templates))
+	for _, t := range ts.templates {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal templates: %w", err)
+	}
+
+	tmpPath := ts.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write template file: %w", err)
+	}
+	if err := os.Rename(tmpPath, ts.filePath); err != nil {
+		return fmt.Errorf("failed to write template file: %w", err)
+	}
+	return nil
+}
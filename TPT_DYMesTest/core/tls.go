@@ -0,0 +1,161 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// 未指定憑證時產生的開發用自簽憑證（保存後重複使用，避免每次啟動指紋都不同）
+const (
+	DevCertFile = "dymes-dev-cert.pem"
+	DevKeyFile  = "dymes-dev-key.pem"
+)
+
+// LoadTLSConfig 載入伺服器憑證；certFile 與 keyFile 皆為空時使用（或產生）開發用自簽憑證
+func LoadTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("both certificate and key files must be specified")
+	}
+
+	if certFile == "" {
+		certFile, keyFile = DevCertFile, DevKeyFile
+		if err := ensureDevCert(certFile, keyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+		logTCP.Info("server certificate loaded", "file", certFile, "cn", leaf.Subject.CommonName,
+			"expires", leaf.NotAfter.Format("2006-01-02"), "sha256", certFingerprint(leaf))
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// RequireClientCerts 要求客戶端憑證（mutual TLS），caFile 為簽發客戶端憑證的 CA（PEM）
+func RequireClientCerts(config *tls.Config, caFile string) error {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in client CA file %s", caFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return nil
+}
+
+// LoadClientCertMap 載入客戶端憑證與工作站名稱的對應表
+//
+//	{"TPT-01": "WS1", "3f2a...（憑證 SHA-256 指紋）": "WS2"}
+//
+// 鍵為憑證的 CN 或 SHA-256 指紋（十六進位），值為工作站名稱
+func LoadClientCertMap(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client certificate map: %w", err)
+	}
+	var mapping map[string]string
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("failed to parse client certificate map: %w", err)
+	}
+
+	normalized := make(map[string]string, len(mapping))
+	for key, workStation := range mapping {
+		normalized[normalizeFingerprint(key)] = workStation
+	}
+	return normalized, nil
+}
+
+// certFingerprint 憑證的 SHA-256 指紋（小寫十六進位）
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint 指紋可使用 AA:BB:... 或大寫格式；非指紋的鍵（CN）保持原樣
+func normalizeFingerprint(key string) string {
+	compact := strings.ToLower(strings.ReplaceAll(key, ":", ""))
+	if len(compact) == sha256.Size*2 {
+		if _, err := hex.DecodeString(compact); err == nil {
+			return compact
+		}
+	}
+	return key
+}
+
+// ensureDevCert 產生開發用自簽憑證（檔案已存在時直接使用）
+func ensureDevCert(certFile, keyFile string) error {
+	if _, err := os.Stat(certFile); err == nil {
+		if _, err := os.Stat(keyFile); err == nil {
+			return nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "DYMesTest development", Organization: []string{"DYMesTest"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode key: %w", err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+
+	logTCP.Warn("no certificate configured, generated self-signed development certificate", "file", certFile)
+	return nil
+}
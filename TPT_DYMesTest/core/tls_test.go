@@ -0,0 +1,132 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA 測試用的憑證簽發單位
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, cn string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue 簽發伺服器（localhost）或客戶端憑證
+func (ca *testCA) issue(t *testing.T, cn string, server bool) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestNormalizeFingerprint(t *testing.T) {
+	fingerprint := strings.Repeat("0123456789abcdef", 4)
+	colons := strings.ToUpper(fingerprint[:2])
+	for i := 2; i < len(fingerprint); i += 2 {
+		colons += ":" + strings.ToUpper(fingerprint[i:i+2])
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"lowercase", fingerprint, fingerprint},
+		{"uppercase", strings.ToUpper(fingerprint), fingerprint},
+		{"colon separated", colons, fingerprint},
+		{"common name", "TPT-01", "TPT-01"},
+		{"common name with colons", "TPT:01", "TPT:01"},
+		{"too short", fingerprint[:62], fingerprint[:62]},
+		{"not hex", strings.Repeat("g", 64), strings.Repeat("g", 64)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeFingerprint(tt.input); got != tt.want {
+				t.Fatalf("normalizeFingerprint(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadClientCertMap(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	leaf := ca.issue(t, "TPT-02", false).Leaf
+	fingerprint := certFingerprint(leaf)
+
+	path := filepath.Join(t.TempDir(), "clients.json")
+	content := `{"TPT-01": "WS1", "` + strings.ToUpper(fingerprint) + `": "WS2"}`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mapping, err := LoadClientCertMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping["TPT-01"] != "WS1" || mapping[fingerprint] != "WS2" {
+		t.Fatalf("mapping = %v", mapping)
+	}
+
+	if err := os.WriteFile(path, []byte(`["WS1"]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadClientCertMap(path); err == nil {
+		t.Fatal("expected an error for a malformed map")
+	}
+}
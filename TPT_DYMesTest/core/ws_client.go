@@ -0,0 +1,157 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsSendQueueSize = 256              // 每個 WebSocket 客戶端的發送佇列長度
+	wsWriteWait     = 10 * time.Second // 單次寫入的逾時時間
+	wsPongWait      = 60 * time.Second // 等待 pong 的逾時時間
+	wsPingPeriod    = 30 * time.Second // 發送 ping 的間隔（需小於 wsPongWait）
+)
+
+// wsClient 單一事件串流客戶端（各自擁有發送佇列與寫入 goroutine）
+// WebSocket 客戶端由 writePump 寫出；SSE 客戶端（conn 為 nil）由 handleEvents 寫出
+type wsClient struct {
+	conn       *websocket.Conn
+	remoteAddr string        // 客戶端位址（記錄用）
+	send       chan []byte   // 發送佇列
+	done       chan struct{} // 關閉通知
+	closeOnce  sync.Once
+	filter     *eventFilter // 訂閱條件（nil 表示接收所有事件，由 HTTPServer.wsClientsMu 保護）
+	identity   Identity     // 連線者身分（決定可使用的命令）
+}
+
+// newWSClient 建立新的客戶端（queueSize 至少為 wsSendQueueSize；SSE 客戶端的 conn 為 nil）
+func newWSClient(conn *websocket.Conn, remoteAddr string, queueSize int, filter *eventFilter) *wsClient {
+	if queueSize < wsSendQueueSize {
+		queueSize = wsSendQueueSize
+	}
+	return &wsClient{
+		conn:       conn,
+		remoteAddr: remoteAddr,
+		send:       make(chan []byte, queueSize),
+		done:       make(chan struct{}),
+		filter:     filter,
+	}
+}
+
+// enqueue 將訊息放入發送佇列，佇列已滿時回傳 false（呼叫端應踢除此客戶端）
+func (c *wsClient) enqueue(msg []byte) bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+	}
+
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// close 關閉連線（可重複呼叫）
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	})
+}
+
+// shutdown 伺服器關閉時使用：WebSocket 客戶端先送出 1001 (going away) 關閉訊框再關閉連線
+func (c *wsClient) shutdown() {
+	if c.conn != nil {
+		c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+			time.Now().Add(time.Second))
+	}
+	c.close()
+}
+
+// writePump 依序寫出佇列中的訊息，並定期發送 ping
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.close()
+	}()
+
+	for {
+		select {
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			c.conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				logWS.Debug("write failed", "remote", c.remoteAddr, "error", err)
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// eventSeqOf 取得 encodeEvent 產生的事件序號（不帶序號的訊息回傳 false）
+func eventSeqOf(msg []byte) (uint64, bool) {
+	const prefix = `{"seq":`
+	if !bytes.HasPrefix(msg, []byte(prefix)) {
+		return 0, false
+	}
+
+	var seq uint64
+	digits := 0
+	for _, b := range msg[len(prefix):] {
+		if b < '0' || b > '9' {
+			break
+		}
+		seq = seq*10 + uint64(b-'0')
+		digits++
+	}
+	return seq, digits > 0
+}
+
+// encodeEvent 將事件序列化為 JSON，並在最外層物件加入 seq 欄位
+func encodeEvent(data interface{}, seq uint64) ([]byte, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	payload = bytes.TrimSpace(payload)
+	if len(payload) < 2 || payload[0] != '{' {
+		// 非物件事件包裝成 {"seq": N, "data": ...}
+		return json.Marshal(map[string]interface{}{
+			"seq":  seq,
+			"data": json.RawMessage(payload),
+		})
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(payload) + 24)
+	buf.WriteString(fmt.Sprintf(`{"seq":%d`, seq))
+	if len(payload) > 2 {
+		buf.WriteByte(',')
+	}
+	buf.Write(payload[1:])
+	return buf.Bytes(), nil
+}
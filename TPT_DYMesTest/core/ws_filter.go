@@ -0,0 +1,237 @@
+package core
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// EventFilter 事件訂閱條件（各欄位為空表示不限制）
+//
+// types 可同時指定通訊訊息類型（STATUS、START_ACK、RAW）與結構化事件類型（channel_update、command_result），
+// directions 只套用於通訊訊息；事件沒有對應欄位時（例如 channel_update 沒有 direction）不受該條件限制
+type EventFilter struct {
+	WorkStations []string `json:"work_stations,omitempty"` // 工作站名稱
+	Channels     []string `json:"channels,omitempty"`      // 通道選擇器，例如 "CH001-CH032"、"1,3,5"
+	Types        []string `json:"types,omitempty"`         // 訊息或事件類型
+	Directions   []string `json:"directions,omitempty"`    // TPT->MES 或 MES->TPT
+}
+
+// EventFilterFromQuery 從 URL 參數解析訂閱條件（以逗號分隔多個值）
+//
+//	/ws?channels=CH001-CH004&types=STATUS,channel_update&directions=TPT->MES&work_stations=WS1
+func EventFilterFromQuery(query url.Values) EventFilter {
+	split := func(key string) []string {
+		var values []string
+		for _, v := range query[key] {
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					values = append(values, item)
+				}
+			}
+		}
+		return values
+	}
+
+	return EventFilter{
+		WorkStations: split("work_stations"),
+		Channels:     split("channels"),
+		Types:        split("types"),
+		Directions:   split("directions"),
+	}
+}
+
+// IsEmpty 檢查是否沒有任何條件
+func (f EventFilter) IsEmpty() bool {
+	return len(f.WorkStations) == 0 && len(f.Channels) == 0 && len(f.Types) == 0 && len(f.Directions) == 0
+}
+
+// eventFilter 編譯後的訂閱條件
+type eventFilter struct {
+	spec         EventFilter
+	workStations map[string]bool
+	channels     map[int]bool
+	types        map[string]bool // 小寫
+	directions   map[string]bool
+}
+
+// compileFilter 驗證並編譯訂閱條件（沒有任何條件時回傳 nil，表示接收所有事件）
+// 通道範圍不可超過 channelCount
+func compileFilter(spec EventFilter, channelCount int) (*eventFilter, error) {
+	if spec.IsEmpty() {
+		return nil, nil
+	}
+
+	f := &eventFilter{spec: spec}
+
+	if len(spec.WorkStations) > 0 {
+		f.workStations = make(map[string]bool)
+		for _, ws := range spec.WorkStations {
+			f.workStations[ws] = true
+		}
+	}
+
+	if len(spec.Channels) > 0 {
+		f.channels = make(map[int]bool)
+		for _, selector := range spec.Channels {
+			for _, term := range strings.Split(selector, ",") {
+				if err := selectChannelTerm(strings.TrimSpace(term), channelCount, f.channels); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if len(f.channels) == 0 {
+			// 包含 all，不限制通道
+			f.channels = nil
+		}
+	}
+
+	if len(spec.Types) > 0 {
+		f.types = make(map[string]bool)
+		for _, t := range spec.Types {
+			f.types[strings.ToLower(t)] = true
+		}
+	}
+
+	if len(spec.Directions) > 0 {
+		f.directions = make(map[string]bool)
+		for _, d := range spec.Directions {
+			switch strings.ToUpper(d) {
+			case "TPT->MES", "MES->TPT":
+				f.directions[strings.ToUpper(d)] = true
+			default:
+				return nil, newError(ErrInvalidRequest, nil, "invalid direction: %s", d)
+			}
+		}
+	}
+
+	return f, nil
+}
+
+// selectChannelTerm 解析訂閱條件中的通道項目（CH001、1、CH001-CH032、all）
+// 與批次命令的選擇器不同，不支援依狀態選取（狀態會隨時間改變）
+func selectChannelTerm(term string, channelCount int, selected map[int]bool) error {
+	if term == "" || strings.EqualFold(term, "all") {
+		return nil
+	}
+
+	if from, to, found := strings.Cut(term, "-"); found {
+		start, err := parseChannelNumber(from)
+		if err != nil {
+			return err
+		}
+		end, err := parseChannelNumber(to)
+		if err != nil {
+			return err
+		}
+		if start > end {
+			return newError(ErrInvalidRequest, nil, "invalid channel range: %s", term)
+		}
+		if end > channelCount {
+			return newError(ErrInvalidRequest, nil, "channel range %s exceeds channel count %d", term, channelCount)
+		}
+		for i := start; i <= end; i++ {
+			selected[i] = true
+		}
+		return nil
+	}
+
+	n, err := parseChannelNumber(term)
+	if err != nil {
+		return err
+	}
+	selected[n] = true
+	return nil
+}
+
+// eventMeta 事件中用於比對訂閱條件的欄位
+type eventMeta struct {
+	msgType     string
+	direction   string
+	workStation string
+	channels    []int
+}
+
+// eventChannelRef 事件中的通道欄位（STATUS_ALL 使用 ch，channel_update 使用 ChannelID）
+type eventChannelRef struct {
+	Ch        string `json:"ch"`
+	ChannelID string `json:"ChannelID"`
+}
+
+// parseEventMeta 從已序列化的事件取出比對用的欄位（欄位型別不符時略過該欄位）
+func parseEventMeta(msg []byte) eventMeta {
+	var event struct {
+		Type      string            `json:"type"`
+		Direction string            `json:"direction"`
+		Channel   string            `json:"channel"`
+		Channels  []eventChannelRef `json:"channels"`
+		Status    struct {
+			WorkStationName string `json:"work_station_name"`
+		} `json:"status"`
+		Data struct {
+			Type            string            `json:"type"`
+			WorkStationName string            `json:"work_station_name"`
+			Channel         string            `json:"channel"`
+			Channels        []eventChannelRef `json:"channels"`
+		} `json:"data"`
+	}
+	json.Unmarshal(msg, &event)
+
+	meta := eventMeta{
+		msgType:     event.Type,
+		direction:   event.Direction,
+		workStation: event.Status.WorkStationName,
+	}
+
+	channel, refs := event.Channel, event.Channels
+	if event.Direction != "" {
+		// 通訊訊息：以原始訊息內容為準
+		meta.msgType = event.Data.Type
+		meta.workStation = event.Data.WorkStationName
+		channel, refs = event.Data.Channel, event.Data.Channels
+	}
+
+	if channel != "" {
+		if n, err := parseChannelNumber(channel); err == nil {
+			meta.channels = append(meta.channels, n)
+		}
+	}
+	for _, ref := range refs {
+		id := ref.Ch
+		if id == "" {
+			id = ref.ChannelID
+		}
+		if n, err := parseChannelNumber(id); err == nil {
+			meta.channels = append(meta.channels, n)
+		}
+	}
+
+	return meta
+}
+
+// match 檢查事件是否符合訂閱條件（nil 表示接收所有事件）
+func (f *eventFilter) match(meta eventMeta) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.types != nil && meta.msgType != "" && !f.types[strings.ToLower(meta.msgType)] {
+		return false
+	}
+	if f.directions != nil && meta.direction != "" && !f.directions[meta.direction] {
+		return false
+	}
+	if f.workStations != nil && meta.workStation != "" && !f.workStations[meta.workStation] {
+		return false
+	}
+	if f.channels != nil && len(meta.channels) > 0 {
+		for _, n := range meta.channels {
+			if f.channels[n] {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestEventFilterFromQuery(t *testing.T) {
+	query, _ := url.ParseQuery("channels=CH001-CH004,%20CH008&channels=1&types=STATUS,,channel_update&directions=TPT->MES")
+	spec := EventFilterFromQuery(query)
+	if len(spec.Channels) != 3 || spec.Channels[1] != "CH008" || len(spec.Types) != 2 || len(spec.Directions) != 1 {
+		t.Fatalf("spec = %+v", spec)
+	}
+	if len(spec.WorkStations) != 0 || spec.IsEmpty() {
+		t.Fatalf("spec = %+v", spec)
+	}
+	if !EventFilterFromQuery(url.Values{}).IsEmpty() {
+		t.Fatal("empty query must give an empty filter")
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    EventFilter
+		wantErr bool
+	}{
+		{"channel list", EventFilter{Channels: []string{"CH001, 3"}}, false},
+		{"channel range", EventFilter{Channels: []string{"CH001-CH004"}}, false},
+		{"direction case", EventFilter{Directions: []string{"tpt->mes"}}, false},
+		{"range over count", EventFilter{Channels: []string{"CH003-CH005"}}, true},
+		{"reversed range", EventFilter{Channels: []string{"CH004-CH001"}}, true},
+		{"invalid channel", EventFilter{Channels: []string{"CHX"}}, true},
+		{"state selector", EventFilter{Channels: []string{"Alarm"}}, true},
+		{"invalid direction", EventFilter{Directions: []string{"TPT<-MES"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileFilter(tt.spec, 4)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidRequest) {
+				t.Fatalf("err = %v, want ErrInvalidRequest", err)
+			}
+		})
+	}
+
+	if f, err := compileFilter(EventFilter{}, 4); f != nil || err != nil {
+		t.Fatalf("empty spec = %v, %v; want nil", f, err)
+	}
+	if f, err := compileFilter(EventFilter{Channels: []string{"all"}}, 4); err != nil || f.channels != nil {
+		t.Fatalf("all must not restrict channels: %+v, %v", f, err)
+	}
+}
+
+func TestEventFilterMatch(t *testing.T) {
+	f, err := compileFilter(EventFilter{
+		Channels:     []string{"CH002-CH003"},
+		Types:        []string{"status", "channel_update"},
+		Directions:   []string{"TPT->MES"},
+		WorkStations: []string{"WS1"},
+	}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		event string
+		want  bool
+	}{
+		{"matching status", `{"direction":"TPT->MES","data":{"type":"STATUS","work_station_name":"WS1","channel":"CH002"}}`, true},
+		{"other channel", `{"direction":"TPT->MES","data":{"type":"STATUS","work_station_name":"WS1","channel":"CH004"}}`, false},
+		{"other direction", `{"direction":"MES->TPT","data":{"type":"STATUS","work_station_name":"WS1","channel":"CH002"}}`, false},
+		{"other work station", `{"direction":"TPT->MES","data":{"type":"STATUS","work_station_name":"WS2","channel":"CH002"}}`, false},
+		{"other type", `{"direction":"TPT->MES","data":{"type":"REPORT","work_station_name":"WS1","channel":"CH002"}}`, false},
+		{"status_all any channel", `{"direction":"TPT->MES","data":{"type":"status","work_station_name":"WS1","channels":[{"ch":"001"},{"ch":"003"}]}}`, true},
+		{"channel_update", `{"type":"channel_update","channels":[{"ChannelID":"CH003"}]}`, true},
+		{"channel_update other channel", `{"type":"channel_update","channels":[{"ChannelID":"CH001"}]}`, false},
+		{"event without fields", `{"type":"channel_update"}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.match(parseEventMeta([]byte(tt.event))); got != tt.want {
+				t.Fatalf("match(%s) = %v, want %v", tt.event, got, tt.want)
+			}
+		})
+	}
+
+	var all *eventFilter
+	if !all.match(parseEventMeta([]byte(`{"type":"anything"}`))) {
+		t.Fatal("nil filter must match every event")
+	}
+}
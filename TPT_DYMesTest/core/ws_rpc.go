@@ -0,0 +1,288 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WebSocket 命令回應類型
+const (
+	rpcResult = "rpc_result" // 命令已處理（驗證通過並已發送）
+	rpcError  = "rpc_error"  // 命令失敗
+	rpcAck    = "rpc_ack"    // 命令收到 TPT ACK
+)
+
+// rpcRecentAckSize 保留最近 ACK 的數量（處理 ACK 比等待者註冊更早抵達的情況）
+const rpcRecentAckSize = 256
+
+// wsRequest 瀏覽器經由 WebSocket 送出的命令（JSON-RPC 風格）
+//
+//	{"id": 1, "method": "start", "params": {"channel": "CH001", ...}}
+type wsRequest struct {
+	ID     json.RawMessage `json:"id,omitempty"`     // 請求 ID（原樣帶回回應）
+	Method string          `json:"method"`           // start/stop/pause/resume/rsp_status/user_command/batch/subscribe/snapshot/ping
+	Params json.RawMessage `json:"params,omitempty"` // 命令參數
+}
+
+// wsResponse 命令回應（只送給發出請求的客戶端，不佔用廣播序號）
+type wsResponse struct {
+	Type   string          `json:"type"`             // rpc_result/rpc_error/rpc_ack
+	ID     json.RawMessage `json:"id,omitempty"`     // 對應的請求 ID
+	Result interface{}     `json:"result,omitempty"` // 處理結果
+	Error  string          `json:"error,omitempty"`  // 錯誤訊息
+}
+
+// rpcAckWaiter 等待 TPT ACK 的 WebSocket 請求
+type rpcAckWaiter struct {
+	client *wsClient
+	id     json.RawMessage
+	sentAt time.Time
+}
+
+// rpcAckTracker 依 msg_id 將 TPT ACK 轉送給發出命令的客戶端（由 HTTPServer.wsClientsMu 保護）
+type rpcAckTracker struct {
+	waiters map[string]rpcAckWaiter
+	recent  map[string]CommandResult // 尚無等待者的最近 ACK
+	order   []string                 // recent 的加入順序
+}
+
+// newRPCAckTracker 建立新的 ACK 追蹤器
+func newRPCAckTracker() *rpcAckTracker {
+	return &rpcAckTracker{
+		waiters: make(map[string]rpcAckWaiter),
+		recent:  make(map[string]CommandResult),
+	}
+}
+
+// register 登記等待者；若 ACK 已先抵達則直接回傳該 ACK
+func (t *rpcAckTracker) register(msgID string, waiter rpcAckWaiter) (CommandResult, bool) {
+	if result, ok := t.recent[msgID]; ok {
+		delete(t.recent, msgID)
+		return result, true
+	}
+
+	// 清除過期未回覆的等待者
+	for id, w := range t.waiters {
+		if waiter.sentAt.Sub(w.sentAt) > pendingCommandTTL {
+			delete(t.waiters, id)
+		}
+	}
+
+	t.waiters[msgID] = waiter
+	return CommandResult{}, false
+}
+
+// resolve 取出等待此 ACK 的請求；沒有等待者時暫存 ACK
+func (t *rpcAckTracker) resolve(result CommandResult) (rpcAckWaiter, bool) {
+	if waiter, ok := t.waiters[result.MsgID]; ok {
+		delete(t.waiters, result.MsgID)
+		return waiter, true
+	}
+
+	if result.MsgID == "" {
+		return rpcAckWaiter{}, false
+	}
+	t.recent[result.MsgID] = result
+	t.order = append(t.order, result.MsgID)
+	if len(t.order) > rpcRecentAckSize {
+		delete(t.recent, t.order[0])
+		t.order = t.order[1:]
+	}
+	return rpcAckWaiter{}, false
+}
+
+// handleWSRequest 處理瀏覽器經由 WebSocket 送出的命令
+func (s *HTTPServer) handleWSRequest(client *wsClient, data []byte) {
+	var req wsRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		s.replyWS(client, wsResponse{Type: rpcError, Error: "Invalid request: " + err.Error()})
+		return
+	}
+
+	result, msgIDs, err := s.dispatchWSRequest(client, req)
+	if requiredRPCRole(req.Method) != RoleViewer {
+		s.auditWS(client, req, result, msgIDs, err)
+	}
+	if err != nil {
+		logWS.Warn("request failed", "remote", client.remoteAddr, "method", req.Method, "error", err)
+		s.replyWS(client, wsResponse{Type: rpcError, ID: req.ID, Error: err.Error(), Result: result})
+		return
+	}
+	s.replyWS(client, wsResponse{Type: rpcResult, ID: req.ID, Result: result})
+
+	// 已發送的命令在收到 TPT ACK 後再回覆一次 rpc_ack
+	if len(msgIDs) > 0 {
+		s.awaitAcks(client, req.ID, msgIDs)
+	}
+}
+
+// dispatchWSRequest 依 method 執行命令，回傳結果與需要等待 ACK 的 msg_id
+func (s *HTTPServer) dispatchWSRequest(client *wsClient, req wsRequest) (interface{}, []string, error) {
+	method := strings.ToLower(req.Method)
+
+	if required := requiredRPCRole(method); !client.identity.Role.Allows(required) {
+		return nil, nil, errForbidden(client.identity, required)
+	}
+	if requiredRPCRole(method) != RoleViewer {
+		logWS.Info("request", "method", method, "user", client.identity.String())
+	}
+
+	switch method {
+	case "ping":
+		return "pong", nil, nil
+
+	case "subscribe", "unsubscribe":
+		var spec EventFilter
+		if method == "subscribe" {
+			if err := decodeParams(req.Params, &spec); err != nil {
+				return nil, nil, err
+			}
+		}
+		filter, err := compileFilter(spec, s.stateManager.ChannelCount())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		s.wsClientsMu.Lock()
+		client.filter = filter
+		s.wsClientsMu.Unlock()
+
+		logWS.Info("subscribed", "remote", client.remoteAddr, "filter", spec)
+		return map[string]interface{}{"status": "ok", "filter": spec}, nil, nil
+
+	case "snapshot":
+		return s.currentState(), nil, nil
+
+	case "start", "stop", "pause", "resume", "rsp_status":
+		var cmd Command
+		if err := decodeParams(req.Params, &cmd); err != nil {
+			return nil, nil, err
+		}
+		cmd.Type = strings.ToUpper(method)
+		if cmd.Type != CmdRspStatus && cmd.Channel == "" {
+			return nil, nil, fmt.Errorf("Missing channel field")
+		}
+		msgID, err := s.stateManager.SendCommand(cmd)
+		if err != nil {
+			return nil, nil, err
+		}
+		return map[string]interface{}{"status": "ok", "msg_id": msgID}, []string{msgID}, nil
+
+	case "batch":
+		var batchReq BatchCommandRequest
+		if err := decodeParams(req.Params, &batchReq); err != nil {
+			return nil, nil, err
+		}
+		result, err := s.executeBatch(batchReq)
+		if err != nil {
+			return nil, nil, err
+		}
+		var msgIDs []string
+		for _, r := range result.Results {
+			if r.OK && r.MsgID != "" {
+				msgIDs = append(msgIDs, r.MsgID)
+			}
+		}
+		return result, msgIDs, nil
+
+	case "user_command":
+		var userReq UserCommandRequest
+		if err := decodeParams(req.Params, &userReq); err != nil {
+			return nil, nil, err
+		}
+		timeout, err := userReq.replyTimeout()
+		if err != nil {
+			return nil, nil, err
+		}
+		payload, err := s.buildUserPayload(userReq)
+		if err != nil {
+			return nil, nil, err
+		}
+		sent, reply, err := s.stateManager.SendUserCommandPayload(payload, timeout)
+		result := map[string]interface{}{"sent": sent}
+		if err != nil {
+			return result, nil, err
+		}
+		result["status"] = "ok"
+		if userReq.WaitReply {
+			result["reply"] = reply
+		}
+		return result, nil, nil
+
+	case "":
+		return nil, nil, fmt.Errorf("Missing method field")
+
+	default:
+		return nil, nil, fmt.Errorf("Unsupported method: %s", req.Method)
+	}
+}
+
+// decodeParams 解析請求參數（未提供參數時保留零值）
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return fmt.Errorf("Invalid params: %w", err)
+	}
+	return nil
+}
+
+// awaitAcks 登記等待 ACK 的命令；ACK 若已先抵達則立即回覆
+func (s *HTTPServer) awaitAcks(client *wsClient, id json.RawMessage, msgIDs []string) {
+	waiter := rpcAckWaiter{client: client, id: id, sentAt: time.Now()}
+
+	s.wsClientsMu.Lock()
+	defer s.wsClientsMu.Unlock()
+
+	for _, msgID := range msgIDs {
+		if result, ok := s.rpcAcks.register(msgID, waiter); ok {
+			s.sendAckLocked(waiter, result)
+		}
+	}
+}
+
+// forwardAckLocked 將 TPT ACK 轉送給發出命令的客戶端（呼叫端需持有 wsClientsMu）
+func (s *HTTPServer) forwardAckLocked(data interface{}) {
+	result, ok := data.(CommandResult)
+	if !ok || result.Status != CommandAcked {
+		return
+	}
+	if waiter, ok := s.rpcAcks.resolve(result); ok {
+		s.sendAckLocked(waiter, result)
+	}
+}
+
+// sendAckLocked 送出 rpc_ack（呼叫端需持有 wsClientsMu）
+func (s *HTTPServer) sendAckLocked(waiter rpcAckWaiter, result CommandResult) {
+	msg, err := json.Marshal(wsResponse{Type: rpcAck, ID: waiter.id, Result: result})
+	if err != nil {
+		return
+	}
+	if !s.wsClients[waiter.client] {
+		return
+	}
+	if !waiter.client.enqueue(msg) {
+		s.evictWSClientLocked(waiter.client)
+	}
+}
+
+// replyWS 將命令回應放入客戶端的發送佇列
+func (s *HTTPServer) replyWS(client *wsClient, resp wsResponse) {
+	msg, err := json.Marshal(resp)
+	if err != nil {
+		logWS.Error("failed to encode response", "error", err)
+		return
+	}
+	if client.enqueue(msg) {
+		return
+	}
+
+	s.wsClientsMu.Lock()
+	defer s.wsClientsMu.Unlock()
+	if s.wsClients[client] {
+		s.evictWSClientLocked(client)
+	}
+}
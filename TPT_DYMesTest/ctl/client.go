@@ -0,0 +1,226 @@
+package ctl
+
+import (
+	"GoTestMES/core"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// client 以 /api/v1 與執行中的伺服器溝通
+type client struct {
+	baseURL string
+	token   string
+	user    string // 使用者名稱:密碼（HTTP Basic）
+	http    *http.Client
+	stream  *http.Client // 事件串流使用，不設定逾時
+}
+
+// APIError 伺服器回傳的錯誤（對應 core.APIErrorResponse）
+type APIError struct {
+	Status int
+	core.APIError
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("%s (HTTP %d)", e.Message, e.Status)
+	}
+	return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+}
+
+func newClient(opts globalOptions) *client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &client{
+		baseURL: strings.TrimRight(opts.server, "/"),
+		token:   opts.token,
+		user:    opts.user,
+		http:    &http.Client{Transport: transport, Timeout: opts.timeout},
+		stream:  &http.Client{Transport: transport},
+	}
+}
+
+// newRequest 建立帶有認證資訊的請求
+func (c *client) newRequest(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Request, error) {
+	target := c.baseURL + core.APIPrefix + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.user != "":
+		name, password, _ := strings.Cut(c.user, ":")
+		req.SetBasicAuth(name, password)
+	}
+	return req, nil
+}
+
+// call 發送請求並回傳原始回應內容（非 2xx 時回傳 *APIError）
+func (c *client) call(ctx context.Context, method, path string, query url.Values, body interface{}) (json.RawMessage, error) {
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, decodeAPIError(resp.StatusCode, data)
+	}
+	return data, nil
+}
+
+// do 發送請求並將回應解析到 out（out 為 nil 時只回傳原始內容）
+func (c *client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) (json.RawMessage, error) {
+	data, err := c.call(ctx, method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, fmt.Errorf("unexpected response from %s: %w", path, err)
+		}
+	}
+	return data, nil
+}
+
+// decodeAPIError 解析統一格式的錯誤（舊版端點或代理伺服器回傳純文字時以內容作為訊息）
+func decodeAPIError(status int, data []byte) error {
+	apiErr := &APIError{Status: status}
+	var resp core.APIErrorResponse
+	if err := json.Unmarshal(data, &resp); err == nil && resp.Error.Message != "" {
+		apiErr.APIError = resp.Error
+		return apiErr
+	}
+	apiErr.Message = strings.TrimSpace(string(data))
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(status)
+	}
+	return apiErr
+}
+
+// streamEvents 訂閱 /api/v1/events，每收到一則事件呼叫 handle
+// 連線中斷時以最後的序號重新連線（Last-Event-ID），直到 ctx 結束或 handle 回傳錯誤
+func (c *client) streamEvents(ctx context.Context, query url.Values, since string, handle func(event json.RawMessage) error, onError func(error)) error {
+	lastID := since
+	for {
+		err := c.streamOnce(ctx, query, lastID, func(id string, event json.RawMessage) error {
+			if id != "" {
+				lastID = id
+			}
+			return handle(event)
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		if apiErr, ok := err.(*APIError); ok && apiErr.Status < 500 {
+			// 認證失敗、參數錯誤等重試也不會成功
+			return err
+		}
+		if _, ok := err.(handlerError); ok {
+			return err
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(streamRetryDelay):
+		}
+	}
+}
+
+// streamRetryDelay 事件串流中斷後重新連線的間隔
+const streamRetryDelay = 2 * time.Second
+
+// handlerError streamEvents 的 handle 回傳的錯誤（不重新連線）
+type handlerError struct{ error }
+
+// streamOnce 讀取一次 SSE 連線直到中斷
+func (c *client) streamOnce(ctx context.Context, query url.Values, lastID string, handle func(id string, event json.RawMessage) error) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "/events", query, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		return decodeAPIError(resp.StatusCode, data)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var id string
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// 空行代表一則事件結束
+			if len(data) > 0 {
+				if err := handle(id, json.RawMessage(strings.Join(data, "\n"))); err != nil {
+					return handlerError{err}
+				}
+			}
+			id, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// 註解行（心跳）
+		case strings.HasPrefix(line, "id:"):
+			id = strings.TrimSpace(strings.TrimPrefix(line, "id:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
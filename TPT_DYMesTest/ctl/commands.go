@@ -0,0 +1,416 @@
+package ctl
+
+import (
+	"GoTestMES/core"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// statusOutput status 的 -json 輸出
+type statusOutput struct {
+	Status      map[string]interface{} `json:"status"`
+	Connections []core.ClientInfo      `json:"connections"`
+}
+
+// runStatus ctl status
+func runStatus(e *env, args []string) error {
+	fs := e.newFlagSet("status", "")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var out statusOutput
+	if _, err := e.client.do(e.ctx, http.MethodGet, "/status", nil, nil, &out.Status); err != nil {
+		return err
+	}
+	if _, err := e.client.do(e.ctx, http.MethodGet, "/connections", nil, nil, &out.Connections); err != nil {
+		return err
+	}
+	if e.opts.json {
+		return writeJSONLine(e.stdout, out)
+	}
+	printStatus(e.stdout, out)
+	return nil
+}
+
+// runChannels ctl channels
+func runChannels(e *env, args []string) error {
+	fs := e.newFlagSet("channels", "[-state S] [-barcode-prefix P] [-process P] [-offset N] [-limit N]")
+	state := fs.String("state", "", "Only channels in these states (comma-separated, e.g. Running,Paused)")
+	barcodePrefix := fs.String("barcode-prefix", "", "Only channels whose barcode starts with this prefix")
+	process := fs.String("process", "", "Only channels running this process")
+	offset := fs.Int("offset", 0, "Skip this many channels")
+	limit := fs.Int("limit", 0, "Maximum number of channels (0 for all)")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	setIf(query, "state", *state)
+	setIf(query, "barcode_prefix", *barcodePrefix)
+	setIf(query, "process", *process)
+	if *offset > 0 {
+		query.Set("offset", fmt.Sprint(*offset))
+	}
+	if *limit > 0 {
+		query.Set("limit", fmt.Sprint(*limit))
+	}
+
+	var page core.ChannelPage
+	data, err := e.client.do(e.ctx, http.MethodGet, "/channels", query, nil, &page)
+	if err != nil {
+		return err
+	}
+	if e.opts.json {
+		return writeRaw(e.stdout, data)
+	}
+	printChannels(e.stdout, page)
+	return nil
+}
+
+// runChannel ctl channel <id>
+func runChannel(e *env, args []string) error {
+	fs := e.newFlagSet("channel", "<id>")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError(fs, "expected exactly one channel")
+	}
+
+	var detail core.ChannelDetail
+	data, err := e.client.do(e.ctx, http.MethodGet, "/channels/"+url.PathEscape(positional[0]), nil, nil, &detail)
+	if err != nil {
+		return err
+	}
+	if e.opts.json {
+		return writeRaw(e.stdout, data)
+	}
+	printChannelDetail(e.stdout, detail)
+	return nil
+}
+
+// runBatch ctl start|stop|pause|resume <selector...>
+func runBatch(cmdType string) func(e *env, args []string) error {
+	return func(e *env, args []string) error {
+		name := strings.ToLower(cmdType)
+		usage := "<selector...> [-interval D]"
+		if cmdType == core.CmdStart {
+			usage = "<selector...> -barcode B -process P -data-path D [-barcodes CH001=B1,CH002=B2] [-interval D]"
+		}
+		fs := e.newFlagSet(name, usage)
+		interval := fs.Duration("interval", 0, "Delay between channels (default: the server's -batch-interval)")
+		var barcode, process, dataPath, barcodes *string
+		if cmdType == core.CmdStart {
+			barcode = fs.String("barcode", "", "Barcode for all selected channels")
+			barcodes = fs.String("barcodes", "", "Per-channel barcodes, e.g. CH001=B1,CH002=B2 (overrides -barcode)")
+			process = fs.String("process", "", "Process name")
+			dataPath = fs.String("data-path", "", "Data path")
+		}
+		positional, err := parseFlags(fs, args)
+		if err != nil {
+			return err
+		}
+		if len(positional) == 0 {
+			return usageError(fs, "no channels selected")
+		}
+
+		req := core.BatchCommandRequest{Command: cmdType, Channels: joinSelectors(positional)}
+		if isSet(fs, "interval") {
+			ms := int(*interval / time.Millisecond)
+			req.IntervalMs = &ms
+		}
+		if cmdType == core.CmdStart {
+			req.Barcode, req.Process, req.DataPath = *barcode, *process, *dataPath
+			if *barcodes != "" {
+				if req.Barcodes, err = parseKeyValues(strings.Split(*barcodes, ",")); err != nil {
+					return usageError(fs, "invalid -barcodes: %v", err)
+				}
+			}
+		}
+
+		var summary core.BatchSummary
+		data, err := e.client.do(e.ctx, http.MethodPost, "/commands/batch", nil, req, &summary)
+		if err != nil {
+			return err
+		}
+		if e.opts.json {
+			if err := writeRaw(e.stdout, data); err != nil {
+				return err
+			}
+		} else {
+			printBatch(e.stdout, cmdType, summary)
+		}
+		if summary.Failed > 0 {
+			return fmt.Errorf("%d of %d channel(s) failed", summary.Failed, summary.Total)
+		}
+		return nil
+	}
+}
+
+// runUser ctl user <type> [key=value...]
+func runUser(e *env, args []string) error {
+	fs := e.newFlagSet("user", "<type> [key=value...] [-payload JSON] [-template T] [-save-as T] [-wait] [-wait-timeout D]")
+	payloadJSON := fs.String("payload", "", "JSON object merged into the command (key=value arguments take precedence)")
+	template := fs.String("template", "", "Start from a saved template")
+	saveAs := fs.String("save-as", "", "Save the resulting payload as a template")
+	wait := fs.Bool("wait", false, "Wait for the TPT reply (matched by reply_to)")
+	waitTimeout := fs.Duration("wait-timeout", 0, "How long to wait for the reply (default: server default)")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 && *template == "" {
+		return usageError(fs, "missing command type")
+	}
+
+	payload := make(map[string]interface{})
+	if *payloadJSON != "" {
+		if err := json.Unmarshal([]byte(*payloadJSON), &payload); err != nil {
+			return usageError(fs, "invalid -payload: %v", err)
+		}
+	}
+	fields := positional
+	if len(fields) > 0 && !strings.Contains(fields[0], "=") {
+		payload["type"] = fields[0]
+		fields = fields[1:]
+	}
+	values, err := parseKeyValues(fields)
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+	for k, v := range values {
+		payload[k] = v
+	}
+
+	req := core.UserCommandRequest{Template: *template, SaveAs: *saveAs, WaitReply: *wait}
+	if len(payload) > 0 {
+		req.Payload = payload
+	}
+	if *waitTimeout > 0 {
+		req.TimeoutMs = int(*waitTimeout / time.Millisecond)
+	}
+	if *wait && *waitTimeout+5*time.Second > e.client.http.Timeout {
+		// 等待回覆的時間可能超過預設的請求逾時
+		e.client.http.Timeout = *waitTimeout + 10*time.Second
+	}
+
+	var resp core.UserCommandResponse
+	data, err := e.client.do(e.ctx, http.MethodPost, "/commands/user_command", nil, req, &resp)
+	if err != nil {
+		return err
+	}
+	if e.opts.json {
+		return writeRaw(e.stdout, data)
+	}
+	printUserCommand(e.stdout, resp)
+	return nil
+}
+
+// runTail ctl tail
+func runTail(e *env, args []string) error {
+	fs := e.newFlagSet("tail", "[-channels C] [-types T] [-directions D] [-work-stations W] [-since N]")
+	query := eventFilterFlags(fs)
+	since := fs.String("since", "", "Replay events after this sequence number before following")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	printer := newEventPrinter(e.stdout, e.opts.json)
+	return e.client.streamEvents(e.ctx, query(), *since, printer.print, func(err error) {
+		fmt.Fprintf(e.stderr, "event stream interrupted: %v (reconnecting)\n", err)
+	})
+}
+
+// runExport ctl export audit|events
+func runExport(e *env, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintf(e.stderr, "Usage: %s export audit|events [options]\n", progName)
+		return errUsage
+	}
+
+	switch args[0] {
+	case "audit":
+		return exportAudit(e, args[1:])
+	case "events":
+		return exportEvents(e, args[1:])
+	default:
+		fmt.Fprintf(e.stderr, "unknown export %q (expected audit or events)\n", args[0])
+		return errUsage
+	}
+}
+
+// exportAudit ctl export audit
+func exportAudit(e *env, args []string) error {
+	fs := e.newFlagSet("export audit", "[-user U] [-channel C] [-msg-id ID] [-outcome O] [-since T] [-until T] [-limit N] [-format jsonl|csv] [-o file]")
+	user := fs.String("user", "", "Only records of this user or token name")
+	channel := fs.String("channel", "", "Only records for this channel")
+	msgID := fs.String("msg-id", "", "Only the record that sent this msg_id")
+	outcome := fs.String("outcome", "", "accepted or rejected")
+	since := fs.String("since", "", "Start time (RFC 3339)")
+	until := fs.String("until", "", "End time (RFC 3339)")
+	limit := fs.Int("limit", 1000, "Maximum number of records")
+	format, output := exportFlags(fs)
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(fs, *format); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	setIf(query, "user", *user)
+	setIf(query, "channel", *channel)
+	setIf(query, "msg_id", *msgID)
+	setIf(query, "outcome", *outcome)
+	setIf(query, "since", *since)
+	setIf(query, "until", *until)
+	if *limit > 0 {
+		query.Set("limit", fmt.Sprint(*limit))
+	}
+
+	var records []core.AuditRecord
+	if _, err := e.client.do(e.ctx, http.MethodGet, "/audit", query, nil, &records); err != nil {
+		return err
+	}
+	// 伺服器由新到舊回傳，匯出時改為時間順序
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+
+	return e.writeExport(*output, func(w io.Writer) error {
+		if *format == formatCSV {
+			return writeAuditCSV(w, records)
+		}
+		for _, record := range records {
+			if err := writeJSONLine(w, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, len(records), "audit record(s)")
+}
+
+// exportEvents ctl export events
+func exportEvents(e *env, args []string) error {
+	fs := e.newFlagSet("export events", "[-channels C] [-types T] [-directions D] [-work-stations W] [-since N] [-format jsonl|csv] [-o file]")
+	query := eventFilterFlags(fs)
+	since := fs.Uint64("since", 0, "Only events after this sequence number")
+	format, output := exportFlags(fs)
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(fs, *format); err != nil {
+		return err
+	}
+
+	values := query()
+	if *since > 0 {
+		values.Set("since", fmt.Sprint(*since))
+	}
+	var page core.EventHistoryPage
+	if _, err := e.client.do(e.ctx, http.MethodGet, "/events/history", values, nil, &page); err != nil {
+		return err
+	}
+	if *since > 0 && page.OldestSeq > *since+1 {
+		fmt.Fprintf(e.stderr, "warning: events %d-%d are no longer kept by the server\n", *since+1, page.OldestSeq-1)
+	}
+
+	return e.writeExport(*output, func(w io.Writer) error {
+		if *format == formatCSV {
+			return writeEventsCSV(w, page.Events)
+		}
+		for _, event := range page.Events {
+			if err := writeRaw(w, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, len(page.Events), "event(s)")
+}
+
+// 匯出格式
+const (
+	formatJSONL = "jsonl"
+	formatCSV   = "csv"
+)
+
+// exportFlags 匯出共用的旗標
+func exportFlags(fs *flag.FlagSet) (format, output *string) {
+	format = fs.String("format", formatJSONL, "Output format: jsonl or csv")
+	output = fs.String("o", "", "Write to this file instead of stdout")
+	return format, output
+}
+
+// checkFormat 檢查匯出格式
+func checkFormat(fs *flag.FlagSet, format string) error {
+	if format != formatJSONL && format != formatCSV {
+		return usageError(fs, "invalid -format %q (expected jsonl or csv)", format)
+	}
+	return nil
+}
+
+// eventFilterFlags 事件訂閱條件的旗標（與 /ws、/api/events 的參數相同）
+func eventFilterFlags(fs *flag.FlagSet) func() url.Values {
+	channels := fs.String("channels", "", "Channel selectors, e.g. CH001-CH032,CH040")
+	types := fs.String("types", "", "Message or event types, e.g. STATUS,START_ACK,command_result")
+	directions := fs.String("directions", "", "TPT->MES or MES->TPT")
+	workStations := fs.String("work-stations", "", "Workstation names (comma-separated)")
+	return func() url.Values {
+		query := url.Values{}
+		setIf(query, "channels", *channels)
+		setIf(query, "types", *types)
+		setIf(query, "directions", *directions)
+		setIf(query, "work_stations", *workStations)
+		return query
+	}
+}
+
+// writeExport 將匯出內容寫到檔案或標準輸出
+func (e *env) writeExport(output string, write func(w io.Writer) error, count int, what string) error {
+	if output == "" {
+		return write(e.stdout)
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stderr, "exported %d %s to %s\n", count, what, output)
+	return nil
+}
+
+// parseKeyValues 解析 key=value 參數
+func parseKeyValues(items []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, item := range items {
+		key, value, found := strings.Cut(strings.TrimSpace(item), "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", item)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// setIf 只設定非空的參數
+func setIf(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
@@ -0,0 +1,248 @@
+// Package ctl 實作 ctl 子命令（命令列工具），透過 HTTP API 操作執行中的 MES 模擬器
+//
+//	DYMesTest ctl status
+//	DYMesTest ctl channels -state Running
+//	DYMesTest ctl start CH001-CH032 -barcode BC001 -process CYCLE -data-path 'D:\data'
+//	DYMesTest ctl stop all Running
+//	DYMesTest ctl user RSP_STATUS -wait
+//	DYMesTest ctl tail -channels CH001-CH004 -types STATUS,command_result
+//	DYMesTest ctl export audit -since 2025-01-01T00:00:00Z -format csv -o audit.csv
+//
+// 伺服器位址與認證資訊可由旗標或環境變數（DYMES_SERVER、DYMES_TOKEN、DYMES_USER）指定
+package ctl
+
+import (
+	"GoTestMES/core"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// 結束碼
+const (
+	ExitOK    = 0 // 成功
+	ExitError = 1 // 請求失敗或部分通道失敗
+	ExitUsage = 2 // 參數錯誤
+)
+
+const (
+	DefaultServer  = "http://localhost:5179" // 與主程式預設的 HTTP 連接埠相同
+	DefaultTimeout = 10 * time.Second        // 單一請求的逾時（不套用於 tail）
+)
+
+// progName 使用說明中的命令名稱（例如 DYMesTest ctl）
+var progName = strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe") + " ctl"
+
+// errUsage 參數錯誤（已輸出說明）
+var errUsage = errors.New("usage error")
+
+// globalOptions 所有子命令共用的選項
+type globalOptions struct {
+	server   string
+	token    string
+	user     string
+	json     bool
+	insecure bool
+	timeout  time.Duration
+}
+
+// env 執行子命令所需的環境
+type env struct {
+	ctx    context.Context
+	client *client
+	opts   globalOptions
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// command 子命令
+type command struct {
+	name    string
+	summary string
+	run     func(e *env, args []string) error
+}
+
+// commands 子命令表（依使用說明中的順序）
+func commands() []command {
+	return []command{
+		{"status", "Show TCP/TPT connection status", runStatus},
+		{"channels", "List channels", runChannels},
+		{"channel", "Show channel details, last run and pending commands", runChannel},
+		{"start", "Start channels", runBatch(core.CmdStart)},
+		{"stop", "Stop channels", runBatch(core.CmdStop)},
+		{"pause", "Pause channels", runBatch(core.CmdPause)},
+		{"resume", "Resume channels", runBatch(core.CmdResume)},
+		{"user", "Send a user command", runUser},
+		{"tail", "Follow the live event stream", runTail},
+		{"export", "Export the audit log or recent events", runExport},
+	}
+}
+
+// Run 執行 ctl 子命令，回傳程序結束碼
+// ctx 結束時（例如 Ctrl+C）中止進行中的請求與 tail
+func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet(progName, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var opts globalOptions
+	fs.StringVar(&opts.server, "server", envOr("DYMES_SERVER", DefaultServer), "Server URL (env DYMES_SERVER)")
+	fs.StringVar(&opts.token, "token", os.Getenv("DYMES_TOKEN"), "API token (env DYMES_TOKEN)")
+	fs.StringVar(&opts.user, "user", os.Getenv("DYMES_USER"), "HTTP Basic credentials as name:password (env DYMES_USER)")
+	fs.BoolVar(&opts.json, "json", false, "Print raw JSON responses")
+	fs.BoolVar(&opts.insecure, "insecure", false, "Skip TLS certificate verification (self-signed development certificates)")
+	fs.DurationVar(&opts.timeout, "timeout", DefaultTimeout, "Timeout for each request (not applied to tail)")
+	fs.Usage = func() { printUsage(stderr, fs) }
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	name := fs.Arg(0)
+	for _, cmd := range commands() {
+		if cmd.name != name {
+			continue
+		}
+		e := &env{ctx: ctx, client: newClient(opts), opts: opts, stdout: stdout, stderr: stderr}
+		err := cmd.run(e, fs.Args()[1:])
+		switch {
+		case err == nil:
+			return ExitOK
+		case errors.Is(err, errUsage):
+			return ExitUsage
+		case errors.Is(err, flag.ErrHelp):
+			return ExitOK
+		default:
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return ExitError
+		}
+	}
+
+	fmt.Fprintf(stderr, "unknown command %q\n\n", name)
+	fs.Usage()
+	return ExitUsage
+}
+
+// printUsage 輸出使用說明
+func printUsage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: %s [options] <command> [arguments]\n", progName)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands() {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Run '%s <command> -h' for the arguments of a command.\n", progName)
+	fmt.Fprintln(w, "Selectors: CH001, 1, CH001-CH032, 1-32, all, StandBy, all Running (comma or space separated)")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Options:")
+	fs.PrintDefaults()
+}
+
+// newFlagSet 建立子命令的旗標集合
+func (e *env) newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(progName+" "+name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(e.stderr, "Usage: %s %s %s\n", progName, name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags 解析子命令參數，允許旗標與位置參數交錯（例如 start CH001 -barcode B）
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, errUsage
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// isSet 檢查旗標是否有指定
+func isSet(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+// usageError 輸出錯誤訊息與子命令的使用說明
+func usageError(fs *flag.FlagSet, format string, args ...interface{}) error {
+	fmt.Fprintf(fs.Output(), format+"\n", args...)
+	fs.Usage()
+	return errUsage
+}
+
+// envOr 取得環境變數（未設定時使用預設值）
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// joinSelectors 將位置參數組成批次命令的通道列表
+// "all" 後接狀態名稱時視為同一個選擇器（例如 all StandBy）
+func joinSelectors(args []string) []string {
+	var selectors []string
+	for i := 0; i < len(args); i++ {
+		arg := strings.TrimSpace(args[i])
+		if arg == "" {
+			continue
+		}
+		if strings.EqualFold(arg, "all") && i+1 < len(args) && isStateName(args[i+1]) {
+			selectors = append(selectors, arg+" "+args[i+1])
+			i++
+			continue
+		}
+		selectors = append(selectors, arg)
+	}
+	return selectors
+}
+
+// isStateName 判斷參數是否為狀態名稱（不是通道編號、範圍或 all）
+func isStateName(arg string) bool {
+	if arg == "" || strings.ContainsAny(arg, ",-") || strings.EqualFold(arg, "all") {
+		return false
+	}
+	if c := arg[0]; c >= '0' && c <= '9' {
+		return false
+	}
+	lower := strings.ToLower(arg)
+	if strings.HasPrefix(lower, "ch") && len(lower) > 2 && lower[2] >= '0' && lower[2] <= '9' {
+		return false
+	}
+	return true
+}
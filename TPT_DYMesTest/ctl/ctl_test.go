@@ -0,0 +1,306 @@
+package ctl
+
+import (
+	"GoTestMES/core"
+	"GoTestMES/mestest"
+	"GoTestMES/models"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	closer, _ := core.SetupLogging(core.LogConfig{Level: "error"})
+	code := m.Run()
+	if closer != nil {
+		closer.Close()
+	}
+	os.Exit(code)
+}
+
+// fakeTPT 完成 LINK 並對 START/STOP/PAUSE/RESUME 回覆 OK ACK 的 TPT
+func fakeTPT(t *testing.T, addr string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		reader := bufio.NewReader(conn)
+		for {
+			data, err := core.ReadMessage(reader)
+			if err != nil {
+				return
+			}
+			var msg map[string]interface{}
+			json.Unmarshal(data, &msg)
+			switch msg["type"] {
+			case core.CmdStart, core.CmdStop, core.CmdPause, core.CmdResume:
+				core.WriteMessage(conn, map[string]interface{}{
+					"type": msg["type"].(string) + "_ACK", "msg_id": "A", "reply_to": msg["msg_id"], "channel": msg["channel"], "ack": models.AckOK,
+				})
+			case "PING":
+				core.WriteMessage(conn, map[string]interface{}{"type": "PONG", "msg_id": "P", "reply_to": msg["msg_id"]})
+			}
+		}
+	}()
+
+	channels := make([]map[string]string, 4)
+	for i := range channels {
+		channels[i] = map[string]string{"ch": fmt.Sprintf("%03d", i+1), "state": models.StateStandBy}
+	}
+	core.WriteMessage(conn, map[string]interface{}{
+		"type": "LINK", "msg_id": "L1", "work_station_name": "WS1", "state": "Online-Auto", "channel_count": "4",
+	})
+	core.WriteMessage(conn, map[string]interface{}{
+		"type": "STATUS_ALL", "msg_id": "L2", "work_station_name": "WS1", "channels": channels,
+	})
+}
+
+// newTestServer 啟動帶 HTTP API 的模擬器並完成 LINK
+func newTestServer(t *testing.T) *mestest.Server {
+	t.Helper()
+	mes := mestest.MustNew(t, mestest.WithChannelCount(4), mestest.WithHTTPAddr("127.0.0.1:0"))
+	fakeTPT(t, mes.TCPAddr())
+	if _, err := mes.WaitForLink(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mes.ExpectStatus("CH004", models.StateStandBy); err != nil {
+		t.Fatal(err)
+	}
+	return mes
+}
+
+// run 執行 ctl 並回傳結束碼與輸出
+func run(t *testing.T, ctx context.Context, mes *mestest.Server, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := Run(ctx, append([]string{"-server", mes.URL()}, args...), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestJoinSelectors(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"CH001", "CH003"}, []string{"CH001", "CH003"}},
+		{[]string{"1-32"}, []string{"1-32"}},
+		{[]string{"all"}, []string{"all"}},
+		{[]string{"all", "StandBy"}, []string{"all StandBy"}},
+		{[]string{"all", "StandBy", "CH005"}, []string{"all StandBy", "CH005"}},
+		{[]string{"all", "CH005"}, []string{"all", "CH005"}},
+		{[]string{"all", "5-8"}, []string{"all", "5-8"}},
+		{[]string{"Running", ""}, []string{"Running"}},
+	}
+	for _, tt := range tests {
+		if got := joinSelectors(tt.args); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("joinSelectors(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := Run(context.Background(), nil, &stdout, &stderr); code != ExitUsage || !strings.Contains(stderr.String(), "Commands:") {
+		t.Fatalf("no command: code=%d stderr=%s", code, stderr.String())
+	}
+	stderr.Reset()
+	if code := Run(context.Background(), []string{"bogus"}, &stdout, &stderr); code != ExitUsage || !strings.Contains(stderr.String(), `unknown command "bogus"`) {
+		t.Fatalf("unknown command: code=%d stderr=%s", code, stderr.String())
+	}
+	if code := Run(context.Background(), []string{"stop"}, &stdout, &stderr); code != ExitUsage {
+		t.Fatalf("stop without selector: code=%d", code)
+	}
+	if code := Run(context.Background(), []string{"export", "everything"}, &stdout, &stderr); code != ExitUsage {
+		t.Fatalf("unknown export: code=%d", code)
+	}
+}
+
+func TestStatusAndChannels(t *testing.T) {
+	mes := newTestServer(t)
+	ctx := context.Background()
+
+	code, out, errOut := run(t, ctx, mes, "status")
+	if code != ExitOK || !strings.Contains(out, "work_station_name:  WS1") || !strings.Contains(out, "WORKSTATION") {
+		t.Fatalf("status: code=%d\n%s%s", code, out, errOut)
+	}
+
+	code, out, _ = run(t, ctx, mes, "channels", "-limit", "2")
+	if code != ExitOK || !strings.Contains(out, "CH002    StandBy") || strings.Contains(out, "CH003") || !strings.Contains(out, "(1-2 of 4)") {
+		t.Fatalf("channels: code=%d\n%s", code, out)
+	}
+
+	code, out, _ = run(t, ctx, mes, "-json", "channel", "3")
+	var detail core.ChannelDetail
+	if code != ExitOK || json.Unmarshal([]byte(out), &detail) != nil || detail.ChannelID != "CH003" {
+		t.Fatalf("channel -json: code=%d\n%s", code, out)
+	}
+
+	code, _, errOut = run(t, ctx, mes, "channel", "CH009")
+	if code != ExitError || !strings.Contains(errOut, "(not_found)") {
+		t.Fatalf("missing channel: code=%d stderr=%s", code, errOut)
+	}
+}
+
+func TestBatchCommands(t *testing.T) {
+	mes := newTestServer(t)
+	ctx := context.Background()
+
+	code, out, errOut := run(t, ctx, mes, "start", "CH001", "3-4", "-barcode", "BC", "-barcodes", "CH004=BC4", "-process", "P", "-data-path", `D:\data`, "-interval", "0s")
+	if code != ExitOK || !strings.Contains(out, "START: 3 sent, 0 failed") {
+		t.Fatalf("start: code=%d\n%s%s", code, out, errOut)
+	}
+	if ch, _ := mes.Channel("CH004"); ch.Barcode != "BC4" || ch.DataPath != `D:\data` {
+		t.Fatalf("CH004 = %+v", ch)
+	}
+	if ch, _ := mes.Channel("CH002"); ch.Barcode != "" {
+		t.Fatalf("CH002 must not be started: %+v", ch)
+	}
+
+	// 依狀態選取：沒有 Running 的通道
+	code, out, _ = run(t, ctx, mes, "stop", "all", "Running")
+	if code != ExitOK || !strings.Contains(out, "no channels matched") {
+		t.Fatalf("stop all Running: code=%d\n%s", code, out)
+	}
+
+	// 缺少 START 參數的通道會失敗，結束碼為 1
+	code, out, errOut = run(t, ctx, mes, "start", "2", "-interval", "0s")
+	if code != ExitError || !strings.Contains(out, "CH002  failed") || !strings.Contains(errOut, "1 of 1 channel(s) failed") {
+		t.Fatalf("start without barcode: code=%d\n%s%s", code, out, errOut)
+	}
+
+	code, out, _ = run(t, ctx, mes, "-json", "pause", "all", "-interval", "0s")
+	var summary core.BatchSummary
+	if code != ExitOK || json.Unmarshal([]byte(out), &summary) != nil || summary.Total != 4 {
+		t.Fatalf("pause -json: code=%d\n%s", code, out)
+	}
+}
+
+func TestUserCommand(t *testing.T) {
+	mes := newTestServer(t)
+	ctx := context.Background()
+
+	code, out, errOut := run(t, ctx, mes, "user", "PING", "target=CH001", "-wait", "-wait-timeout", "2s")
+	if code != ExitOK || !strings.Contains(out, `"target":"CH001"`) || !strings.Contains(out, `reply: {`) || !strings.Contains(out, `"type":"PONG"`) {
+		t.Fatalf("user: code=%d\n%s%s", code, out, errOut)
+	}
+
+	code, _, errOut = run(t, ctx, mes, "user", "PING", "novalue")
+	if code != ExitUsage || !strings.Contains(errOut, `expected key=value, got "novalue"`) {
+		t.Fatalf("bad field: code=%d stderr=%s", code, errOut)
+	}
+}
+
+// syncBuffer 可同時讀寫的緩衝區（tail 在背景寫入）
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTail(t *testing.T) {
+	mes := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var stdout, stderr syncBuffer
+	done := make(chan int)
+	go func() {
+		done <- Run(ctx, []string{"-server", mes.URL(), "tail", "-types", "initial_state,command_result", "-channels", "CH002"}, &stdout, &stderr)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(stdout.String(), "initial_state") {
+		if time.Now().After(deadline) {
+			t.Fatalf("no initial_state: %s%s", stdout.String(), stderr.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := mes.Stop("CH001"); err != nil {
+		t.Fatal(err)
+	}
+	msgID, err := mes.Stop("CH002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mes.WaitForAck(msgID); err != nil {
+		t.Fatal(err)
+	}
+	for !strings.Contains(stdout.String(), "command_result STOP CH002 acked OK") {
+		if time.Now().After(deadline) {
+			t.Fatalf("no STOP ACK in tail output: %s", stdout.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if code := <-done; code != ExitOK {
+		t.Fatalf("tail exit code = %d: %s", code, stderr.String())
+	}
+	if strings.Contains(stdout.String(), "CH001") {
+		t.Fatalf("events for CH001 must be filtered out:\n%s", stdout.String())
+	}
+}
+
+func TestExport(t *testing.T) {
+	mes := newTestServer(t)
+	ctx := context.Background()
+	if _, err := mes.Stop("CH003"); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "events.csv")
+	code, _, errOut := run(t, ctx, mes, "export", "events", "-types", "STOP", "-format", "csv", "-o", file)
+	if code != ExitOK || !strings.Contains(errOut, "exported 1 event(s)") {
+		t.Fatalf("export events: code=%d stderr=%s", code, errOut)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], ",message,MES->TPT,STOP,CH003,") {
+		t.Fatalf("unexpected CSV:\n%s", data)
+	}
+
+	code, out, _ := run(t, ctx, mes, "export", "events", "-types", "channel_update")
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if code != ExitOK || !json.Valid([]byte(line)) || !strings.Contains(line, `"channel_update"`) {
+			t.Fatalf("export events jsonl: code=%d\n%s", code, out)
+		}
+	}
+
+	// 模擬器未啟用稽核記錄
+	code, _, errOut = run(t, ctx, mes, "export", "audit")
+	if code != ExitError || !strings.Contains(errOut, "(feature_disabled)") {
+		t.Fatalf("export audit: code=%d stderr=%s", code, errOut)
+	}
+	code, _, _ = run(t, ctx, mes, "export", "audit", "-format", "xml")
+	if code != ExitUsage {
+		t.Fatalf("invalid format: code=%d", code)
+	}
+}
@@ -0,0 +1,205 @@
+package ctl
+
+import (
+	"GoTestMES/core"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// writeJSONLine 以單行 JSON 輸出
+func writeJSONLine(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeRaw(w, data)
+}
+
+// writeRaw 輸出原始 JSON 內容（補上換行）
+func writeRaw(w io.Writer, data []byte) error {
+	data = []byte(strings.TrimRight(string(data), "\n"))
+	_, err := fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// printStatus 輸出連線狀態
+func printStatus(w io.Writer, out statusOutput) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, key := range []string{"tpt_connected", "tpt_state", "work_station_name", "channel_count", "tcp_connected", "tcp_clients"} {
+		fmt.Fprintf(tw, "%s:\t%v\n", key, out.Status[key])
+	}
+	tw.Flush()
+
+	if len(out.Connections) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONNECTION\tWORKSTATION\tLINKED\tTLS\tCONNECTED")
+	for _, c := range out.Connections {
+		fmt.Fprintf(tw, "%s\t%s\t%v\t%v\t%s\n", c.ID, dash(c.WorkStation), c.Linked, c.TLS, c.ConnectedAt.Local().Format(time.DateTime))
+	}
+	tw.Flush()
+}
+
+// printChannels 輸出通道列表
+func printChannels(w io.Writer, page core.ChannelPage) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANNEL\tSTATE\tBARCODE\tPROCESS\tDATA PATH\tMESSAGE")
+	for _, ch := range page.Channels {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", ch.ChannelID, ch.State, dash(ch.Barcode), dash(ch.Process), dash(ch.DataPath), dash(ch.Message))
+	}
+	tw.Flush()
+	if len(page.Channels) < page.Total {
+		fmt.Fprintf(w, "(%d-%d of %d)\n", page.Offset+1, page.Offset+len(page.Channels), page.Total)
+	}
+}
+
+// printChannelDetail 輸出單一通道詳細資訊
+func printChannelDetail(w io.Writer, d core.ChannelDetail) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "channel:\t%s\n", d.ChannelID)
+	fmt.Fprintf(tw, "state:\t%s\n", d.State)
+	if d.PreviousState != "" {
+		fmt.Fprintf(tw, "previous state:\t%s\n", d.PreviousState)
+	}
+	if d.StateChangedAt != nil {
+		fmt.Fprintf(tw, "state changed:\t%s\n", formatTime(*d.StateChangedAt))
+	}
+	for _, field := range [][2]string{{"barcode", d.Barcode}, {"process", d.Process}, {"data path", d.DataPath}, {"message", d.Message}} {
+		if field[1] != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", field[0], field[1])
+		}
+	}
+	if d.LastMessage != nil {
+		fmt.Fprintf(tw, "last message:\t%s %s at %s\n", d.LastMessage.Direction, d.LastMessage.Type, formatTime(d.LastMessage.At))
+	}
+	printRun(tw, "current run", d.CurrentRun)
+	printRun(tw, "last run", d.LastRun)
+	for _, p := range d.PendingCommands {
+		fmt.Fprintf(tw, "pending:\t%s %s (%s)\n", p.Command, p.MsgID, time.Duration(p.AgeMs)*time.Millisecond)
+	}
+	tw.Flush()
+}
+
+// printRun 輸出一次測試的資訊
+func printRun(w io.Writer, label string, run *core.ChannelRun) {
+	if run == nil {
+		return
+	}
+	parts := []string{"barcode=" + run.Barcode, "process=" + run.Process}
+	if run.StartedAt != nil {
+		parts = append(parts, "started="+formatTime(*run.StartedAt))
+	}
+	if run.EndedAt != nil {
+		parts = append(parts, "ended="+formatTime(*run.EndedAt))
+	}
+	if run.EndState != "" {
+		parts = append(parts, "end_state="+run.EndState)
+	}
+	if run.RecordPath != "" {
+		parts = append(parts, "record="+run.RecordPath)
+	}
+	fmt.Fprintf(w, "%s:\t%s\n", label, strings.Join(parts, " "))
+}
+
+// printBatch 輸出批次命令結果
+func printBatch(w io.Writer, cmdType string, summary core.BatchSummary) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, r := range summary.Results {
+		if r.OK {
+			fmt.Fprintf(tw, "%s\tok\t%s\n", r.Channel, r.MsgID)
+		} else {
+			fmt.Fprintf(tw, "%s\tfailed\t%s\n", r.Channel, r.Error)
+		}
+	}
+	tw.Flush()
+	if summary.Total == 0 {
+		fmt.Fprintf(w, "%s: no channels matched\n", cmdType)
+		return
+	}
+	fmt.Fprintf(w, "%s: %d sent, %d failed\n", cmdType, summary.Succeeded, summary.Failed)
+}
+
+// printUserCommand 輸出自訂命令結果
+func printUserCommand(w io.Writer, resp core.UserCommandResponse) {
+	sent, _ := json.Marshal(resp.Sent)
+	fmt.Fprintf(w, "sent:  %s\n", sent)
+	if resp.Reply != nil {
+		reply, _ := json.Marshal(resp.Reply)
+		fmt.Fprintf(w, "reply: %s\n", reply)
+	}
+}
+
+// eventPrinter 輸出 tail 的事件
+type eventPrinter struct {
+	w        io.Writer
+	jsonMode bool
+	now      func() time.Time
+}
+
+func newEventPrinter(w io.Writer, jsonMode bool) *eventPrinter {
+	return &eventPrinter{w: w, jsonMode: jsonMode, now: time.Now}
+}
+
+// print 輸出一則事件（-json 時輸出原始內容，否則輸出一行摘要）
+func (p *eventPrinter) print(event json.RawMessage) error {
+	if p.jsonMode {
+		return writeRaw(p.w, event)
+	}
+	summary := core.SummarizeEvent(event)
+	_, err := fmt.Fprintf(p.w, "%s #%-6d %s\n", p.now().Format("15:04:05.000"), summary.Seq, summary.Text)
+	return err
+}
+
+// writeEventsCSV 以 CSV 匯出事件（最後一欄為完整的事件內容）
+func writeEventsCSV(w io.Writer, events []json.RawMessage) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"seq", "kind", "direction", "type", "channel", "work_station", "summary", "event"})
+	for _, event := range events {
+		s := core.SummarizeEvent(event)
+		cw.Write([]string{fmt.Sprint(s.Seq), s.Kind, s.Direction, s.Type, s.Channel, s.WorkStation, s.Text, string(event)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeAuditCSV 以 CSV 匯出稽核記錄
+func writeAuditCSV(w io.Writer, records []core.AuditRecord) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "time", "user", "role", "remote_addr", "source", "action", "channel", "outcome", "status", "error", "msg_ids", "acks", "payload"})
+	for _, r := range records {
+		acks := make([]string, 0, len(r.Acks))
+		for _, ack := range r.Acks {
+			acks = append(acks, ack.MsgID+"="+ack.Ack)
+		}
+		status := ""
+		if r.Status != 0 {
+			status = fmt.Sprint(r.Status)
+		}
+		cw.Write([]string{
+			fmt.Sprint(r.ID), r.Time.Format(time.RFC3339Nano), r.User, string(r.Role), r.RemoteAddr, r.Source, r.Action,
+			r.Channel, r.Outcome, status, r.Error, strings.Join(r.MsgIDs, " "), strings.Join(acks, " "), string(r.Payload),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatTime 以本地時間輸出
+func formatTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04:05.000")
+}
+
+// dash 空字串以 - 表示
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -2,6 +2,10 @@ package main
 
 import (
 	"GoTestMES/core"
+	"GoTestMES/ctl"
+	"GoTestMES/tui"
+	"context"
+	"crypto/tls"
 	"embed"
 	"flag"
 	"fmt"
@@ -9,6 +13,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 )
 
@@ -22,11 +27,88 @@ const (
 )
 
 func main() {
+	// ctl 子命令：透過 HTTP API 操作執行中的伺服器
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		code := ctl.Run(ctx, os.Args[2:], os.Stdout, os.Stderr)
+		stop()
+		os.Exit(code)
+	}
+
 	tcpPort := flag.Int("tcp-port", DefaultTCPPort, "TCP server port")
 	httpPort := flag.Int("http-port", DefaultHTTPPort, "HTTP server port")
 	channelCount := flag.Int("channels", DefaultChannelCount, "Number of channels")
+	batchInterval := flag.Duration("batch-interval", core.DefaultBatchInterval, "Default delay between commands in a batch")
+	scheduleFile := flag.String("schedule-file", "schedules.json", "File used to persist scheduled commands (empty to disable)")
+	rulesFile := flag.String("rules", "", "JSON file with event-triggered rules to load at startup")
+	eventHistory := flag.Int("event-history", core.DefaultEventHistorySize, "Number of broadcast events kept for WebSocket resume")
+	templateFile := flag.String("template-file", "templates.json", "File used to persist user command templates (empty to disable)")
+	authFile := flag.String("auth-file", "", "JSON file with users, API tokens and roles (empty to disable authentication)")
+	allowedOrigins := flag.String("allowed-origins", "", "Comma-separated cross-origin URLs allowed to use the API and WebSocket (same origin is always allowed)")
+	auditFile := flag.String("audit-file", "audit.jsonl", "Append-only operator audit log (empty to keep records in memory only)")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file (PEM); a self-signed development certificate is generated when TLS is enabled without one")
+	tlsKey := flag.String("tls-key", "", "TLS private key file (PEM)")
+	tcpTLS := flag.Bool("tcp-tls", false, "Enable TLS on the TPT TCP listener")
+	tcpClientCA := flag.String("tcp-client-ca", "", "CA file (PEM) for TPT client certificates; enables mutual TLS on the TCP listener")
+	tcpClientMap := flag.String("tcp-client-map", "", "JSON file mapping client certificate CN or SHA-256 fingerprint to workstation name")
+	httpsEnabled := flag.Bool("https", false, "Serve the Web UI and API over HTTPS")
+	tcpAllow := flag.String("tcp-allow", "", "Comma-separated CIDRs or IPs allowed to connect to the TPT listener (empty allows all)")
+	tcpDeny := flag.String("tcp-deny", "", "Comma-separated CIDRs or IPs refused by the TPT listener (checked before -tcp-allow)")
+	tcpMaxConns := flag.Int("tcp-max-conns", 0, "Maximum concurrent TPT connections (0 for unlimited)")
+	duplicateWS := flag.String("tcp-duplicate-workstation", core.DuplicateReplace, "Second connection with the same workstation name: allow, replace or reject")
+	requireLink := flag.String("require-link", core.LinkModeNG, "Messages before LINK or with a mismatched workstation name: ng, disconnect or off")
+	linkTimeout := flag.Duration("link-timeout", core.DefaultLinkTimeout, "Close TPT connections that do not send LINK within this time after connecting (0 to disable)")
+	logLevel := flag.String("log-level", "info", "Default log level: debug, info, warn or error")
+	logLevels := flag.String("log-levels", "", "Per-subsystem log levels, e.g. protocol=debug,ws=warn (subsystems: protocol, tcp, state, http, ws)")
+	logFormat := flag.String("log-format", core.LogFormatText, "Log output format: text or json")
+	logFile := flag.String("log-file", "", "Also write logs to this file (rotated by size)")
+	logMaxSize := flag.Int("log-max-size", 10, "Rotate the log file when it exceeds this size in MB (0 to disable)")
+	logMaxBackups := flag.Int("log-max-backups", 5, "Number of rotated log files to keep")
+	logHexDump := flag.Bool("log-hex-dump", false, "Include raw frame hex dumps in protocol debug logs")
+	shutdownTimeout := flag.Duration("shutdown-timeout", core.DefaultShutdownTimeout, "Maximum time to wait for pending ACKs and open HTTP requests on shutdown")
+	shutdownNotify := flag.String("shutdown-notify", "", "Send a user command of this type to TPT before disconnecting on shutdown (empty to disable)")
+	tuiMode := flag.Bool("tui", false, "Run an interactive terminal UI (channel grid, connection status and message log) instead of printing logs")
+	hashPassword := flag.String("hash-password", "", "Print a password_hash for the auth file and exit")
 	flag.Parse()
 
+	if *hashPassword != "" {
+		hash, err := core.HashPassword(*hashPassword)
+		if err != nil {
+			log.Fatalf("Failed to hash password: %v", err)
+		}
+		fmt.Println(hash)
+		return
+	}
+
+	levels, err := core.ParseLogLevels(*logLevels)
+	if err != nil {
+		log.Fatalf("Invalid -log-levels: %v", err)
+	}
+	// TUI 模式下日誌改為顯示在訊息記錄中（介面結束後恢復輸出到 stderr）
+	var tuiLogs *tui.LogWriter
+	if *tuiMode {
+		tuiLogs = tui.NewLogWriter(os.Stderr)
+	}
+	logConfig := core.LogConfig{
+		Level:      *logLevel,
+		Levels:     levels,
+		Format:     *logFormat,
+		File:       *logFile,
+		MaxSizeMB:  *logMaxSize,
+		MaxBackups: *logMaxBackups,
+		HexDump:    *logHexDump,
+	}
+	if tuiLogs != nil {
+		logConfig.Output = tuiLogs
+	}
+	logCloser, err := core.SetupLogging(logConfig)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	if logCloser != nil {
+		defer logCloser.Close()
+	}
+
 	printBanner()
 	log.Printf("Starting TPT MES Test Server...")
 
@@ -35,26 +117,165 @@ func main() {
 		log.Fatalf("Failed to load static files: %v", err)
 	}
 
+	if *batchInterval < 0 || *batchInterval > core.MaxBatchInterval {
+		log.Fatalf("Invalid -batch-interval: must be between 0 and %v", core.MaxBatchInterval)
+	}
+
 	stateManager := core.NewStateManager(*channelCount)
+
+	rulesEngine := core.NewRulesEngine(stateManager)
+	rulesEngine.SetBatchInterval(*batchInterval)
+	if *rulesFile != "" {
+		if err := rulesEngine.LoadFile(*rulesFile); err != nil {
+			log.Fatalf("Failed to load rules: %v", err)
+		}
+	}
+
+	var tlsConfig *tls.Config
+	if *tcpTLS || *httpsEnabled {
+		tlsConfig, err = core.LoadTLSConfig(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+	}
+
 	tcpServer := core.NewTCPServer(*tcpPort, stateManager)
-	if err := tcpServer.Start(); err != nil {
-		log.Fatalf("Failed to start TCP server: %v", err)
+	admission := core.AdmissionPolicy{MaxConnections: *tcpMaxConns}
+	if admission.Allow, err = core.ParseCIDRList(*tcpAllow); err != nil {
+		log.Fatalf("Invalid -tcp-allow: %v", err)
+	}
+	if admission.Deny, err = core.ParseCIDRList(*tcpDeny); err != nil {
+		log.Fatalf("Invalid -tcp-deny: %v", err)
+	}
+	if admission.DuplicateWorkStation, err = core.ParseDuplicatePolicy(*duplicateWS); err != nil {
+		log.Fatalf("Invalid -tcp-duplicate-workstation: %v", err)
+	}
+	tcpServer.SetAdmissionPolicy(admission)
+	linkMode, err := core.ParseLinkMode(*requireLink)
+	if err != nil {
+		log.Fatalf("Invalid -require-link: %v", err)
+	}
+	tcpServer.SetHandshakePolicy(core.HandshakePolicy{Mode: linkMode, LinkTimeout: *linkTimeout})
+	if *tcpTLS {
+		tcpTLSConfig := tlsConfig.Clone()
+		var certMap map[string]string
+		if *tcpClientCA != "" {
+			if err := core.RequireClientCerts(tcpTLSConfig, *tcpClientCA); err != nil {
+				log.Fatalf("Failed to load client CA: %v", err)
+			}
+			if *tcpClientMap != "" {
+				if certMap, err = core.LoadClientCertMap(*tcpClientMap); err != nil {
+					log.Fatalf("Failed to load client certificate map: %v", err)
+				}
+			}
+		}
+		tcpServer.SetTLSConfig(tcpTLSConfig, certMap)
 	}
 
 	httpServer := core.NewHTTPServer(*httpPort, stateManager, tcpServer, staticFS)
+	httpServer.SetBatchInterval(*batchInterval)
+	if *httpsEnabled {
+		httpServer.SetTLSConfig(tlsConfig)
+	}
+	httpServer.SetEventHistorySize(*eventHistory)
+
+	scheduler := core.NewScheduler(stateManager, *scheduleFile)
+	scheduler.SetBatchInterval(*batchInterval)
+	if err := scheduler.Load(); err != nil {
+		log.Printf("⚠ Failed to load schedules: %v", err)
+	}
+	httpServer.SetScheduler(scheduler)
+	httpServer.SetRulesEngine(rulesEngine)
+
+	templates := core.NewTemplateStore(*templateFile)
+	if err := templates.Load(); err != nil {
+		log.Printf("⚠ Failed to load templates: %v", err)
+	}
+	httpServer.SetTemplateStore(templates)
+	auditLog := core.NewAuditLog(*auditFile)
+	if err := auditLog.Open(); err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	httpServer.SetAuditLog(auditLog)
+	httpServer.SetAllowedOrigins(strings.Split(*allowedOrigins, ","))
+	if *authFile != "" {
+		auth, err := core.LoadAuthFile(*authFile)
+		if err != nil {
+			log.Fatalf("Failed to load auth file: %v", err)
+		}
+		httpServer.SetAuthenticator(auth)
+	} else {
+		log.Printf("⚠ Authentication disabled: every client can send commands (use -auth-file to enable)")
+	}
+
+	// 廣播函數必須在伺服器啟動前設定（TPT 可能在啟動後立即連線）
+	var ui *tui.UI
+	if *tuiMode {
+		// 與網頁前端使用相同的廣播資料
+		ui = tui.New(stateManager, tui.Options{Title: "DYMesTest MES", Logs: tuiLogs})
+		stateManager.SetBroadcastFunc(func(data interface{}) {
+			httpServer.BroadcastToWebSocket(data)
+			ui.Publish(data)
+		})
+	}
+
+	if err := tcpServer.Start(); err != nil {
+		log.Fatalf("Failed to start TCP server: %v", err)
+	}
 	if err := httpServer.Start(); err != nil {
 		log.Fatalf("Failed to start HTTP server: %v", err)
 	}
 
 	log.Printf("✓ Server started successfully!")
-	log.Printf("✓ Web UI: http://localhost:%d", *httpPort)
+	log.Printf("✓ Web UI: %s://localhost:%d", httpServer.Scheme(), *httpPort)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if ui != nil {
+		if err := ui.Run(ctx, os.Stdin, os.Stdout); err != nil {
+			log.Printf("⚠ %v", err)
+			<-ctx.Done()
+		}
+	} else {
+		<-ctx.Done()
+	}
+	stop() // 再次按下 Ctrl+C 時直接結束
+
+	log.Printf("Shutting down server...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	shutdown(shutdownCtx, tcpServer, httpServer, stateManager, scheduler, rulesEngine, auditLog, *shutdownNotify)
+}
+
+// shutdown 依序關閉各元件：停止自動命令 → 等待 ACK → 通知 TPT → 關閉連線 → 寫入檔案
+func shutdown(ctx context.Context, tcpServer *core.TCPServer, httpServer *core.HTTPServer, stateManager *core.StateManager,
+	scheduler *core.Scheduler, rulesEngine *core.RulesEngine, auditLog *core.AuditLog, notifyType string) {
+	// 不再產生新命令（排程與規則停止，API 命令會被拒絕）
+	scheduler.Stop()
+	rulesEngine.Stop()
+	tcpServer.Drain()
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	// 等待已發送的命令收到 ACK
+	if pending := stateManager.WaitForPendingCommands(ctx); pending > 0 {
+		log.Printf("⚠ %d command(s) still waiting for ACK", pending)
+	}
 
-	log.Printf("\nShutting down server...")
+	if notifyType != "" {
+		if err := tcpServer.NotifyShutdown(notifyType); err != nil {
+			log.Printf("⚠ Failed to notify TPT: %v", err)
+		}
+	}
 	tcpServer.Stop()
+
+	// 最後關閉 HTTP，讓前端收到 TPT 斷線事件後再關閉 WebSocket
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("⚠ HTTP server shutdown: %v", err)
+	}
+
+	if err := auditLog.Close(); err != nil {
+		log.Printf("⚠ Failed to close audit log: %v", err)
+	}
+	log.Printf("✓ Shutdown complete")
 }
 
 // printBanner 顯示啟動橫幅
@@ -0,0 +1,20 @@
+package mestest_test
+
+import (
+	"GoTestMES/core"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// 測試輸出只保留錯誤日誌
+	closer, err := core.SetupLogging(core.LogConfig{Level: "error"})
+	if err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	if closer != nil {
+		closer.Close()
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,257 @@
+// Package mestest 在 Go 測試中啟動行程內的 MES 模擬器
+//
+// 每個測試可各自建立一個 MES，監聽系統分配的連接埠，讓待測的 TPT 連線後
+// 以型別化的輔助方法驅動流程並驗證結果：
+//
+//	mes := mestest.MustNew(t)
+//	tpt := dialTPT(t, mes.TCPAddr())
+//
+//	if _, err := mes.WaitForLink(); err != nil {
+//		t.Fatal(err)
+//	}
+//	if err := mes.ExpectStatus("CH001", "StandBy"); err != nil {
+//		t.Fatal(err)
+//	}
+//	msgID, err := mes.Start("CH001", "BC123", "PROC", `D:\data`)
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	if result, err := mes.WaitForAck(msgID); err != nil || result.Ack != "OK" {
+//		t.Fatalf("START not acknowledged: %+v, %v", result, err)
+//	}
+package mestest
+
+import (
+	"GoTestMES/core"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// ErrTimeout 等待逾時（可用 errors.Is 判斷）
+var ErrTimeout = errors.New("mestest: timed out")
+
+// pollInterval 沒有事件時重新檢查條件的間隔
+const pollInterval = 50 * time.Millisecond
+
+// Server 行程內的 MES 模擬器
+type Server struct {
+	stateManager *core.StateManager
+	tcpServer    *core.TCPServer
+	httpServer   *core.HTTPServer // 未指定 WithHTTPAddr 時為 nil
+	timeout      time.Duration
+
+	mu      sync.Mutex
+	acks    map[string]core.CommandResult // 已收到的 ACK map[msg_id]結果
+	changed chan struct{}                 // 每次廣播事件時關閉並重建，喚醒等待中的呼叫端
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// New 建立並啟動 MES 模擬器（連接埠綁定失敗時回傳錯誤）
+func New(opts ...Option) (*Server, error) {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	s := &Server{
+		stateManager: core.NewStateManager(cfg.channelCount),
+		timeout:      cfg.timeout,
+		acks:         make(map[string]core.CommandResult),
+		changed:      make(chan struct{}),
+	}
+
+	s.tcpServer = core.NewTCPServer(0, s.stateManager)
+	s.tcpServer.SetListenAddr(cfg.tcpAddr)
+	s.tcpServer.SetHandshakePolicy(cfg.handshake)
+
+	if cfg.httpAddr != "" {
+		staticFS := cfg.staticFS
+		if staticFS == nil {
+			staticFS = fstest.MapFS{}
+		}
+		s.httpServer = core.NewHTTPServer(0, s.stateManager, s.tcpServer, staticFS)
+		s.httpServer.SetListenAddr(cfg.httpAddr)
+	}
+
+	// NewHTTPServer 會設定廣播函數，因此在之後包裝一層以觀察事件
+	s.stateManager.SetBroadcastFunc(s.observe)
+
+	if err := s.tcpServer.Start(); err != nil {
+		return nil, err
+	}
+	if s.httpServer != nil {
+		if err := s.httpServer.Start(); err != nil {
+			s.tcpServer.Stop()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// MustNew 建立 MES 模擬器，失敗時結束測試；測試結束時自動關閉
+func MustNew(tb testing.TB, opts ...Option) *Server {
+	tb.Helper()
+	s, err := New(opts...)
+	if err != nil {
+		tb.Fatalf("mestest: %v", err)
+	}
+	tb.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Close 關閉所有連線（可重複呼叫）
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		s.tcpServer.Drain()
+		s.tcpServer.Stop()
+		if s.httpServer != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+			defer cancel()
+			s.closeErr = s.httpServer.Shutdown(ctx)
+		}
+	})
+	return s.closeErr
+}
+
+// TCPAddr 回傳 TPT 連線的實際位址（例如 127.0.0.1:41234）
+func (s *Server) TCPAddr() string {
+	return s.tcpServer.Addr().String()
+}
+
+// URL 回傳 HTTP API 的基本網址（未啟動 HTTP 時回傳空字串）
+func (s *Server) URL() string {
+	if s.httpServer == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s://%s", s.httpServer.Scheme(), s.httpServer.Addr())
+}
+
+// StateManager 取得狀態管理器（進階用途，例如自訂命令或規則）
+func (s *Server) StateManager() *core.StateManager {
+	return s.stateManager
+}
+
+// Channel 取得通道目前的狀態
+func (s *Server) Channel(channelID string) (core.ChannelState, bool) {
+	return s.stateManager.GetChannel(channelID)
+}
+
+// WaitForLink 等待 TPT 完成 LINK，回傳工作站名稱
+func (s *Server) WaitForLink() (string, error) {
+	var workStation string
+	err := s.waitFor("LINK", func() bool {
+		status := s.stateManager.GetConnectionStatus()
+		workStation, _ = status["work_station_name"].(string)
+		connected, _ := status["tpt_connected"].(bool)
+		return connected
+	})
+	return workStation, err
+}
+
+// ExpectStatus 等待通道進入指定狀態（例如 "StandBy"、"Running"）
+func (s *Server) ExpectStatus(channelID, state string) error {
+	var current string
+	err := s.waitFor(fmt.Sprintf("%s to be %s", channelID, state), func() bool {
+		ch, ok := s.stateManager.GetChannel(channelID)
+		current = ch.State
+		return ok && ch.State == state
+	})
+	if err != nil {
+		if _, ok := s.stateManager.GetChannel(channelID); !ok {
+			return fmt.Errorf("%w (channel %s does not exist)", err, channelID)
+		}
+		return fmt.Errorf("%w (current state %q)", err, current)
+	}
+	return nil
+}
+
+// Start 發送 START 命令（經過與 Web 介面相同的狀態驗證），回傳命令的 msg_id
+func (s *Server) Start(channelID, barcode, process, dataPath string) (string, error) {
+	return s.Send(core.Command{
+		Type:     core.CmdStart,
+		Channel:  channelID,
+		Barcode:  barcode,
+		Process:  process,
+		DataPath: dataPath,
+	})
+}
+
+// Stop 發送 STOP 命令，回傳命令的 msg_id
+func (s *Server) Stop(channelID string) (string, error) {
+	return s.Send(core.Command{Type: core.CmdStop, Channel: channelID})
+}
+
+// Pause 發送 PAUSE 命令，回傳命令的 msg_id
+func (s *Server) Pause(channelID string) (string, error) {
+	return s.Send(core.Command{Type: core.CmdPause, Channel: channelID})
+}
+
+// Resume 發送 RESUME 命令，回傳命令的 msg_id
+func (s *Server) Resume(channelID string) (string, error) {
+	return s.Send(core.Command{Type: core.CmdResume, Channel: channelID})
+}
+
+// Send 發送任意支援的命令（START/STOP/PAUSE/RESUME/RSP_STATUS），回傳命令的 msg_id
+func (s *Server) Send(cmd core.Command) (string, error) {
+	return s.stateManager.SendCommand(cmd)
+}
+
+// WaitForAck 等待 TPT 回覆指定 msg_id 的 ACK（ACK 為 NG 時不視為錯誤，請檢查 result.Ack）
+func (s *Server) WaitForAck(msgID string) (core.CommandResult, error) {
+	var result core.CommandResult
+	err := s.waitFor("ACK of "+msgID, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var ok bool
+		result, ok = s.acks[msgID]
+		return ok
+	})
+	return result, err
+}
+
+// observe 轉發事件給 HTTP 伺服器並記錄 ACK（由 StateManager 持有鎖時呼叫，不可回呼 StateManager）
+func (s *Server) observe(data interface{}) {
+	if s.httpServer != nil {
+		s.httpServer.BroadcastToWebSocket(data)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if result, ok := data.(core.CommandResult); ok && result.Status == core.CommandAcked {
+		s.acks[result.MsgID] = result
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// waitFor 在逾時前等待條件成立（每次事件廣播後重新檢查）
+func (s *Server) waitFor(what string, cond func() bool) error {
+	deadline := time.NewTimer(s.timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.mu.Lock()
+		changed := s.changed
+		s.mu.Unlock()
+
+		if cond() {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ticker.C:
+		case <-deadline.C:
+			return fmt.Errorf("%w after %s waiting for %s", ErrTimeout, s.timeout, what)
+		}
+	}
+}
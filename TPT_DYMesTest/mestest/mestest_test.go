@@ -0,0 +1,105 @@
+package mestest_test
+
+import (
+	"GoTestMES/core"
+	"GoTestMES/mestest"
+	"GoTestMES/models"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeTPT 自動回覆 START 的 TPT（回覆 ACK 後回報 Running）
+func fakeTPT(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		reader := bufio.NewReader(conn)
+		for {
+			data, err := core.ReadMessage(reader)
+			if err != nil {
+				return
+			}
+			var msg map[string]interface{}
+			json.Unmarshal(data, &msg)
+			if msg["type"] != "START" {
+				continue
+			}
+			core.WriteMessage(conn, map[string]interface{}{
+				"type": "START_ACK", "msg_id": "A1", "reply_to": msg["msg_id"], "channel": msg["channel"], "ack": models.AckOK,
+			})
+			core.WriteMessage(conn, map[string]interface{}{
+				"type": "STATUS", "msg_id": "S1", "work_station_name": "WS1", "channel": msg["channel"], "state": models.StateRunning,
+			})
+		}
+	}()
+
+	core.WriteMessage(conn, map[string]interface{}{
+		"type": "LINK", "msg_id": "L1", "work_station_name": "WS1", "state": "Online-Auto", "channel_count": "4",
+	})
+	core.WriteMessage(conn, map[string]interface{}{
+		"type": "STATUS_ALL", "msg_id": "L2", "work_station_name": "WS1",
+		"channels": []map[string]string{{"ch": "001", "state": models.StateStandBy}},
+	})
+	return conn
+}
+
+func TestServer(t *testing.T) {
+	mes := mestest.MustNew(t, mestest.WithChannelCount(4), mestest.WithHTTPAddr("127.0.0.1:0"))
+	fakeTPT(t, mes.TCPAddr())
+
+	workStation, err := mes.WaitForLink()
+	if err != nil || workStation != "WS1" {
+		t.Fatalf("WaitForLink = %q, %v", workStation, err)
+	}
+	if err := mes.ExpectStatus("CH001", models.StateStandBy); err != nil {
+		t.Fatal(err)
+	}
+
+	msgID, err := mes.Start("CH001", "BC1", "PROC", `D:\data`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := mes.WaitForAck(msgID)
+	if err != nil || result.Ack != models.AckOK || result.Command != core.CmdStart {
+		t.Fatalf("WaitForAck = %+v, %v", result, err)
+	}
+	if err := mes.ExpectStatus("CH001", models.StateRunning); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(mes.URL() + "/api/v1/channels/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET channel = %d", resp.StatusCode)
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	mes := mestest.MustNew(t, mestest.WithTimeout(50*time.Millisecond))
+
+	if _, err := mes.WaitForLink(); !errors.Is(err, mestest.ErrTimeout) {
+		t.Fatalf("WaitForLink without TPT: %v", err)
+	}
+	if err := mes.ExpectStatus("CH001", models.StateStandBy); !errors.Is(err, mestest.ErrTimeout) {
+		t.Fatalf("ExpectStatus: %v", err)
+	}
+	if _, err := mes.Stop("CH001"); !errors.Is(err, core.ErrTPTOffline) {
+		t.Fatalf("Stop without TPT: %v", err)
+	}
+	if err := mes.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
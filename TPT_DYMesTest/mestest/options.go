@@ -0,0 +1,68 @@
+package mestest
+
+import (
+	"GoTestMES/core"
+	"io/fs"
+	"time"
+)
+
+const (
+	DefaultTCPAddr      = "127.0.0.1:0"   // 預設只在本機監聽，由系統分配連接埠
+	DefaultChannelCount = 128             // 與主程式相同的預設通道數量
+	DefaultTimeout      = 5 * time.Second // WaitFor*、Expect* 的預設等待時間
+)
+
+// config New 的設定（由 Option 修改）
+type config struct {
+	tcpAddr      string
+	httpAddr     string // 空字串表示不啟動 HTTP
+	staticFS     fs.FS
+	channelCount int
+	timeout      time.Duration
+	handshake    core.HandshakePolicy
+}
+
+// Option 調整 New 的設定
+type Option func(*config)
+
+// WithTCPAddr 指定 TPT 連線的監聽位址（例如 ":50200"；預設為 127.0.0.1:0）
+func WithTCPAddr(addr string) Option {
+	return func(c *config) { c.tcpAddr = addr }
+}
+
+// WithHTTPAddr 同時啟動 HTTP API 與 WebSocket（例如 "127.0.0.1:0"；預設不啟動）
+func WithHTTPAddr(addr string) Option {
+	return func(c *config) { c.httpAddr = addr }
+}
+
+// WithStaticFS 指定 Web 介面的靜態檔案（僅在 WithHTTPAddr 時使用；預設不提供 Web 介面）
+func WithStaticFS(fsys fs.FS) Option {
+	return func(c *config) { c.staticFS = fsys }
+}
+
+// WithChannelCount 指定通道數量
+func WithChannelCount(count int) Option {
+	return func(c *config) { c.channelCount = count }
+}
+
+// WithTimeout 指定 WaitForLink、ExpectStatus、WaitForAck 的等待時間
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *config) { c.timeout = timeout }
+}
+
+// WithHandshakePolicy 指定 LINK 握手規則（預設與主程式相同：LINK 之前的訊息回覆 NG）
+func WithHandshakePolicy(policy core.HandshakePolicy) Option {
+	return func(c *config) { c.handshake = policy }
+}
+
+func defaultConfig() config {
+	return config{
+		tcpAddr:      DefaultTCPAddr,
+		channelCount: DefaultChannelCount,
+		timeout:      DefaultTimeout,
+		handshake: core.HandshakePolicy{
+			Mode:        core.LinkModeNG,
+			LinkTimeout: core.DefaultLinkTimeout,
+		},
+	}
+}
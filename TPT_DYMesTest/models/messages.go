@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"sync"
+	"time"
+)
 
 // ChannelState 通道狀態常數
 const (
@@ -223,13 +227,52 @@ type ReportAckMessage struct {
 
 // GetTimestamp 取得當前時間戳記（ISO 8601 格式）
 func GetTimestamp() string {
-    // FixedZone("時區名稱", 偏移秒數) -> 8小時 * 60分 * 60秒 = 28800
-    loc := time.FixedZone("Asia/Taipei", 8*60*60)
-    
-    return time.Now().In(loc).Format("2006-01-02T15:04:05+08:00")
+	return FormatTimestamp(time.Now())
+}
+
+// FormatTimestamp 將指定時間格式化為協定的時間戳記格式 (UTC+8)
+func FormatTimestamp(t time.Time) string {
+	// FixedZone("時區名稱", 偏移秒數) -> 8小時 * 60分 * 60秒 = 28800
+	loc := time.FixedZone("Asia/Taipei", 8*60*60)
+
+	return t.In(loc).Format("2006-01-02T15:04:05+08:00")
 }
 
-// GenerateMsgID 產生訊息 ID (16 碼 HEX)
+// 訊息 ID 中的序號：4 碼 16 進位（維持 msg_id 為 16 碼 HEX 字串），每秒最多 65536 個
+const msgIDSeqMax = 0x10000
+
+var (
+	msgIDMu     sync.Mutex
+	msgIDSecond int64 // 目前序號所屬的秒數（Unix 時間）
+	msgIDSeq    int64 // 該秒已使用的序號數量
+)
+
+// GenerateMsgID 產生訊息 ID (16 碼 HEX：12 碼時間 yyMMddHHmmss + 4 碼 16 進位序號)
+// 序號每秒重新計算，同一秒內用完時改用下一秒，因此程序內產生的 ID 不會重複
 func GenerateMsgID() string {
-	return time.Now().Format("20060102150405") + "01" // 簡化版本，實際可用 UUID
+	msgIDMu.Lock()
+	defer msgIDMu.Unlock()
+
+	sec := time.Now().Unix()
+	if sec < msgIDSecond {
+		// 系統時間倒退時沿用最後使用的秒數
+		sec = msgIDSecond
+	}
+	if sec != msgIDSecond {
+		msgIDSecond, msgIDSeq = sec, 0
+	}
+	if msgIDSeq >= msgIDSeqMax {
+		// 這一秒的序號已用完：等到下一秒再繼續
+		time.Sleep(time.Until(time.Unix(sec+1, 0)))
+		msgIDSecond, msgIDSeq = sec+1, 0
+	}
+
+	id := FormatMsgID(time.Unix(msgIDSecond, 0), msgIDSeq)
+	msgIDSeq++
+	return id
+}
+
+// FormatMsgID 以指定時間與序號組出訊息 ID（序號取 65536 的餘數）
+func FormatMsgID(t time.Time, seq int64) string {
+	return fmt.Sprintf("%s%04X", t.Format("060102150405"), seq%msgIDSeqMax)
 }
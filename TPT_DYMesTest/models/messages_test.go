@@ -0,0 +1,62 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGenerateMsgIDUnique(t *testing.T) {
+	// 超過舊版每秒 100 個的上限，確認同一秒內不會重複
+	seen := make(map[string]bool)
+	for i := 0; i < 5000; i++ {
+		id := GenerateMsgID()
+		if !isHexMsgID(id) {
+			t.Fatalf("msg_id %q is not a 16-character HEX string", id)
+		}
+		if seen[id] {
+			t.Fatalf("duplicate msg_id %s after %d IDs", id, i)
+		}
+		seen[id] = true
+	}
+}
+
+// isHexMsgID 檢查是否為 16 碼大寫 HEX 字串
+func isHexMsgID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFormatMsgID(t *testing.T) {
+	ts := time.Date(2025, 3, 9, 8, 7, 6, 0, time.Local)
+	for seq, want := range map[int64]string{
+		0:       "2503090807060000",
+		10:      "250309080706000A",
+		0xFFFF:  "250309080706FFFF",
+		0x10001: "2503090807060001",
+	} {
+		if got := FormatMsgID(ts, seq); got != want || !isHexMsgID(got) {
+			t.Fatalf("FormatMsgID(%d) = %s, want %s", seq, got, want)
+		}
+	}
+}
+
+func TestGenerateMsgIDNextSecond(t *testing.T) {
+	msgIDMu.Lock()
+	sec := time.Now().Unix()
+	msgIDSecond, msgIDSeq = sec, msgIDSeqMax // 這一秒的序號已用完
+	msgIDMu.Unlock()
+
+	id := GenerateMsgID()
+	msgIDMu.Lock()
+	defer msgIDMu.Unlock()
+	if msgIDSecond <= sec || id[12:] != "0000" {
+		t.Fatalf("id = %s, second = %d (was %d)", id, msgIDSecond, sec)
+	}
+}
@@ -2,6 +2,21 @@
 let ws = null;
 let reconnectTimer = null;
 
+// 最後收到的事件序號（用於偵測遺漏的事件）
+let lastSeq = null;
+let resyncing = false;
+
+// WebSocket 命令請求（id -> { resolve, timer }）
+let rpcNextId = 1;
+const rpcPending = new Map();
+const RPC_TIMEOUT_MS = 30000;
+
+// 通訊 Log 訂閱條件（由伺服器端篩選，null 表示接收所有事件）
+let logFilter = null;
+
+// 設定訂閱條件時仍需接收的狀態事件（維持通道表與連線狀態即時更新）
+const STATE_EVENT_TYPES = ['channel_update', 'connection_update', 'command_result', 'rule_fired'];
+
 // 狀態資料
 let channels = [];
 let connectionStatus = {
@@ -14,14 +29,25 @@ let connectionStatus = {
 document.addEventListener('DOMContentLoaded', function() {
     initWebSocket();
     initEventListeners();
+    loadWhoAmI();
     initChannelSelect();
     loadChannels();
+    loadTemplates();
+    loadConnections();
 });
 
 // 初始化 WebSocket
 function initWebSocket() {
     const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-    const wsUrl = `${protocol}//${window.location.host}/ws`;
+    // 重連時帶上最後收到的序號，由伺服器補送中斷期間的事件
+    // 訂閱條件也一併帶入，補送的事件同樣會經過篩選
+    const params = new URLSearchParams();
+    if (lastSeq !== null) params.set('since', lastSeq);
+    if (logFilter) {
+        Object.entries(logFilter).forEach(([key, values]) => params.set(key, values.join(',')));
+    }
+    const query = params.toString() ? `?${params}` : '';
+    const wsUrl = `${protocol}//${window.location.host}/ws${query}`;
     
     ws = new WebSocket(wsUrl);
     
@@ -52,6 +78,13 @@ function initWebSocket() {
         console.log('WebSocket 連線關閉');
         addLog('系統', 'WebSocket 連線關閉，3秒後重連...', 'warning');
         
+        // 尚未回應的命令視為失敗（命令可能已送出，結果以 command_result 為準）
+        rpcPending.forEach(pending => {
+            clearTimeout(pending.timer);
+            pending.resolve({ ok: false, result: { error: 'WebSocket 連線中斷' } });
+        });
+        rpcPending.clear();
+        
         // 3秒後重連
         reconnectTimer = setTimeout(function() {
             initWebSocket();
@@ -59,27 +92,224 @@ function initWebSocket() {
     };
 }
 
+// 檢查事件序號，發現缺口時重新取得快照
+function checkSequence(data) {
+    if (typeof data.seq !== 'number') return true;
+    
+    if (data.type === 'initial_state') {
+        lastSeq = data.seq;
+        return true;
+    }
+    
+    // 已包含在快照中的舊事件
+    if (lastSeq !== null && data.seq <= lastSeq) return false;
+    
+    // 設有訂閱條件時，被篩選掉的事件會造成序號不連續，不視為遺漏
+    if (!logFilter && lastSeq !== null && data.seq > lastSeq + 1) {
+        addLog('系統', `偵測到遺漏 ${data.seq - lastSeq - 1} 則事件，重新同步狀態...`, 'warning');
+        resyncSnapshot();
+    }
+    lastSeq = data.seq;
+    return true;
+}
+
+// 重新取得狀態快照
+async function resyncSnapshot() {
+    if (resyncing) return;
+    resyncing = true;
+    try {
+        const response = await fetch('/api/snapshot');
+        if (response.ok) {
+            const snapshot = await response.json();
+            if (lastSeq === null || snapshot.seq >= lastSeq) {
+                handleWebSocketMessage(snapshot);
+            } else {
+                connectionStatus = snapshot.status;
+                channels = snapshot.channels || [];
+                updateConnectionStatus();
+                updateChannelTable();
+            }
+        }
+    } catch (e) {
+        console.error('重新同步失敗:', e);
+    } finally {
+        resyncing = false;
+    }
+}
+
+// 經由 WebSocket 發送命令，回傳 { ok, result }
+function wsCall(method, params) {
+    return new Promise(resolve => {
+        const id = rpcNextId++;
+        const timer = setTimeout(() => {
+            rpcPending.delete(id);
+            resolve({ ok: false, result: { error: '等待回應逾時' } });
+        }, RPC_TIMEOUT_MS);
+        rpcPending.set(id, { resolve, timer });
+        ws.send(JSON.stringify({ id, method, params }));
+    });
+}
+
+// 處理命令回應（rpc_ack 的結果已由 command_result 事件顯示）
+function handleRpcResponse(data) {
+    const pending = rpcPending.get(data.id);
+    if (!pending || data.type === 'rpc_ack') return;
+    
+    rpcPending.delete(data.id);
+    clearTimeout(pending.timer);
+    if (data.type === 'rpc_result') {
+        pending.resolve({ ok: true, result: data.result });
+    } else {
+        pending.resolve({ ok: false, result: Object.assign({}, data.result, { error: data.error }) });
+    }
+}
+
+// 發送命令：WebSocket 已連線時經由 WebSocket，否則改用 HTTP API
+async function sendCommand(method, params) {
+    if (ws && ws.readyState === WebSocket.OPEN) {
+        return wsCall(method, params);
+    }
+    
+    const response = await fetch(`/api/cmd/${method}`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: params ? JSON.stringify(params) : undefined
+    });
+    const result = await response.json();
+    return { ok: response.ok, result };
+}
+
+// 各角色可使用的按鈕（viewer 只能查看）
+const ROLE_BUTTONS = {
+    operator: ['btn-start', 'btn-stop', 'btn-pause', 'btn-resume', 'btn-rsp-status', 'btn-batch', 'btn-user-command'],
+    admin: ['btn-raw-send', 'btn-fuzz']
+};
+
+// 取得目前使用者，停用角色權限不足的按鈕
+async function loadWhoAmI() {
+    try {
+        const response = await fetch('/api/whoami');
+        const me = await response.json();
+        document.getElementById('current-user').textContent =
+            me.auth_enabled ? `${me.name || '匿名'} (${me.role})` : '未啟用驗證';
+
+        const allowed = { viewer: [], operator: ['operator'], admin: ['operator', 'admin'] }[me.role] || [];
+        for (const [role, ids] of Object.entries(ROLE_BUTTONS)) {
+            for (const id of ids) {
+                const btn = document.getElementById(id);
+                if (btn && !allowed.includes(role)) {
+                    btn.disabled = true;
+                    btn.title = `需要 ${role} 權限`;
+                }
+            }
+        }
+    } catch (error) {
+        console.error('Failed to load identity:', error);
+    }
+}
+
 // 處理 WebSocket 訊息
 function handleWebSocketMessage(data) {
+    if (data.type === 'rpc_result' || data.type === 'rpc_error' || data.type === 'rpc_ack') {
+        handleRpcResponse(data);
+        return;
+    }
+    
+    if (data.type === 'gap_too_large') {
+        addLog('系統', `中斷期間遺漏的事件過多（自 #${data.since} 起），改為重新載入狀態`, 'warning');
+        return;
+    }
+    
+    if (!checkSequence(data)) return;
+    
     if (data.type === 'initial_state') {
         // 初始狀態
         connectionStatus = data.status;
         channels = data.channels || [];
         updateConnectionStatus();
         updateChannelTable();
+    } else if (data.type === 'channel_update') {
+        // 通道狀態變更（以伺服器的狀態為準）
+        applyChannelUpdate(data.channels || []);
+    } else if (data.type === 'connection_update') {
+        // 連線狀態變更
+        connectionStatus = data.status;
+        updateConnectionStatus();
+    } else if (data.type === 'command_result') {
+        // 命令結果
+        logCommandResult(data);
+    } else if (data.type === 'rule_fired') {
+        // 規則觸發
+        addLog('規則', `${data.name || data.rule_id}: ${data.trigger} ${data.channel || ''} → ${data.action}`, 'warning');
     } else if (data.direction) {
         // 通訊 Log
         const direction = data.direction;
         const msgData = data.data;
-        const msgType = msgData.type || 'Unknown';
         
         addLog(direction, JSON.stringify(msgData, null, 2), 
                direction === 'TPT->MES' ? 'receive' : 'send');
-        
-        // 如果是狀態更新，重新載入通道列表
-        if (msgType.includes('STATUS') || msgType.includes('REPORT')) {
-            setTimeout(loadChannels, 500);
+    }
+}
+
+// 套用通道狀態變更
+function applyChannelUpdate(updates) {
+    updates.forEach(update => {
+        const index = channels.findIndex(ch => ch.ChannelID === update.ChannelID);
+        if (index >= 0) {
+            channels[index] = update;
+        } else {
+            channels.push(update);
         }
+    });
+    updateChannelTable();
+}
+
+// 套用通訊 Log 訂閱條件
+async function applyLogFilter() {
+    const split = value => value.split(',').map(v => v.trim()).filter(v => v);
+    const channels = split(document.getElementById('log-filter-channels').value);
+    const types = split(document.getElementById('log-filter-types').value);
+    const direction = document.getElementById('log-filter-direction').value;
+    
+    const filter = {};
+    if (channels.length > 0) filter.channels = channels;
+    if (types.length > 0) filter.types = types.concat(STATE_EVENT_TYPES);
+    if (direction) filter.directions = [direction];
+    
+    const previous = logFilter;
+    logFilter = Object.keys(filter).length > 0 ? filter : null;
+    
+    if (!ws || ws.readyState !== WebSocket.OPEN) {
+        addLog('系統', '訂閱條件將於 WebSocket 重新連線後套用', 'warning');
+        return;
+    }
+    
+    const { ok, result } = await wsCall(logFilter ? 'subscribe' : 'unsubscribe', logFilter);
+    if (!ok) {
+        logFilter = previous;
+        addLog('錯誤', '套用訂閱條件失敗: ' + result.error, 'error');
+        return;
+    }
+    
+    if (logFilter) {
+        addLog('系統', `已套用訂閱條件: ${JSON.stringify(logFilter)}`, 'info');
+    } else {
+        addLog('系統', '已取消訂閱條件', 'info');
+        // 篩選期間可能錯過其他通道的狀態變更
+        if (previous) resyncSnapshot();
+    }
+}
+
+// 顯示命令結果
+function logCommandResult(result) {
+    const target = result.channel ? ` ${result.channel}` : '';
+    if (result.status === 'acked') {
+        const latency = result.latency_ms ? ` (${result.latency_ms} ms)` : '';
+        const message = result.message ? `: ${result.message}` : '';
+        addLog('ACK', `${result.command}${target} → ${result.ack}${latency}${message}`,
+               result.ack === 'OK' ? 'success' : 'error');
+    } else if (result.status === 'failed') {
+        addLog('錯誤', `${result.command}${target} 失敗: ${result.message}`, 'error');
     }
 }
 
@@ -103,14 +333,80 @@ function initEventListeners() {
     // 自訂命令按鈕
     document.getElementById('btn-user-command').addEventListener('click', sendUserCommand);
     
+    // 範本選擇
+    document.getElementById('template-select').addEventListener('change', applyTemplate);
+    
+    // 原始封包注入
+    document.getElementById('btn-raw-send').addEventListener('click', sendRawFrame);
+    document.getElementById('btn-fuzz').addEventListener('click', sendFuzz);
+    document.getElementById('raw-connection-select').addEventListener('focus', loadConnections);
+    
+    // 批次命令按鈕
+    document.getElementById('btn-batch').addEventListener('click', sendBatchCommand);
+    
     // 清除 Log 按鈕
     document.getElementById('btn-clear-log').addEventListener('click', clearLog);
     
+    // Log 訂閱條件
+    document.getElementById('btn-log-filter').addEventListener('click', applyLogFilter);
+    
     // 篩選器
     document.getElementById('filter-running').addEventListener('change', updateChannelTable);
     document.getElementById('filter-standby').addEventListener('change', updateChannelTable);
     document.getElementById('filter-alarm').addEventListener('change', updateChannelTable);
     document.getElementById('filter-offline').addEventListener('change', updateChannelTable);
+    
+    // 分頁切換與稽核查詢
+    document.querySelectorAll('.tab').forEach(tab => tab.addEventListener('click', () => switchTab(tab.dataset.tab)));
+    document.getElementById('btn-audit-refresh').addEventListener('click', loadAudit);
+}
+
+// 切換右側分頁
+function switchTab(tabId) {
+    document.querySelectorAll('.tab').forEach(tab => tab.classList.toggle('active', tab.dataset.tab === tabId));
+    document.querySelectorAll('.tab-content').forEach(panel => { panel.hidden = panel.id !== tabId; });
+    if (tabId === 'tab-audit') {
+        loadAudit();
+    }
+}
+
+// 載入操作稽核記錄
+async function loadAudit() {
+    const params = new URLSearchParams({ limit: '200' });
+    const user = document.getElementById('audit-filter-user').value.trim();
+    const channel = document.getElementById('audit-filter-channel').value.trim();
+    const outcome = document.getElementById('audit-filter-outcome').value;
+    if (user) params.set('user', user);
+    if (channel) params.set('channel', channel);
+    if (outcome) params.set('outcome', outcome);
+
+    const tbody = document.getElementById('audit-tbody');
+    try {
+        const response = await fetch(`/api/audit?${params}`);
+        const records = await response.json();
+        if (!response.ok) {
+            tbody.innerHTML = `<tr><td colspan="7">${escapeHtml(records.error ? records.error.message : '查詢失敗')}</td></tr>`;
+            return;
+        }
+
+        tbody.innerHTML = records.map(rec => {
+            const acks = (rec.acks || []).map(a => `${a.channel || a.msg_id} ${a.ack}`).join(', ');
+            const outcome = rec.outcome === 'rejected'
+                ? `<span class="audit-rejected" title="${escapeHtml(rec.error || '')}">rejected</span>`
+                : 'accepted';
+            return `<tr title="${escapeHtml(rec.payload ? JSON.stringify(rec.payload) : '')}">
+                <td>${new Date(rec.time).toLocaleString()}</td>
+                <td>${escapeHtml(rec.user || '-')} (${rec.role})</td>
+                <td>${escapeHtml(rec.remote_addr)}</td>
+                <td>${escapeHtml(rec.action)}</td>
+                <td>${escapeHtml(rec.channel || '-')}</td>
+                <td>${outcome}</td>
+                <td>${escapeHtml(acks || (rec.msg_ids ? '等待中' : '-'))}</td>
+            </tr>`;
+        }).join('');
+    } catch (error) {
+        console.error('Failed to load audit log:', error);
+    }
 }
 
 // 初始化通道選擇下拉選單
@@ -267,15 +563,9 @@ async function sendStartCommand() {
     }
     
     try {
-        const response = await fetch('/api/cmd/start', {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ channel, barcode, process, data_path: dataPath })
-        });
-        
-        const result = await response.json();
+        const { ok, result } = await sendCommand('start', { channel, barcode, process, data_path: dataPath });
         
-        if (response.ok) {
+        if (ok) {
             addLog('命令', `START 命令已發送至 ${channel}`, 'success');
         } else {
             addLog('錯誤', result.error || '發送失敗', 'error');
@@ -297,15 +587,9 @@ async function sendStopCommand() {
     }
     
     try {
-        const response = await fetch('/api/cmd/stop', {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ channel })
-        });
-        
-        const result = await response.json();
+        const { ok, result } = await sendCommand('stop', { channel });
         
-        if (response.ok) {
+        if (ok) {
             addLog('命令', `STOP 命令已發送至 ${channel}`, 'success');
         } else {
             addLog('錯誤', result.error || '發送失敗', 'error');
@@ -327,15 +611,9 @@ async function sendPauseCommand() {
     }
     
     try {
-        const response = await fetch('/api/cmd/pause', {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ channel })
-        });
-        
-        const result = await response.json();
+        const { ok, result } = await sendCommand('pause', { channel });
         
-        if (response.ok) {
+        if (ok) {
             addLog('命令', `PAUSE 命令已發送至 ${channel}`, 'success');
         } else {
             addLog('錯誤', result.error || '發送失敗', 'error');
@@ -357,15 +635,9 @@ async function sendResumeCommand() {
     }
     
     try {
-        const response = await fetch('/api/cmd/resume', {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ channel })
-        });
+        const { ok, result } = await sendCommand('resume', { channel });
         
-        const result = await response.json();
-        
-        if (response.ok) {
+        if (ok) {
             addLog('命令', `RESUME 命令已發送至 ${channel}`, 'success');
         } else {
             addLog('錯誤', result.error || '發送失敗', 'error');
@@ -380,14 +652,9 @@ async function sendResumeCommand() {
 // 發送 RSP_STATUS 命令
 async function sendRspStatusCommand() {
     try {
-        const response = await fetch('/api/cmd/rsp_status', {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' }
-        });
+        const { ok, result } = await sendCommand('rsp_status', null);
         
-        const result = await response.json();
-        
-        if (response.ok) {
+        if (ok) {
             addLog('命令', 'RSP_STATUS 命令已發送', 'success');
         } else {
             addLog('錯誤', result.error || '發送失敗', 'error');
@@ -399,26 +666,92 @@ async function sendRspStatusCommand() {
     }
 }
 
+// 已儲存的自訂命令範本
+let templates = [];
+
+// 載入自訂命令範本
+async function loadTemplates() {
+    try {
+        const response = await fetch('/api/templates');
+        if (!response.ok) return;
+        templates = await response.json();
+    } catch (e) {
+        console.error('載入範本失敗:', e);
+        return;
+    }
+    
+    const select = document.getElementById('template-select');
+    const current = select.value;
+    select.innerHTML = '<option value="">-- 不使用範本 --</option>';
+    templates.forEach(t => {
+        const option = document.createElement('option');
+        option.value = t.name;
+        option.textContent = t.name;
+        select.appendChild(option);
+    });
+    select.value = current;
+}
+
+// 套用範本內容到輸入欄位
+function applyTemplate() {
+    const name = document.getElementById('template-select').value;
+    const t = templates.find(t => t.name === name);
+    if (!t) return;
+    
+    const fields = Object.assign({}, t.payload);
+    document.getElementById('user-command-input').value = fields.type || '';
+    delete fields.type;
+    document.getElementById('user-payload-input').value =
+        Object.keys(fields).length > 0 ? JSON.stringify(fields, null, 2) : '';
+}
+
 // 發送自訂命令
 async function sendUserCommand() {
     const commandType = document.getElementById('user-command-input').value.trim();
+    const payloadText = document.getElementById('user-payload-input').value.trim();
+    const saveAs = document.getElementById('template-name-input').value.trim();
+    const waitReply = document.getElementById('wait-reply-checkbox').checked;
     
     if (!commandType) {
         alert('請輸入命令類型');
         return;
     }
     
+    let payload = {};
+    if (payloadText) {
+        try {
+            payload = JSON.parse(payloadText);
+        } catch (e) {
+            alert('附加欄位不是合法的 JSON: ' + e.message);
+            return;
+        }
+    }
+    payload.type = commandType;
+    
+    const body = { payload, wait_reply: waitReply };
+    if (saveAs) {
+        body.save_as = saveAs;
+    }
+    
     try {
         const response = await fetch('/api/cmd/user_command', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ type: commandType })
+            body: JSON.stringify(body)
         });
         
-        const result = await response.json();
+        const text = await response.text();
+        let result = {};
+        try { result = JSON.parse(text); } catch (e) { result = { error: text }; }
         
         if (response.ok) {
-            addLog('命令', `自訂命令已發送 (type: ${commandType})`, 'success');
+            addLog('命令', `自訂命令已發送 (type: ${commandType}, msg_id: ${result.sent.msg_id})`, 'success');
+            if (waitReply) {
+                addLog('回覆', JSON.stringify(result.reply, null, 2), 'receive');
+            }
+            if (saveAs) {
+                loadTemplates();
+            }
         } else {
             addLog('錯誤', result.error || '發送失敗', 'error');
             alert('錯誤: ' + (result.error || '發送失敗'));
@@ -429,6 +762,150 @@ async function sendUserCommand() {
     }
 }
 
+// 發送批次命令
+async function sendBatchCommand() {
+    const selector = document.getElementById('batch-selector-input').value.trim();
+    const command = document.getElementById('batch-command-select').value;
+    const intervalMs = parseInt(document.getElementById('batch-interval-input').value, 10);
+    
+    if (!selector) {
+        alert('請輸入通道選擇器');
+        return;
+    }
+    
+    const body = { command, selector };
+    if (!isNaN(intervalMs)) {
+        body.interval_ms = intervalMs;
+    }
+    
+    if (command === 'START') {
+        body.barcode = document.getElementById('barcode-input').value.trim();
+        body.process = document.getElementById('process-input').value.trim();
+        body.data_path = document.getElementById('datapath-input').value.trim();
+        if (!body.barcode || !body.process || !body.data_path) {
+            alert('請填寫所有必要欄位（條碼、製程、資料路徑）');
+            return;
+        }
+    }
+    
+    try {
+        const response = await fetch('/api/cmd/batch', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body)
+        });
+        
+        if (!response.ok) {
+            const text = await response.text();
+            let message = text;
+            try { message = JSON.parse(text).error || text; } catch (e) {}
+            addLog('錯誤', message || '發送失敗', 'error');
+            alert('錯誤: ' + (message || '發送失敗'));
+            return;
+        }
+        
+        const result = await response.json();
+        addLog('命令', `${command} 批次命令完成: 成功 ${result.succeeded} / 失敗 ${result.failed}`,
+               result.failed > 0 ? 'warning' : 'success');
+        result.results.filter(r => !r.ok).forEach(r => {
+            addLog('錯誤', `${r.channel}: ${r.error}`, 'error');
+        });
+    } catch (e) {
+        console.error('發送批次命令失敗:', e);
+        addLog('錯誤', '發送批次命令失敗: ' + e.message, 'error');
+    }
+}
+
+// 載入 TPT 連線列表
+async function loadConnections() {
+    try {
+        const response = await fetch('/api/connections');
+        if (!response.ok) return;
+        const connections = await response.json();
+        
+        const select = document.getElementById('raw-connection-select');
+        const current = select.value;
+        select.innerHTML = '<option value="">所有連線</option>';
+        connections.forEach(c => {
+            const option = document.createElement('option');
+            option.value = c.id;
+            option.textContent = c.id;
+            select.appendChild(option);
+        });
+        select.value = current;
+    } catch (e) {
+        console.error('載入連線列表失敗:', e);
+    }
+}
+
+// 發送原始封包
+async function sendRawFrame() {
+    const data = document.getElementById('raw-data-input').value;
+    const body = {
+        connection: document.getElementById('raw-connection-select').value,
+        encoding: document.getElementById('raw-encoding-select').value,
+        data: data,
+        terminator: document.getElementById('raw-terminator-checkbox').checked,
+        chunk_size: parseInt(document.getElementById('raw-chunk-input').value, 10) || 0,
+        chunk_delay_ms: parseInt(document.getElementById('raw-chunk-delay-input').value, 10) || 0
+    };
+    
+    try {
+        const response = await fetch('/api/raw', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body)
+        });
+        
+        const text = await response.text();
+        let result = {};
+        try { result = JSON.parse(text); } catch (e) { result = { error: text }; }
+        
+        if (response.ok) {
+            addLog('注入', `已發送 ${result.bytes} bytes 原始封包`, 'warning');
+        } else {
+            addLog('錯誤', result.error || '發送失敗', 'error');
+        }
+    } catch (e) {
+        console.error('發送原始封包失敗:', e);
+        addLog('錯誤', '發送原始封包失敗: ' + e.message, 'error');
+    }
+}
+
+// 發送 Fuzz 封包
+async function sendFuzz() {
+    const body = {
+        connection: document.getElementById('raw-connection-select').value,
+        template: document.getElementById('fuzz-template-select').value,
+        count: parseInt(document.getElementById('fuzz-count-input').value, 10) || 10
+    };
+    
+    addLog('注入', `開始 Fuzz 測試 (${body.template} x ${body.count})...`, 'warning');
+    
+    try {
+        const response = await fetch('/api/raw/fuzz', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body)
+        });
+        
+        const text = await response.text();
+        let result = {};
+        try { result = JSON.parse(text); } catch (e) { result = { error: text }; }
+        
+        const frames = result.frames || [];
+        const summary = frames.map(f => f.mutation).join(', ');
+        if (response.ok) {
+            addLog('注入', `Fuzz 完成 (seed: ${result.seed}): ${summary}`, 'warning');
+        } else {
+            addLog('錯誤', `Fuzz 中斷 (seed: ${result.seed || '-'}): ${result.error || '發送失敗'}`, 'error');
+        }
+    } catch (e) {
+        console.error('Fuzz 測試失敗:', e);
+        addLog('錯誤', 'Fuzz 測試失敗: ' + e.message, 'error');
+    }
+}
+
 // 新增 Log
 function addLog(source, message, type = 'info') {
     const logConsole = document.getElementById('log-console');
@@ -465,6 +942,3 @@ function escapeHtml(text) {
     return div.innerHTML;
 }
 
-// 定期更新通道狀態（每 5 秒）
-setInterval(loadChannels, 5000);
-
@@ -0,0 +1,92 @@
+package tui
+
+import "unicode/utf8"
+
+// keyCode 按鍵種類
+type keyCode int
+
+const (
+	keyRune keyCode = iota // 一般字元（見 key.r）
+	keyEnter
+	keyEsc
+	keyBackspace
+	keyTab
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyPgUp
+	keyPgDn
+	keyCtrlC
+	keyCtrlL
+)
+
+// key 一次按鍵
+type key struct {
+	code keyCode
+	r    rune
+}
+
+// escapeSequences VT 控制序列（ESC 之後的部分）
+var escapeSequences = map[string]keyCode{
+	"[A": keyUp, "[B": keyDown, "[C": keyRight, "[D": keyLeft,
+	"OA": keyUp, "OB": keyDown, "OC": keyRight, "OD": keyLeft,
+	"[H": keyHome, "[F": keyEnd, "OH": keyHome, "OF": keyEnd,
+	"[1~": keyHome, "[4~": keyEnd, "[7~": keyHome, "[8~": keyEnd,
+	"[5~": keyPgUp, "[6~": keyPgDn,
+}
+
+// parseKeys 將一次讀取的輸入轉為按鍵
+// 單獨的 ESC 視為 Esc 鍵；無法辨識的控制序列整段略過
+func parseKeys(data []byte) []key {
+	var keys []key
+	for len(data) > 0 {
+		b := data[0]
+		switch {
+		case b == 0x1b:
+			if len(data) == 1 {
+				return append(keys, key{code: keyEsc})
+			}
+			if data[1] != '[' && data[1] != 'O' {
+				// Alt+字元或連續的 ESC
+				keys = append(keys, key{code: keyEsc})
+				data = data[1:]
+				continue
+			}
+			// 控制序列以 0x40-0x7E 的字元結束
+			end := 2
+			for end < len(data) && (data[end] < 0x40 || data[end] > 0x7e) {
+				end++
+			}
+			if end < len(data) {
+				end++
+			}
+			if code, ok := escapeSequences[string(data[1:end])]; ok {
+				keys = append(keys, key{code: code})
+			}
+			data = data[end:]
+			continue
+		case b == '\r' || b == '\n':
+			keys = append(keys, key{code: keyEnter})
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, key{code: keyBackspace})
+		case b == '\t':
+			keys = append(keys, key{code: keyTab})
+		case b == 0x03:
+			keys = append(keys, key{code: keyCtrlC})
+		case b == 0x0c:
+			keys = append(keys, key{code: keyCtrlL})
+		case b < 0x20:
+			// 其他控制字元
+		default:
+			r, size := utf8.DecodeRune(data)
+			keys = append(keys, key{code: keyRune, r: r})
+			data = data[size:]
+			continue
+		}
+		data = data[1:]
+	}
+	return keys
+}
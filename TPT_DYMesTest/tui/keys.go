@@ -0,0 +1,179 @@
+package tui
+
+import "GoTestMES/core"
+
+// keyHelp 說明畫面的按鍵列表（依顯示順序）
+var keyHelp = [][2]string{
+	{"←↑→↓ / hjkl", "Select channel"},
+	{"g / G", "First / last channel"},
+	{"s", "START selected channel (prompts barcode, process, data path)"},
+	{"x", "STOP selected channel"},
+	{"p", "PAUSE selected channel"},
+	{"r", "RESUME selected channel"},
+	{"R", "RSP_STATUS (request all channel states)"},
+	{"u", "Send a user command: TYPE key=value ... ({channel} = selected)"},
+	{"c", "Show only messages of the selected channel / all messages"},
+	{"PgUp / PgDn", "Scroll the message log (End follows new messages)"},
+	{"Ctrl+L", "Redraw"},
+	{"?", "Show / hide this help"},
+	{"q / Ctrl+C", "Quit"},
+}
+
+// handleKey 處理一次按鍵
+func (u *UI) handleKey(k key) {
+	if k.code == keyCtrlC {
+		u.quit = true
+		return
+	}
+	if u.prompt != nil {
+		u.handlePromptKey(k)
+		return
+	}
+	if u.showHelp {
+		// 說明畫面按任意鍵關閉
+		u.showHelp = false
+		return
+	}
+
+	switch k.code {
+	case keyLeft:
+		u.moveSelection(-1)
+	case keyRight:
+		u.moveSelection(1)
+	case keyUp:
+		u.moveSelection(-u.gridColumns())
+	case keyDown:
+		u.moveSelection(u.gridColumns())
+	case keyHome:
+		u.selected = 0
+	case keyPgUp:
+		u.scrollLog(u.logHeight() - 1)
+	case keyPgDn:
+		u.scrollLog(-(u.logHeight() - 1))
+	case keyEnd:
+		u.logScroll = 0
+	case keyCtrlL:
+		// 下一次繪製本來就是完整畫面
+	case keyEsc:
+		u.notice = ""
+	case keyRune:
+		u.handleRune(k.r)
+	}
+}
+
+// handleRune 處理一般字元按鍵
+func (u *UI) handleRune(r rune) {
+	switch r {
+	case 'q':
+		u.quit = true
+		return
+	case '?':
+		u.showHelp = true
+		return
+	case 'h':
+		u.moveSelection(-1)
+		return
+	case 'l':
+		u.moveSelection(1)
+		return
+	case 'k':
+		u.moveSelection(-u.gridColumns())
+		return
+	case 'j':
+		u.moveSelection(u.gridColumns())
+		return
+	case 'g':
+		u.selected = 0
+		return
+	case 'G':
+		u.selected = len(u.channels) - 1
+		u.clampSelection()
+		return
+	case 'c':
+		u.logFilter = !u.logFilter
+		u.logScroll = 0
+		return
+	case 'R':
+		u.sendCommand(core.Command{Type: core.CmdRspStatus})
+		return
+	}
+
+	ch, ok := u.selectedChannel()
+	if !ok {
+		return
+	}
+	switch r {
+	case 's':
+		u.startPrompt(ch)
+	case 'x':
+		u.sendCommand(core.Command{Type: core.CmdStop, Channel: ch.ChannelID})
+	case 'p':
+		u.sendCommand(core.Command{Type: core.CmdPause, Channel: ch.ChannelID})
+	case 'r':
+		u.sendCommand(core.Command{Type: core.CmdResume, Channel: ch.ChannelID})
+	case 'u':
+		u.userPrompt(ch)
+	}
+}
+
+// handlePromptKey 處理輸入列的按鍵（Enter 進入下一個欄位，Esc 取消）
+func (u *UI) handlePromptKey(k key) {
+	p := u.prompt
+	switch k.code {
+	case keyEsc:
+		u.prompt = nil
+		u.setNotice(false, "cancelled")
+	case keyBackspace:
+		if len(p.input) > 0 {
+			p.input = p.input[:len(p.input)-1]
+		}
+	case keyRune:
+		p.input = append(p.input, k.r)
+	case keyEnter:
+		p.values[p.step] = string(p.input)
+		p.step++
+		if p.step < len(p.labels) {
+			p.input = []rune(p.values[p.step])
+			return
+		}
+		u.prompt = nil
+		p.submit(p.values)
+	}
+}
+
+// moveSelection 移動選取的通道
+func (u *UI) moveSelection(delta int) {
+	next := u.selected + delta
+	if next < 0 || next >= len(u.channels) {
+		return
+	}
+	u.selected = next
+	if u.logFilter {
+		u.logScroll = 0
+	}
+}
+
+// scrollLog 捲動訊息記錄（正數往舊的訊息）
+func (u *UI) scrollLog(lines int) {
+	u.logScroll += lines
+	if max := u.visibleLogCount() - u.logHeight(); u.logScroll > max {
+		u.logScroll = max
+	}
+	if u.logScroll < 0 {
+		u.logScroll = 0
+	}
+}
+
+// visibleLogCount 符合篩選條件的訊息數量
+func (u *UI) visibleLogCount() int {
+	if !u.logFilter {
+		return len(u.log)
+	}
+	n := 0
+	for _, entry := range u.log {
+		if u.logVisible(entry) {
+			n++
+		}
+	}
+	return n
+}
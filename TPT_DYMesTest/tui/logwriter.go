@@ -0,0 +1,57 @@
+package tui
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// LogWriter 伺服器日誌的輸出
+// TUI 執行期間將每一行交給訊息記錄顯示（避免破壞畫面），其餘時間直接寫到下層的輸出
+type LogWriter struct {
+	mu      sync.Mutex
+	out     io.Writer
+	capture func(line string) // nil 表示直接輸出
+	partial []byte            // 尚未收到換行的內容
+}
+
+// NewLogWriter 建立日誌輸出（TUI 未執行時寫到 out，例如 os.Stderr）
+func NewLogWriter(out io.Writer) *LogWriter {
+	return &LogWriter{out: out}
+}
+
+// Write 實作 io.Writer
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.capture == nil {
+		return w.out.Write(p)
+	}
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(bytes.TrimRight(w.partial[:i], "\r"))
+		w.partial = w.partial[i+1:]
+		if line != "" {
+			w.capture(line)
+		}
+	}
+	return len(p), nil
+}
+
+// redirect 開始（fn 不為 nil）或停止收集日誌
+// capture 在持有鎖時呼叫，不可再寫入日誌
+func (w *LogWriter) redirect(fn func(line string)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if fn == nil && len(w.partial) > 0 {
+		w.out.Write(append(w.partial, '\n'))
+	}
+	w.partial = nil
+	w.capture = fn
+}
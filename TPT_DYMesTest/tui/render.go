@@ -0,0 +1,375 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	cellWidth  = 16 // 通道格寬度：選取標記 + 通道編號 + 狀態 + 選取標記
+	cellGap    = 1
+	stateWidth = 8
+)
+
+// ANSI SGR 樣式
+const (
+	sgrReset   = "\x1b[0m"
+	sgrBold    = "\x1b[1m"
+	sgrDim     = "\x1b[2m"
+	sgrInverse = "\x1b[7m"
+	sgrRed     = "\x1b[31m"
+	sgrGreen   = "\x1b[32m"
+	sgrYellow  = "\x1b[33m"
+	sgrCyan    = "\x1b[36m"
+	sgrMagenta = "\x1b[35m"
+)
+
+// stateShortNames 狀態在通道格中的簡稱（最多 stateWidth 字元）
+var stateShortNames = map[string]string{
+	"StartFailed":      "StartNG",
+	"ChangeStepFailed": "StepNG",
+	"ResumeFailed":     "ResumeNG",
+	"ReversePolarity":  "RevPol",
+}
+
+// stateStyle 狀態顏色（與網頁前端 getStateClass 的對應相同，以名稱包含的關鍵字判斷）
+func stateStyle(state string) string {
+	s := strings.ToLower(state)
+	switch {
+	case strings.Contains(s, "running"):
+		return "\x1b[30;42m" // 綠
+	case strings.Contains(s, "standby"):
+		return "\x1b[97;100m" // 灰
+	case strings.Contains(s, "paused"):
+		return "\x1b[30;43m" // 橘
+	case strings.Contains(s, "alarm"):
+		return "\x1b[97;41m" // 紅
+	case strings.Contains(s, "finish"):
+		return "\x1b[97;44m" // 藍
+	case strings.Contains(s, "offline"):
+		return "\x1b[37;40m" // 深灰
+	default:
+		return "\x1b[30;47m" // 淺灰
+	}
+}
+
+// gridColumns 通道格每列的數量
+func (u *UI) gridColumns() int {
+	cols := (u.width + cellGap) / (cellWidth + cellGap)
+	if cols < 1 {
+		return 1
+	}
+	return cols
+}
+
+// gridHeight 通道格顯示的列數（最多約為畫面高度的一半，其餘留給訊息記錄）
+func (u *UI) gridHeight() int {
+	cols := u.gridColumns()
+	rows := (len(u.channels) + cols - 1) / cols
+	limit := (u.height - 4) / 2
+	if limit < 1 {
+		limit = 1
+	}
+	if rows > limit {
+		return limit
+	}
+	if rows < 1 {
+		return 1
+	}
+	return rows
+}
+
+// logHeight 訊息記錄顯示的行數
+// 固定列：標題、選取通道、訊息記錄標題、底部提示
+func (u *UI) logHeight() int {
+	h := u.height - 4 - u.gridHeight()
+	if h < 1 {
+		return 1
+	}
+	return h
+}
+
+// render 產生完整畫面（每一列以游標定位後輸出，並清除該列剩餘的內容）
+func (u *UI) render() string {
+	var lines []string
+	lines = append(lines, u.renderHeader())
+	if u.showHelp {
+		lines = append(lines, u.renderHelp(u.height-2)...)
+	} else {
+		lines = append(lines, u.renderGrid()...)
+		lines = append(lines, u.renderDetail())
+		lines = append(lines, u.renderLog()...)
+	}
+	for len(lines) < u.height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines[:u.height-1], u.renderFooter())
+
+	var b strings.Builder
+	for i, line := range lines {
+		fmt.Fprintf(&b, "\x1b[%d;1H%s%s\x1b[K", i+1, line, sgrReset)
+	}
+	return b.String()
+}
+
+// renderHeader 標題列：TCP/TPT 連線狀態、工作站、通道數量與時間
+func (u *UI) renderHeader() string {
+	tcp := sgrRed + "TCP disconnected"
+	if connected, _ := u.status["tcp_connected"].(bool); connected {
+		tcp = fmt.Sprintf("%sTCP connected (%v)", sgrGreen, u.status["tcp_clients"])
+	}
+	tpt := sgrRed + "TPT offline"
+	if linked, _ := u.status["tpt_connected"].(bool); linked {
+		tpt = sgrGreen + "TPT linked"
+		if state, _ := u.status["tpt_state"].(string); state != "" {
+			tpt += " " + state
+		}
+	}
+	ws, _ := u.status["work_station_name"].(string)
+
+	left := fmt.Sprintf("%s%s%s  %s%s  %s%s  %s (%d ch)",
+		sgrBold, u.opts.Title, sgrReset, tcp, sgrReset, tpt, sgrReset, orDash(ws), len(u.channels))
+	clock := u.now().Format("15:04:05")
+	return padRight(left, u.width-len(clock)-1) + " " + clock
+}
+
+// renderGrid 通道格（捲動到包含選取通道的位置）
+func (u *UI) renderGrid() []string {
+	cols := u.gridColumns()
+	height := u.gridHeight()
+	first := 0
+	if row := u.selected / cols; row >= height {
+		first = row - height + 1
+	}
+
+	lines := make([]string, 0, height)
+	for row := first; row < first+height; row++ {
+		var b strings.Builder
+		for col := 0; col < cols; col++ {
+			i := row*cols + col
+			if i >= len(u.channels) {
+				break
+			}
+			if col > 0 {
+				b.WriteString(strings.Repeat(" ", cellGap))
+			}
+			b.WriteString(u.renderCell(i))
+		}
+		lines = append(lines, b.String())
+	}
+	return lines
+}
+
+// renderCell 單一通道格
+func (u *UI) renderCell(i int) string {
+	ch := u.channels[i]
+	state := ch.State
+	if short, ok := stateShortNames[state]; ok {
+		state = short
+	}
+	left, right := " ", " "
+	style := stateStyle(ch.State)
+	if i == u.selected {
+		left, right = ">", "<"
+		style += sgrBold
+	}
+	text := left + padRight(ch.ChannelID, 5) + " " + padRight(state, stateWidth) + right
+	return style + truncate(text, cellWidth) + sgrReset
+}
+
+// renderDetail 選取通道的詳細資訊
+func (u *UI) renderDetail() string {
+	ch, ok := u.selectedChannel()
+	if !ok {
+		return sgrDim + "no channels"
+	}
+	parts := []string{sgrBold + ch.ChannelID + sgrReset, stateStyle(ch.State) + " " + ch.State + " " + sgrReset}
+	for _, field := range [][2]string{{"barcode", ch.Barcode}, {"process", ch.Process}, {"data", ch.DataPath}, {"message", ch.Message}} {
+		if field[1] != "" {
+			parts = append(parts, field[0]+"="+field[1])
+		}
+	}
+	if detail, ok := u.sm.GetChannelDetail(ch.ChannelID); ok {
+		if n := len(detail.PendingCommands); n > 0 {
+			parts = append(parts, fmt.Sprintf("%spending=%d%s", sgrYellow, n, sgrReset))
+		}
+		if m := detail.LastMessage; m != nil {
+			parts = append(parts, fmt.Sprintf("%slast: %s %s %s%s", sgrDim, m.Direction, m.Type, m.At.Local().Format("15:04:05"), sgrReset))
+		}
+	}
+	return truncate(strings.Join(parts, " "), u.width)
+}
+
+// renderLog 訊息記錄（標題列與最新的訊息）
+func (u *UI) renderLog() []string {
+	height := u.logHeight()
+	var entries []logEntry
+	for _, entry := range u.log {
+		if u.logVisible(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	end := len(entries) - u.logScroll
+	if end < 0 {
+		end = 0
+	}
+	start := end - height
+	if start < 0 {
+		start = 0
+	}
+
+	title := "Messages (all)"
+	if u.logFilter {
+		ch, _ := u.selectedChannel()
+		title = fmt.Sprintf("Messages (%s)", ch.ChannelID)
+	}
+	if u.logScroll > 0 {
+		title += fmt.Sprintf(", %d newer, End to follow", u.logScroll)
+	}
+	rule := "── " + title + " "
+	if n := u.width - displayWidth(rule); n > 0 {
+		rule += strings.Repeat("─", n)
+	}
+	lines := []string{sgrDim + rule}
+
+	for _, entry := range entries[start:end] {
+		style := ""
+		switch {
+		case entry.kind == "log":
+			style = sgrDim
+		case strings.HasPrefix(entry.text, "TPT->MES"):
+			style = sgrCyan
+		case strings.HasPrefix(entry.text, "MES->TPT"):
+			style = sgrMagenta
+		case strings.Contains(entry.text, " failed"):
+			style = sgrRed
+		}
+		lines = append(lines, style+truncate(entry.at.Format("15:04:05.000")+" "+entry.text, u.width))
+	}
+	return lines
+}
+
+// renderHelp 按鍵說明
+func (u *UI) renderHelp(height int) []string {
+	lines := []string{"", sgrBold + "Keys" + sgrReset}
+	for _, h := range keyHelp {
+		lines = append(lines, truncate(fmt.Sprintf("  %-14s %s", h[0], h[1]), u.width))
+	}
+	lines = append(lines, "", sgrDim+"Press any key to close")
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	return lines
+}
+
+// renderFooter 底部列：輸入列、提示訊息或按鍵提示
+func (u *UI) renderFooter() string {
+	if p := u.prompt; p != nil {
+		label := p.labels[p.step]
+		if len(p.labels) > 1 {
+			label = fmt.Sprintf("%s (%d/%d)", label, p.step+1, len(p.labels))
+		}
+		// 輸入內容過長時只顯示尾端
+		text := string(p.input)
+		room := u.width - displayWidth(label) - 3
+		for displayWidth(text) > room && text != "" {
+			_, size := utf8.DecodeRuneInString(text)
+			text = text[size:]
+		}
+		return sgrBold + label + ": " + sgrReset + text + sgrInverse + " "
+	}
+	if u.notice != "" {
+		style := sgrGreen
+		if u.noticeErr {
+			style = sgrRed
+		}
+		return style + truncate(u.notice, u.width)
+	}
+	return sgrDim + truncate("s start  x stop  p pause  r resume  R status  u user  c filter  PgUp/PgDn scroll  ? help  q quit", u.width)
+}
+
+// runeWidth 字元在終端機中佔用的寬度（東亞全形字元為 2）
+func runeWidth(r rune) int {
+	if r >= 0x1100 && (r <= 0x115f || r == 0x2329 || r == 0x232a ||
+		(r >= 0x2e80 && r <= 0xa4cf && r != 0x303f) ||
+		(r >= 0xac00 && r <= 0xd7a3) ||
+		(r >= 0xf900 && r <= 0xfaff) ||
+		(r >= 0xfe30 && r <= 0xfe4f) ||
+		(r >= 0xff00 && r <= 0xff60) ||
+		(r >= 0xffe0 && r <= 0xffe6) ||
+		(r >= 0x1f300 && r <= 0x1faff) ||
+		(r >= 0x20000 && r <= 0x3fffd)) {
+		return 2
+	}
+	return 1
+}
+
+// displayWidth 字串的顯示寬度（略過 ANSI 控制序列）
+func displayWidth(s string) int {
+	w := 0
+	inEscape := false
+	for _, r := range s {
+		switch {
+		case inEscape:
+			if r >= 0x40 && r <= 0x7e && r != '[' {
+				inEscape = false
+			}
+		case r == 0x1b:
+			inEscape = true
+		case r < 0x20:
+		default:
+			w += runeWidth(r)
+		}
+	}
+	return w
+}
+
+// truncate 截斷到指定的顯示寬度（保留 ANSI 控制序列，控制字元以空白取代）
+func truncate(s string, width int) string {
+	var b strings.Builder
+	w := 0
+	inEscape, full := false, false
+	for _, r := range s {
+		switch {
+		case inEscape:
+			b.WriteRune(r)
+			if r >= 0x40 && r <= 0x7e && r != '[' {
+				inEscape = false
+			}
+			continue
+		case r == 0x1b:
+			inEscape = true
+			b.WriteRune(r)
+			continue
+		case r < 0x20:
+			r = ' '
+		}
+		rw := runeWidth(r)
+		if full || w+rw > width {
+			full = true
+			continue
+		}
+		w += rw
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// padRight 截斷或以空白補齊到指定的顯示寬度
+func padRight(s string, width int) string {
+	s = truncate(s, width)
+	if pad := width - displayWidth(s); pad > 0 {
+		s += strings.Repeat(" ", pad)
+	}
+	return s
+}
+
+// orDash 空字串以 - 表示
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
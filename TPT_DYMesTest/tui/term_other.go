@@ -0,0 +1,28 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !windows
+
+package tui
+
+import (
+	"errors"
+	"os"
+)
+
+var errUnsupported = errors.New("terminal UI is not supported on this platform")
+
+type terminalState struct{}
+
+func makeRaw(in *os.File) (*terminalState, error) {
+	return nil, errUnsupported
+}
+
+func restore(in *os.File, state *terminalState) error {
+	return nil
+}
+
+func terminalSize(out *os.File) (width, height int, err error) {
+	return 0, 0, errUnsupported
+}
+
+func enableVirtualTerminal(out *os.File) (func(), error) {
+	return nil, errUnsupported
+}
@@ -0,0 +1,64 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package tui
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// terminalState 進入 raw mode 前的終端機設定
+type terminalState struct {
+	termios syscall.Termios
+}
+
+// makeRaw 將終端機切換為 raw mode（不回顯、逐字元讀取），回傳原本的設定
+func makeRaw(in *os.File) (*terminalState, error) {
+	fd := in.Fd()
+	var old syscall.Termios
+	if err := ioctl(fd, ioctlGetTermios, unsafe.Pointer(&old)); err != nil {
+		return nil, err
+	}
+
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, ioctlSetTermios, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return &terminalState{termios: old}, nil
+}
+
+// restore 還原終端機設定
+func restore(in *os.File, state *terminalState) error {
+	return ioctl(in.Fd(), ioctlSetTermios, unsafe.Pointer(&state.termios))
+}
+
+// terminalSize 取得終端機的欄數與列數
+func terminalSize(out *os.File) (width, height int, err error) {
+	var ws struct {
+		Row, Col, Xpixel, Ypixel uint16
+	}
+	if err := ioctl(out.Fd(), syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
+
+// enableVirtualTerminal Unix 終端機本來就支援 ANSI 控制碼
+func enableVirtualTerminal(out *os.File) (func(), error) {
+	return func() {}, nil
+}
+
+func ioctl(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
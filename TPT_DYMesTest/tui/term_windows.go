@@ -0,0 +1,84 @@
+package tui
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Windows 主控台模式旗標
+const (
+	enableProcessedInput            = 0x0001
+	enableLineInput                 = 0x0002
+	enableEchoInput                 = 0x0004
+	enableVirtualTerminalInput      = 0x0200
+	enableProcessedOutput           = 0x0001
+	enableVirtualTerminalProcessing = 0x0004
+)
+
+var (
+	kernel32                       = syscall.NewLazyDLL("kernel32.dll")
+	procSetConsoleMode             = kernel32.NewProc("SetConsoleMode")
+	procGetConsoleScreenBufferInfo = kernel32.NewProc("GetConsoleScreenBufferInfo")
+)
+
+// terminalState 進入 raw mode 前的主控台模式
+type terminalState struct {
+	mode uint32
+}
+
+// makeRaw 關閉行緩衝與回顯，並以 VT 控制碼回報方向鍵等按鍵
+func makeRaw(in *os.File) (*terminalState, error) {
+	handle := syscall.Handle(in.Fd())
+	var old uint32
+	if err := syscall.GetConsoleMode(handle, &old); err != nil {
+		return nil, err
+	}
+	raw := old&^(enableProcessedInput|enableLineInput|enableEchoInput) | enableVirtualTerminalInput
+	if err := setConsoleMode(handle, raw); err != nil {
+		return nil, err
+	}
+	return &terminalState{mode: old}, nil
+}
+
+// restore 還原主控台模式
+func restore(in *os.File, state *terminalState) error {
+	return setConsoleMode(syscall.Handle(in.Fd()), state.mode)
+}
+
+// enableVirtualTerminal 讓主控台解譯 ANSI 控制碼，回傳還原函式
+func enableVirtualTerminal(out *os.File) (func(), error) {
+	handle := syscall.Handle(out.Fd())
+	var old uint32
+	if err := syscall.GetConsoleMode(handle, &old); err != nil {
+		return nil, err
+	}
+	if err := setConsoleMode(handle, old|enableProcessedOutput|enableVirtualTerminalProcessing); err != nil {
+		return nil, err
+	}
+	return func() { setConsoleMode(handle, old) }, nil
+}
+
+// terminalSize 取得主控台視窗的欄數與列數
+func terminalSize(out *os.File) (width, height int, err error) {
+	var info struct {
+		sizeX, sizeY                   int16
+		cursorX, cursorY               int16
+		attributes                     uint16
+		left, top, right, bottom       int16
+		maximumWindowX, maximumWindowY int16
+	}
+	r, _, e := procGetConsoleScreenBufferInfo.Call(out.Fd(), uintptr(unsafe.Pointer(&info)))
+	if r == 0 {
+		return 0, 0, e
+	}
+	return int(info.right-info.left) + 1, int(info.bottom-info.top) + 1, nil
+}
+
+func setConsoleMode(handle syscall.Handle, mode uint32) error {
+	r, _, e := procSetConsoleMode.Call(uintptr(handle), uintptr(mode))
+	if r == 0 {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,403 @@
+// Package tui 實作終端機介面（TUI），在同一個程序內直接使用 StateManager
+//
+// 畫面包含通道狀態格、連線狀態與捲動的訊息記錄；資料來源與網頁前端相同（StateManager 的廣播），
+// 以鍵盤對選取的通道發送命令。只使用 ANSI 控制序列，可在 SSH 連線的終端機中執行。
+package tui
+
+import (
+	"GoTestMES/core"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	eventBufferSize = 1024 // 等待處理的廣播事件上限（超過時改為重新讀取完整狀態）
+	maxLogEntries   = 1000 // 訊息記錄保留的筆數
+	frameInterval   = 100 * time.Millisecond
+)
+
+// Options TUI 選項
+type Options struct {
+	Title string     // 標題列文字
+	Logs  *LogWriter // 伺服器日誌（不為 nil 時執行期間顯示在訊息記錄中）
+}
+
+// logEntry 訊息記錄的一筆資料
+type logEntry struct {
+	at      time.Time
+	kind    string // message/channel_update/.../log
+	channel string
+	text    string
+}
+
+// prompt 底部的輸入列（START 參數、自訂命令）
+type prompt struct {
+	labels []string
+	values []string
+	step   int
+	input  []rune
+	submit func(values []string)
+}
+
+// UI 終端機介面
+type UI struct {
+	sm     *core.StateManager
+	opts   Options
+	events chan []byte
+	logs   chan string
+	resync atomic.Bool // 事件被丟棄，需要重新讀取完整狀態
+	now    func() time.Time
+
+	// 以下只在 Run 的事件迴圈中存取
+	channels  []core.ChannelState
+	status    map[string]interface{}
+	selected  int
+	log       []logEntry
+	logScroll int  // 由底部往上捲動的行數（0 表示跟隨最新訊息）
+	logFilter bool // 只顯示選取通道的訊息
+	prompt    *prompt
+	notice    string
+	noticeErr bool
+	showHelp  bool
+	lastStart core.Command // 上一次 START 的參數（作為下一次的預設值）
+	width     int
+	height    int
+	quit      bool
+}
+
+// New 建立終端機介面
+// 需將 Publish 加入 StateManager 的廣播函數才會收到即時更新
+func New(sm *core.StateManager, opts Options) *UI {
+	if opts.Title == "" {
+		opts.Title = "DYMesTest"
+	}
+	return &UI{
+		sm:     sm,
+		opts:   opts,
+		events: make(chan []byte, eventBufferSize),
+		logs:   make(chan string, 256),
+		now:    time.Now,
+		width:  80,
+		height: 24,
+	}
+}
+
+// Publish 接收 StateManager 的廣播（與 WebSocket 推送相同的資料）
+// 呼叫時 StateManager 持有鎖，因此只序列化後放入佇列，不可阻塞或回呼 StateManager
+func (u *UI) Publish(data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	select {
+	case u.events <- payload:
+	default:
+		u.resync.Store(true)
+	}
+}
+
+// Run 執行終端機介面，直到 ctx 結束或使用者按下 q / Ctrl+C
+// in 與 out 需為終端機（例如 os.Stdin、os.Stdout）
+func (u *UI) Run(ctx context.Context, in, out *os.File) error {
+	state, err := makeRaw(in)
+	if err != nil {
+		return fmt.Errorf("terminal UI requires an interactive terminal: %w", err)
+	}
+	defer restore(in, state)
+
+	restoreOutput, err := enableVirtualTerminal(out)
+	if err != nil {
+		return fmt.Errorf("terminal UI requires an interactive terminal: %w", err)
+	}
+	defer restoreOutput()
+
+	// 切換到替代畫面並隱藏游標，結束時還原
+	io.WriteString(out, "\x1b[?1049h\x1b[?25l")
+	defer io.WriteString(out, "\x1b[?25h\x1b[?1049l")
+
+	if u.opts.Logs != nil {
+		u.opts.Logs.redirect(u.captureLog)
+		defer u.opts.Logs.redirect(nil)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	keys := make(chan []byte)
+	// 讀取鍵盤的 goroutine 在結束後仍會停在 Read 上，程序隨後即結束
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := in.Read(buf)
+			if err != nil {
+				return
+			}
+			data := append([]byte(nil), buf[:n]...)
+			select {
+			case keys <- data:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	u.reload()
+	u.resize(out)
+	ticker := time.NewTicker(frameInterval)
+	defer ticker.Stop()
+
+	dirty := true
+	lastSecond := u.now().Unix()
+	for !u.quit {
+		if dirty {
+			io.WriteString(out, u.render())
+			dirty = false
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case data := <-keys:
+			for _, k := range parseKeys(data) {
+				u.handleKey(k)
+			}
+			dirty = true
+		case event := <-u.events:
+			u.applyEvent(event)
+			dirty = true
+		case line := <-u.logs:
+			u.appendLog(logEntry{at: u.now(), kind: "log", text: line})
+			dirty = true
+		case <-ticker.C:
+			if u.resize(out) {
+				dirty = true
+			}
+			if u.resync.Swap(false) {
+				u.reload()
+				dirty = true
+			}
+			// 標題列的時鐘每秒更新
+			if sec := u.now().Unix(); sec != lastSecond {
+				lastSecond = sec
+				dirty = true
+			}
+		}
+	}
+	return nil
+}
+
+// captureLog 接收伺服器日誌（LogWriter 持有鎖時呼叫，不可阻塞）
+// 文字格式日誌開頭的 time= 欄位與訊息記錄的時間重複，因此省略
+func (u *UI) captureLog(line string) {
+	if strings.HasPrefix(line, "time=") {
+		if _, rest, ok := strings.Cut(line, " "); ok {
+			line = rest
+		}
+	}
+	select {
+	case u.logs <- line:
+	default:
+	}
+}
+
+// resize 讀取終端機大小，有變更時回傳 true
+func (u *UI) resize(out *os.File) bool {
+	width, height, err := terminalSize(out)
+	if err != nil || width <= 0 || height <= 0 || (width == u.width && height == u.height) {
+		return false
+	}
+	u.width, u.height = width, height
+	return true
+}
+
+// reload 從 StateManager 重新讀取完整狀態（啟動時與事件被丟棄時）
+func (u *UI) reload() {
+	// 先清空佇列，之後的事件都比讀取到的狀態新
+	for {
+		select {
+		case <-u.events:
+			continue
+		default:
+		}
+		break
+	}
+	u.channels = u.sm.GetAllChannels()
+	u.status = u.sm.GetConnectionStatus()
+	u.clampSelection()
+}
+
+// applyEvent 套用一則廣播事件並寫入訊息記錄
+func (u *UI) applyEvent(event []byte) {
+	var fields struct {
+		Type     string                 `json:"type"`
+		Channels []core.ChannelState    `json:"channels"`
+		Status   map[string]interface{} `json:"status"`
+	}
+	json.Unmarshal(event, &fields)
+
+	switch fields.Type {
+	case core.EventChannelUpdate:
+		for _, ch := range fields.Channels {
+			u.updateChannel(ch)
+		}
+	case core.EventConnectionUpdate:
+		if fields.Status != nil {
+			u.status = fields.Status
+		}
+		// 通道數量變更（例如重新 LINK）時重新讀取
+		if n, ok := fields.Status["channel_count"].(float64); ok && int(n) != len(u.channels) {
+			u.resync.Store(true)
+		}
+	}
+
+	summary := core.SummarizeEvent(event)
+	u.appendLog(logEntry{at: u.now(), kind: summary.Kind, channel: summary.Channel, text: summary.Text})
+}
+
+// updateChannel 更新單一通道（未知的通道改為重新讀取完整狀態）
+func (u *UI) updateChannel(ch core.ChannelState) {
+	for i := range u.channels {
+		if u.channels[i].ChannelID == ch.ChannelID {
+			u.channels[i] = ch
+			return
+		}
+	}
+	u.resync.Store(true)
+}
+
+// appendLog 新增一筆訊息記錄
+func (u *UI) appendLog(entry logEntry) {
+	u.log = append(u.log, entry)
+	if len(u.log) > maxLogEntries {
+		u.log = append(u.log[:0], u.log[len(u.log)-maxLogEntries:]...)
+	}
+	// 往上捲動時保持畫面內容不動
+	if u.logScroll > 0 && u.logVisible(entry) {
+		u.logScroll++
+	}
+}
+
+// logVisible 判斷訊息是否符合目前的篩選條件
+func (u *UI) logVisible(entry logEntry) bool {
+	if !u.logFilter {
+		return true
+	}
+	ch, ok := u.selectedChannel()
+	return ok && entry.channel == ch.ChannelID
+}
+
+// selectedChannel 取得選取的通道
+func (u *UI) selectedChannel() (core.ChannelState, bool) {
+	if u.selected < 0 || u.selected >= len(u.channels) {
+		return core.ChannelState{}, false
+	}
+	return u.channels[u.selected], true
+}
+
+// clampSelection 確保選取位置在通道範圍內
+func (u *UI) clampSelection() {
+	if u.selected >= len(u.channels) {
+		u.selected = len(u.channels) - 1
+	}
+	if u.selected < 0 {
+		u.selected = 0
+	}
+}
+
+// setNotice 設定底部的提示訊息
+func (u *UI) setNotice(isErr bool, format string, args ...interface{}) {
+	u.notice = fmt.Sprintf(format, args...)
+	u.noticeErr = isErr
+}
+
+// sendCommand 對選取的通道發送命令
+func (u *UI) sendCommand(cmd core.Command) {
+	msgID, err := u.sm.SendCommand(cmd)
+	if err != nil {
+		u.setNotice(true, "%s %s: %v", cmd.Type, cmd.Channel, err)
+		return
+	}
+	if cmd.Channel == "" {
+		u.setNotice(false, "%s sent (%s)", cmd.Type, msgID)
+		return
+	}
+	u.setNotice(false, "%s sent to %s (%s)", cmd.Type, cmd.Channel, msgID)
+}
+
+// startPrompt 詢問 START 參數後發送（預設值取自通道目前的值或上一次 START）
+func (u *UI) startPrompt(ch core.ChannelState) {
+	defaults := []string{
+		firstNonEmpty(ch.Barcode, u.lastStart.Barcode),
+		firstNonEmpty(ch.Process, u.lastStart.Process),
+		firstNonEmpty(ch.DataPath, u.lastStart.DataPath),
+	}
+	u.openPrompt([]string{"Barcode", "Process", "Data path"}, defaults, func(values []string) {
+		cmd := core.Command{Type: core.CmdStart, Channel: ch.ChannelID, Barcode: values[0], Process: values[1], DataPath: values[2]}
+		u.lastStart = cmd
+		u.sendCommand(cmd)
+	})
+}
+
+// userPrompt 詢問自訂命令（TYPE key=value ...）後發送
+func (u *UI) userPrompt(ch core.ChannelState) {
+	u.openPrompt([]string{"User command (TYPE key=value ...)"}, []string{""}, func(values []string) {
+		payload, err := parseUserCommand(values[0], ch.ChannelID)
+		if err != nil {
+			u.setNotice(true, "%v", err)
+			return
+		}
+		sent, _, err := u.sm.SendUserCommandPayload(payload, 0)
+		if err != nil {
+			u.setNotice(true, "%s: %v", payload["type"], err)
+			return
+		}
+		u.setNotice(false, "%s sent (%v)", sent["type"], sent["msg_id"])
+	})
+}
+
+// openPrompt 開啟輸入列
+func (u *UI) openPrompt(labels, defaults []string, submit func(values []string)) {
+	u.prompt = &prompt{
+		labels: labels,
+		values: defaults,
+		input:  []rune(defaults[0]),
+		submit: submit,
+	}
+	u.notice = ""
+}
+
+// parseUserCommand 解析 "TYPE key=value ..."；未指定 channel 時不帶入（由使用者決定是否需要）
+// 值為 {channel} 時代入選取的通道
+func parseUserCommand(line, channel string) (map[string]interface{}, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("user command: type is required")
+	}
+	payload := map[string]interface{}{"type": strings.ToUpper(fields[0])}
+	for _, field := range fields[1:] {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("user command: expected key=value, got %q", field)
+		}
+		if v == "{channel}" {
+			v = channel
+		}
+		payload[k] = v
+	}
+	return payload, nil
+}
+
+// firstNonEmpty 回傳第一個非空字串
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
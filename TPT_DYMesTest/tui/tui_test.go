@@ -0,0 +1,344 @@
+package tui
+
+import (
+	"GoTestMES/core"
+	"GoTestMES/models"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	core.SetupLogging(core.LogConfig{Level: "error"})
+	os.Exit(m.Run())
+}
+
+// sentCommands 記錄 StateManager 發送給 TPT 的命令
+type sentCommands struct {
+	mu   sync.Mutex
+	msgs []map[string]interface{}
+}
+
+func (s *sentCommands) send(data interface{}) error {
+	raw, _ := json.Marshal(data)
+	var m map[string]interface{}
+	json.Unmarshal(raw, &m)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.msgs = append(s.msgs, m)
+	return nil
+}
+
+func (s *sentCommands) last(t *testing.T) map[string]interface{} {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.msgs) == 0 {
+		t.Fatal("no command was sent")
+	}
+	return s.msgs[len(s.msgs)-1]
+}
+
+// handle 將 TPT 訊息交給 StateManager
+func handle(t *testing.T, sm *core.StateManager, msg map[string]interface{}) {
+	t.Helper()
+	raw, _ := json.Marshal(msg)
+	if _, err := sm.HandleMessage(raw); err != nil {
+		t.Fatalf("HandleMessage(%s): %v", raw, err)
+	}
+}
+
+// newTestUI 建立已完成 LINK（4 個 StandBy 通道）的 StateManager 與 TUI
+func newTestUI(t *testing.T) (*UI, *core.StateManager, *sentCommands) {
+	t.Helper()
+	sm := core.NewStateManager(4)
+	sent := &sentCommands{}
+	sm.SetSendToTPTFunc(sent.send)
+	ui := New(sm, Options{})
+	sm.SetBroadcastFunc(ui.Publish)
+	ui.now = func() time.Time { return time.Date(2025, 1, 2, 3, 4, 5, 0, time.Local) }
+
+	handle(t, sm, map[string]interface{}{
+		"type": "LINK", "msg_id": "L1", "work_station_name": "WS1", "state": "Online-Auto", "channel_count": "4",
+	})
+	channels := make([]map[string]string, 4)
+	for i := range channels {
+		channels[i] = map[string]string{"ch": fmt.Sprintf("%03d", i+1), "state": models.StateStandBy}
+	}
+	handle(t, sm, map[string]interface{}{
+		"type": "STATUS_ALL", "msg_id": "SA1", "work_station_name": "WS1", "channels": channels,
+	})
+	ui.reload()
+	return ui, sm, sent
+}
+
+// drain 套用佇列中所有的廣播事件（取代 Run 的事件迴圈）
+func drain(ui *UI) {
+	for {
+		select {
+		case event := <-ui.events:
+			ui.applyEvent(event)
+		default:
+			if ui.resync.Swap(false) {
+				ui.reload()
+			}
+			return
+		}
+	}
+}
+
+// typeKeys 依序送出按鍵（一般字元以字串表示）
+func typeKeys(ui *UI, input string) {
+	for _, k := range parseKeys([]byte(input)) {
+		ui.handleKey(k)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []key
+	}{
+		{"s", []key{{code: keyRune, r: 's'}}},
+		{"條碼", []key{{code: keyRune, r: '條'}, {code: keyRune, r: '碼'}}},
+		{"\r", []key{{code: keyEnter}}},
+		{"\x1b", []key{{code: keyEsc}}},
+		{"\x7f\x08", []key{{code: keyBackspace}, {code: keyBackspace}}},
+		{"\x1b[A\x1bOB\x1b[C\x1b[D", []key{{code: keyUp}, {code: keyDown}, {code: keyRight}, {code: keyLeft}}},
+		{"\x1b[5~\x1b[6~\x1b[H\x1b[4~", []key{{code: keyPgUp}, {code: keyPgDn}, {code: keyHome}, {code: keyEnd}}},
+		{"\x03\x0c", []key{{code: keyCtrlC}, {code: keyCtrlL}}},
+		{"\x1b[15~x", []key{{code: keyRune, r: 'x'}}}, // 無法辨識的序列（F5）略過
+	}
+	for _, tt := range tests {
+		got := parseKeys([]byte(tt.input))
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseKeys(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestEventsUpdateState(t *testing.T) {
+	ui, sm, _ := newTestUI(t)
+	drain(ui)
+
+	if len(ui.channels) != 4 || ui.channels[0].State != models.StateStandBy {
+		t.Fatalf("channels = %+v", ui.channels)
+	}
+	if linked, _ := ui.status["tpt_connected"].(bool); !linked {
+		t.Fatalf("status = %v", ui.status)
+	}
+
+	handle(t, sm, map[string]interface{}{
+		"type": "STATUS", "msg_id": "S1", "work_station_name": "WS1", "channel": "CH003", "state": models.StateAlarm, "message": "OVP",
+	})
+	drain(ui)
+	if ch := ui.channels[2]; ch.State != models.StateAlarm || ch.Message != "OVP" {
+		t.Fatalf("CH003 = %+v", ch)
+	}
+
+	// 訊息記錄包含 TPT 訊息與結構化事件
+	var texts []string
+	for _, entry := range ui.log {
+		texts = append(texts, entry.text)
+	}
+	joined := strings.Join(texts, "\n")
+	for _, want := range []string{"TPT->MES STATUS CH003", "channel_update CH003 Alarm message=OVP"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("log does not contain %q:\n%s", want, joined)
+		}
+	}
+
+	// 篩選選取的通道
+	ui.selected = 2
+	ui.logFilter = true
+	for _, entry := range ui.log {
+		if ui.logVisible(entry) && entry.channel != "CH003" {
+			t.Errorf("filtered log contains %+v", entry)
+		}
+	}
+}
+
+func TestPublishOverflowResyncs(t *testing.T) {
+	ui, sm, _ := newTestUI(t)
+	for i := 0; i < eventBufferSize+10; i++ {
+		ui.Publish(map[string]interface{}{"type": "noise"})
+	}
+	if !ui.resync.Load() {
+		t.Fatal("resync flag not set after overflow")
+	}
+
+	handle(t, sm, map[string]interface{}{
+		"type": "STATUS", "msg_id": "S1", "work_station_name": "WS1", "channel": "CH001", "state": models.StateRunning,
+	})
+	drain(ui)
+	if ui.channels[0].State != models.StateRunning {
+		t.Fatalf("CH001 = %+v, want Running after resync", ui.channels[0])
+	}
+}
+
+func TestKeyCommands(t *testing.T) {
+	ui, _, sent := newTestUI(t)
+
+	// 選取 CH002 後以輸入列發送 START
+	typeKeys(ui, "l")
+	typeKeys(ui, "s")
+	if ui.prompt == nil {
+		t.Fatal("START prompt not opened")
+	}
+	typeKeys(ui, "BC01\rCYCLE\rD:\\data\r")
+	if ui.prompt != nil {
+		t.Fatal("prompt still open")
+	}
+	msg := sent.last(t)
+	if msg["type"] != core.CmdStart || msg["channel"] != "CH002" || msg["barcode"] != "BC01" || msg["process"] != "CYCLE" || msg["data_path"] != `D:\data` {
+		t.Fatalf("sent = %v", msg)
+	}
+	if ui.noticeErr || !strings.Contains(ui.notice, "START sent to CH002") {
+		t.Fatalf("notice = %q", ui.notice)
+	}
+
+	// 下一次 START 預設使用上一次的參數，Backspace 可修改
+	typeKeys(ui, "l")
+	typeKeys(ui, "s\x7f2\r\r\r")
+	if msg := sent.last(t); msg["channel"] != "CH003" || msg["barcode"] != "BC02" || msg["process"] != "CYCLE" {
+		t.Fatalf("sent = %v", msg)
+	}
+
+	// Esc 取消
+	n := len(sent.msgs)
+	typeKeys(ui, "s")
+	typeKeys(ui, "\x1b")
+	if ui.prompt != nil || len(sent.msgs) != n {
+		t.Fatal("Esc did not cancel the prompt")
+	}
+
+	for _, tt := range []struct {
+		keys, want string
+	}{
+		{"x", core.CmdStop},
+		{"p", core.CmdPause},
+		{"r", core.CmdResume},
+	} {
+		typeKeys(ui, tt.keys)
+		if msg := sent.last(t); msg["type"] != tt.want || msg["channel"] != "CH003" {
+			t.Errorf("%s: sent = %v, want %s CH003", tt.keys, msg, tt.want)
+		}
+	}
+
+	typeKeys(ui, "R")
+	if msg := sent.last(t); msg["type"] != core.CmdRspStatus {
+		t.Errorf("R: sent = %v", msg)
+	}
+
+	// 自訂命令
+	typeKeys(ui, "u")
+	typeKeys(ui, "ping target={channel} note=hi\r")
+	if msg := sent.last(t); msg["type"] != "PING" || msg["target"] != "CH003" || msg["note"] != "hi" {
+		t.Errorf("u: sent = %v", msg)
+	}
+	typeKeys(ui, "u")
+	typeKeys(ui, "PING bad\r")
+	if !ui.noticeErr {
+		t.Errorf("notice = %q, want error", ui.notice)
+	}
+
+	// 驗證失敗時顯示錯誤
+	typeKeys(ui, "s\x1b")
+	ui.lastStart = core.Command{}
+	typeKeys(ui, "G")
+	typeKeys(ui, "s\r\r\r")
+	if !ui.noticeErr || !strings.Contains(ui.notice, "START CH004") {
+		t.Fatalf("notice = %q, want START error", ui.notice)
+	}
+
+	typeKeys(ui, "q")
+	if !ui.quit {
+		t.Fatal("q did not quit")
+	}
+}
+
+func TestRender(t *testing.T) {
+	ui, sm, _ := newTestUI(t)
+	handle(t, sm, map[string]interface{}{
+		"type": "STATUS", "msg_id": "S1", "work_station_name": "WS1", "channel": "CH002", "state": models.StateRunning, "barcode": "BC01",
+	})
+	drain(ui)
+	ui.width, ui.height = 80, 24
+	ui.selected = 1
+
+	screen := ui.render()
+	for _, want := range []string{
+		"TCP disconnected", "TPT linked Online-Auto", "WS1 (4 ch)", "03:04:05",
+		stateStyle(models.StateStandBy) + " CH001 StandBy  ",
+		stateStyle(models.StateRunning) + sgrBold + ">CH002 Running <",
+		"barcode=BC01",
+		"Messages (all)",
+		"TPT->MES STATUS CH002",
+		"s start",
+	} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("screen does not contain %q", want)
+		}
+	}
+	if rows := strings.Count(screen, "\x1b[K"); rows != ui.height {
+		t.Errorf("rendered %d rows, want %d", rows, ui.height)
+	}
+
+	// 窄畫面與說明畫面不超出寬度
+	ui.width, ui.height = 20, 6
+	for _, help := range []bool{false, true} {
+		ui.showHelp = help
+		for _, line := range strings.Split(ui.render(), "\x1b[K") {
+			if w := displayWidth(line); w > ui.width {
+				t.Errorf("help=%v: line width %d > %d: %q", help, w, ui.width, line)
+			}
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		input string
+		width int
+		want  string
+	}{
+		{"abcdef", 3, "abc"},
+		{"條碼ABC", 5, "條碼A"},
+		{"條碼ABC", 3, "條"},
+		{sgrRed + "abc" + sgrReset, 2, sgrRed + "ab" + sgrReset},
+		{"a\tb", 3, "a b"},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.input, tt.width); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.input, tt.width, got, tt.want)
+		}
+	}
+	if got := padRight("條", 4); got != "條  " {
+		t.Errorf("padRight = %q", got)
+	}
+}
+
+func TestLogWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := NewLogWriter(&out)
+	fmt.Fprintln(w, "before")
+
+	var captured []string
+	w.redirect(func(line string) { captured = append(captured, line) })
+	fmt.Fprint(w, "one\r\ntw")
+	fmt.Fprint(w, "o\n\nthree")
+	w.redirect(nil)
+	fmt.Fprintln(w, "after")
+
+	if got := strings.Join(captured, "|"); got != "one|two" {
+		t.Errorf("captured = %q", got)
+	}
+	if got := out.String(); got != "before\nthree\nafter\n" {
+		t.Errorf("output = %q", got)
+	}
+}